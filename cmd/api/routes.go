@@ -4,13 +4,16 @@ import (
 	"auth/infrastructure"
 	"auth/internal/controller"
 	"auth/internal/domain"
+	"auth/internal/usecase"
+	"auth/internal/utils"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"strings"
 
-	"github.com/golang-jwt/jwt"
 	"github.com/labstack/echo/v4"
 	"golang.org/x/exp/slices"
 )
@@ -24,92 +27,334 @@ type errorresponse struct {
 func Routes(
 	router *echo.Echo,
 	UserController controller.UserController,
+	MfaController controller.MfaController,
+	WebauthnController controller.WebauthnController,
+	OAuthController controller.OAuthController,
+	UpstreamController controller.UpstreamController,
+	ApiKeyController controller.ApiKeyController,
+	SigningKeyController controller.SigningKeyController,
+	ServiceAccountController controller.ServiceAccountController,
+	UserAttributeController controller.UserAttributeController,
+	OAuthClientController controller.OAuthClientController,
+	ApiKeyUsecase usecase.ApiKeyUseCase,
+	UserUsecase usecase.UserUseCase,
 ) {
 
 	router.POST("/register", UserController.Register)
 	router.POST("/login", UserController.Login)
+	router.POST("/login/mfa", MfaController.LoginMfa)
+	router.POST("/login/magic-link", UserController.RequestMagicLink)
+	router.POST("/login/magic-link/consume", UserController.ConsumeMagicLink)
+	router.POST("/login/webauthn/begin", WebauthnController.BeginLogin)
+	router.POST("/login/webauthn/finish", WebauthnController.FinishLogin)
+	router.GET("/login/upstream/:provider", UpstreamController.Begin)
+	router.GET("/login/upstream/:provider/callback", UpstreamController.Callback)
+	router.POST("/token/refresh", UserController.RefreshToken)
+	router.GET("/authorize", OAuthController.Authorize)
+	router.POST("/authorize", OAuthController.Authorize)
+	router.POST("/token", OAuthController.Token)
+	router.POST("/introspect", OAuthController.Introspect)
+	router.POST("/revoke", OAuthController.Revoke)
+	router.POST("/device/code", OAuthController.DeviceCode)
+	router.GET("/.well-known/openid-configuration", OAuthController.OpenIDConfiguration)
+	router.GET("/.well-known/jwks.json", OAuthController.JWKS)
+	router.POST("/verify-email", UserController.VerifyEmail)
+	router.POST("/verify-email/resend", UserController.ResendVerification)
+	router.POST("/password/forgot", UserController.ForgotPassword)
+	router.POST("/password/reset", UserController.ResetPassword)
 
-	router.Use(authMiddleware)
-	router.GET("/profile", UserController.Profile)
-	router.POST("/logout", UserController.Logout)
+	router.Use(authMiddleware(ApiKeyUsecase, UserUsecase))
+	router.GET("/profile", UserController.Profile, requireScope("profile", "email"))
+	router.POST("/logout", UserController.Logout, sessionOnly)
+	router.POST("/logout/all", UserController.LogoutAll, sensitiveAction)
+	router.POST("/password/change", UserController.ChangePassword, sensitiveAction)
+	router.POST("/mfa/totp/enroll", MfaController.EnrollTOTP, sensitiveAction)
+	router.POST("/mfa/totp/confirm", MfaController.ConfirmTOTP, sensitiveAction)
+	router.POST("/mfa/totp/disable", MfaController.DisableTOTP, sensitiveAction)
+	router.POST("/webauthn/register/begin", WebauthnController.BeginRegistration, sensitiveAction)
+	router.POST("/webauthn/register/finish", WebauthnController.FinishRegistration, sensitiveAction)
+	router.GET("/userinfo", OAuthController.UserInfo)
+	router.POST("/userinfo", OAuthController.UserInfo)
+	router.GET("/device", OAuthController.DeviceVerification, sensitiveAction)
+	router.POST("/device", OAuthController.VerifyDevice, sensitiveAction)
+	router.POST("/api-keys", ApiKeyController.Create, sensitiveAction)
+	router.GET("/api-keys", ApiKeyController.List, sensitiveAction)
+	router.DELETE("/api-keys/:id", ApiKeyController.Revoke, sensitiveAction)
+	router.GET("/sessions", UserController.Sessions, sensitiveAction)
+	router.DELETE("/sessions/:id", UserController.RevokeSession, sensitiveAction)
+	router.POST("/admin/impersonate/:userID", UserController.Impersonate, sensitiveAction)
+	router.POST("/admin/users/:id/sessions/revoke", UserController.AdminLogoutAll, sensitiveAction)
+	router.POST("/admin/signing-keys/rotate", SigningKeyController.Rotate, sensitiveAction)
+	router.POST("/admin/service-accounts", ServiceAccountController.Create, sensitiveAction)
+	router.POST("/admin/oauth-clients", OAuthClientController.Create, sensitiveAction)
+	router.GET("/admin/users/:id/attributes", UserAttributeController.List, sensitiveAction)
+	router.PUT("/admin/users/:id/attributes/:name", UserAttributeController.Set, sensitiveAction)
+	router.DELETE("/admin/users/:id/attributes/:name", UserAttributeController.Delete, sensitiveAction)
 
 }
 
-func authMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+// sessionOnly keeps API keys and tokens issued to OAuth clients away from
+// routes that need the user's own session
+func sessionOnly(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		whitelistUrl := []string{"/login", "/register"}
-		redisConn := infrastructure.OpenRedis()
+		if c.Get("api_key") != nil {
+			response := errorresponse{
+				Message: "API key tidak dapat digunakan untuk aksi ini",
+			}
+
+			return c.JSON(http.StatusForbidden, response)
+		}
+
+		if clientID, _ := c.Get("client_id").(string); clientID != "" {
+			response := errorresponse{
+				Message: "Token client tidak dapat digunakan untuk aksi ini",
+			}
+
+			return c.JSON(http.StatusForbidden, response)
+		}
+
+		return next(c)
+	}
+}
+
+// requireScope limits API keys and tokens issued to OAuth clients to the
+// routes their scope covers. The user's own sessions are not limited.
+func requireScope(scopes ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			clientID, _ := c.Get("client_id").(string)
+
+			if c.Get("api_key") == nil && clientID == "" {
+				return next(c)
+			}
+
+			granted, _ := c.Get("scope").(string)
+
+			for _, scope := range scopes {
+				if !slices.Contains(strings.Fields(granted), scope) {
+					c.Response().Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, strings.Join(scopes, " ")))
 
-		defer redisConn.Close()
+					response := errorresponse{
+						Message: "Scope tidak mencukupi untuk aksi ini",
+					}
 
-		ctx := c.Request().Context()
+					return c.JSON(http.StatusForbidden, response)
+				}
+			}
 
-		// Register public route
-		if slices.Contains(whitelistUrl, c.Request().RequestURI) {
 			return next(c)
 		}
+	}
+}
 
-		// Check header Authorization is empty
-		if c.Request().Header.Get("Authorization") == "" {
+// sensitiveAction guards routes that manage credentials or hand out access.
+// Neither a leaked API key nor an admin impersonating the user may use them.
+func sensitiveAction(next echo.HandlerFunc) echo.HandlerFunc {
+	return sessionOnly(func(c echo.Context) error {
+		if actor, _ := c.Get("act").(string); actor != "" {
 			response := errorresponse{
-				Message: "Missing JWT",
+				Message: "Aksi ini tidak dapat dilakukan saat impersonasi",
 			}
 
-			return c.JSON(http.StatusUnauthorized, response)
+			return c.JSON(http.StatusForbidden, response)
 		}
 
-		// Get value Header Authorization
-		token := c.Request().Header.Get("Authorization")
+		if unchecked, _ := c.Get("revocation_unchecked").(bool); unchecked {
+			return revocationUnavailable(c)
+		}
 
-		// Check value Header contain bearer
-		if !strings.Contains(token, "Bearer ") {
-			response := errorresponse{
-				Message: "Missing JWT",
+		return next(c)
+	})
+}
+
+// revocationUnavailable refuses a token that could not be checked against
+// the denylist
+func revocationUnavailable(c echo.Context) error {
+	response := errorresponse{
+		Message: "Sesi tidak dapat diperiksa, coba lagi nanti",
+	}
+
+	return c.JSON(http.StatusServiceUnavailable, response)
+}
+
+func authMiddleware(ApiKeyUsecase usecase.ApiKeyUseCase, UserUsecase usecase.UserUseCase) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			whitelistUrl := []string{
+				"/login",
+				"/login/mfa",
+				"/login/magic-link",
+				"/login/magic-link/consume",
+				"/login/webauthn/begin",
+				"/login/webauthn/finish",
+				"/register",
+				"/token/refresh",
+				"/authorize",
+				"/token",
+				"/introspect",
+				"/revoke",
+				"/device/code",
+				"/.well-known/openid-configuration",
+				"/.well-known/jwks.json",
+				"/verify-email",
+				"/verify-email/resend",
+				"/password/forgot",
+				"/password/reset",
 			}
+			// Public routes with path parameters
+			whitelistPrefix := []string{
+				"/login/upstream/",
+			}
+			redisConn := infrastructure.OpenRedis()
 
-			return c.JSON(http.StatusUnauthorized, response)
-		}
+			defer redisConn.Close()
 
-		// Delete bearer and left only token
-		tokenFix := strings.Replace(token, "Bearer ", "", 1)
+			ctx := c.Request().Context()
 
-		// Parse token & verify secret
-		claims := jwt.MapClaims{}
-		tokenParse, errFix := jwt.ParseWithClaims(tokenFix, claims, func(token *jwt.Token) (interface{}, error) {
-			return []byte(os.Getenv("JWT_KEY")), nil
-		})
+			// Register public route
+			if slices.Contains(whitelistUrl, c.Request().URL.Path) {
+				return next(c)
+			}
+			for _, prefix := range whitelistPrefix {
+				if strings.HasPrefix(c.Request().URL.Path, prefix) {
+					return next(c)
+				}
+			}
 
-		// Return if cant parse and validation
-		if errFix != nil {
-			response := errorresponse{
-				Message: "Missing JWT",
+			// Check header Authorization is empty
+			if c.Request().Header.Get("Authorization") == "" {
+				response := errorresponse{
+					Message: "Missing JWT",
+				}
+
+				return c.JSON(http.StatusUnauthorized, response)
 			}
 
-			return c.JSON(http.StatusUnauthorized, response)
-		}
+			// Get value Header Authorization
+			token := c.Request().Header.Get("Authorization")
+
+			// Check value Header contain bearer
+			if !strings.Contains(token, "Bearer ") {
+				response := errorresponse{
+					Message: "Missing JWT",
+				}
+
+				return c.JSON(http.StatusUnauthorized, response)
+			}
+
+			// Delete bearer and left only token
+			tokenFix := strings.Replace(token, "Bearer ", "", 1)
 
-		// Convert parse to claim
-		claim := tokenParse.Claims.(jwt.MapClaims)
-		// convert from interface to string
-		uuid := fmt.Sprintf("%v", claim["uuid"])
+			// API keys are looked up instead of parsed
+			if strings.HasPrefix(tokenFix, domain.ApiKeyPrefix) {
+				user, apiKey, err := ApiKeyUsecase.Authenticate(ctx, tokenFix, c.RealIP())
+
+				if err != nil {
+					response := errorresponse{
+						Message: "Invalid API key",
+					}
+
+					return c.JSON(http.StatusUnauthorized, response)
+				}
+
+				// set to context, there is no session behind a key
+				c.Set("user", *user)
+				c.Set("uuid", "")
+				c.Set("scope", strings.Join(apiKey.Scopes, " "))
+				c.Set("client_id", "")
+				c.Set("act", "")
+				c.Set("api_key", apiKey.ID)
+				return next(c)
+			}
 
-		resUuid, _ := redisConn.Get(ctx, uuid).Result()
+			// Parse token & verify signature and claims
+			claims, err := utils.ParseToken(tokenFix)
+
+			if err != nil {
+				return unauthorized(c, err)
+			}
 
-		// check uuid if uuid exist pass it
-		if resUuid != "" {
-			// Parse return to struct
 			user := domain.User{}
-			json.Unmarshal([]byte(resUuid), &user)
+
+			if utils.StatelessSessions() {
+				// Tokens signed before the switch carry no user
+				if claims.User == nil {
+					return unauthorized(c, utils.ErrTokenInvalid)
+				}
+
+				// The denylist fails open unless JWT_DENYLIST_FAIL_CLOSED is
+				// set, Redis being away should not sign everybody out.
+				// Sensitive actions always fail closed.
+				revoked, err := redisConn.Exists(ctx, domain.RevokedTokenKey(claims.ID)).Result()
+
+				if err != nil {
+					log.Printf("Checking the jti denylist failed: %s", err)
+
+					if os.Getenv("JWT_DENYLIST_FAIL_CLOSED") == "true" {
+						return revocationUnavailable(c)
+					}
+
+					c.Set("revocation_unchecked", true)
+				} else if revoked > 0 {
+					return unauthorized(c, utils.ErrTokenRevoked)
+				}
+
+				user = *claims.User
+			} else {
+				resUuid, _ := redisConn.Get(ctx, claims.Uuid).Result()
+
+				// A valid token whose session is gone was logged out
+				if resUuid == "" {
+					return unauthorized(c, utils.ErrTokenRevoked)
+				}
+
+				// Parse return to struct
+				json.Unmarshal([]byte(resUuid), &user)
+			}
+
 			// set to context
 			c.Set("user", user)
-			c.Set("uuid", uuid)
-			return next(c)
-		} else {
-			response := errorresponse{
-				Message: "Missing JWT",
+			c.Set("uuid", claims.Uuid)
+			// Only set for tokens issued to OAuth clients
+			c.Set("scope", claims.Scope)
+			c.Set("client_id", claims.ClientID)
+			// Only set when an admin is impersonating the user
+			actor := ""
+			if claims.Act != nil {
+				actor = claims.Act.Subject
 			}
-
-			return c.JSON(http.StatusUnauthorized, response)
+			c.Set("act", actor)
+			// Keep the session list current, stateless sessions are not
+			// looked up in Redis and are not touched either
+			if !utils.StatelessSessions() {
+				UserUsecase.TouchSession(ctx, user.ID, claims.Uuid, c.RealIP())
+			}
+			return next(c)
 		}
 	}
 }
+
+// unauthorized tells the client why its token was refused, see RFC 6750
+// section 3
+func unauthorized(c echo.Context, err error) error {
+	message := "Invalid JWT"
+
+	switch {
+	case errors.Is(err, utils.ErrTokenMalformed):
+		message = "Malformed JWT"
+	case errors.Is(err, utils.ErrTokenExpired):
+		message = "Expired JWT"
+	case errors.Is(err, utils.ErrTokenNotValidYet):
+		message = "JWT not valid yet"
+	case errors.Is(err, utils.ErrTokenRevoked):
+		message = "Revoked JWT"
+	}
+
+	c.Response().Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="invalid_token", error_description=%q`, message))
+
+	response := errorresponse{
+		Message: message,
+	}
+
+	return c.JSON(http.StatusUnauthorized, response)
+}
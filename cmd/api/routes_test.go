@@ -0,0 +1,87 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// serveWith runs a handler behind a middleware for a request authenticated
+// with the given context values
+func serveWith(middleware echo.MiddlewareFunc, values map[string]any) int {
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+
+	for key, value := range values {
+		c.Set(key, value)
+	}
+
+	middleware(func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})(c)
+
+	return rec.Code
+}
+
+func TestRequireScopeLimitsApiKeys(t *testing.T) {
+	readOnly := map[string]any{"api_key": 1, "client_id": "", "scope": "openid"}
+
+	if code := serveWith(requireScope("profile", "email"), readOnly); code != http.StatusForbidden {
+		t.Errorf("expected a key without the scope to be refused, got %d", code)
+	}
+
+	full := map[string]any{"api_key": 1, "client_id": "", "scope": "openid profile email"}
+
+	if code := serveWith(requireScope("profile", "email"), full); code != http.StatusOK {
+		t.Errorf("expected a key with the scope to pass, got %d", code)
+	}
+}
+
+func TestRequireScopeLimitsClientTokens(t *testing.T) {
+	client := map[string]any{"client_id": "app", "scope": "openid"}
+
+	if code := serveWith(requireScope("profile"), client); code != http.StatusForbidden {
+		t.Errorf("expected a client token without the scope to be refused, got %d", code)
+	}
+}
+
+func TestRequireScopeLeavesSessionsAlone(t *testing.T) {
+	session := map[string]any{"client_id": "", "scope": ""}
+
+	if code := serveWith(requireScope("profile"), session); code != http.StatusOK {
+		t.Errorf("expected a session to pass, got %d", code)
+	}
+}
+
+func TestSessionOnlyRefusesKeysAndClients(t *testing.T) {
+	guard := echo.MiddlewareFunc(sessionOnly)
+
+	if code := serveWith(guard, map[string]any{"api_key": 1, "client_id": ""}); code != http.StatusForbidden {
+		t.Errorf("expected an API key to be refused, got %d", code)
+	}
+
+	if code := serveWith(guard, map[string]any{"client_id": "app"}); code != http.StatusForbidden {
+		t.Errorf("expected a client token to be refused, got %d", code)
+	}
+
+	if code := serveWith(guard, map[string]any{"client_id": ""}); code != http.StatusOK {
+		t.Errorf("expected a session to pass, got %d", code)
+	}
+}
+
+func TestSensitiveActionNeedsACheckedToken(t *testing.T) {
+	guard := echo.MiddlewareFunc(sensitiveAction)
+
+	unchecked := map[string]any{"client_id": "", "act": "", "revocation_unchecked": true}
+
+	if code := serveWith(guard, unchecked); code != http.StatusServiceUnavailable {
+		t.Errorf("expected a token the denylist could not check to be refused, got %d", code)
+	}
+
+	if code := serveWith(guard, map[string]any{"client_id": "", "act": ""}); code != http.StatusOK {
+		t.Errorf("expected a checked token to pass, got %d", code)
+	}
+}
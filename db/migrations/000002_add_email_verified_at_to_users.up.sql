@@ -0,0 +1,5 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at timestamp;
+
+-- Accounts from before verification existed count as verified, turning
+-- REQUIRE_EMAIL_VERIFICATION on must not lock them out
+UPDATE users SET email_verified_at = now() WHERE email_verified_at IS NULL;
\ No newline at end of file
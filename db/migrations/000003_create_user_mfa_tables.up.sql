@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS user_totp (
+	user_id integer PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+	secret varchar NOT NULL,
+	confirmed_at timestamp,
+	created_at timestamp NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+	id serial PRIMARY KEY,
+	user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	code_hash varchar NOT NULL,
+	used_at timestamp
+);
+
+CREATE INDEX IF NOT EXISTS user_recovery_codes_user_id_idx ON user_recovery_codes (user_id);
\ No newline at end of file
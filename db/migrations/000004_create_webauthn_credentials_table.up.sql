@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+	id serial PRIMARY KEY,
+	user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	name varchar,
+	credential_id bytea NOT NULL,
+	public_key bytea NOT NULL,
+	aaguid bytea,
+	sign_count bigint NOT NULL DEFAULT 0,
+	created_at timestamp NOT NULL DEFAULT now(),
+	last_used_at timestamp,
+	CONSTRAINT credential_id_unique UNIQUE (credential_id)
+);
+
+CREATE INDEX IF NOT EXISTS webauthn_credentials_user_id_idx ON webauthn_credentials (user_id);
\ No newline at end of file
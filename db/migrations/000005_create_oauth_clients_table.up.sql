@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS oauth_clients (
+	id serial PRIMARY KEY,
+	client_id varchar NOT NULL,
+	name varchar,
+	secret_hash varchar,
+	redirect_uris text[] NOT NULL DEFAULT '{}',
+	allowed_scopes text[] NOT NULL DEFAULT '{}',
+	grant_types text[] NOT NULL DEFAULT '{authorization_code,refresh_token}',
+	created_at timestamp NOT NULL DEFAULT now(),
+	CONSTRAINT client_id_unique UNIQUE (client_id)
+);
\ No newline at end of file
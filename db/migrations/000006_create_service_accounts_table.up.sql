@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS service_accounts (
+	id serial PRIMARY KEY,
+	client_id varchar NOT NULL,
+	name varchar,
+	secret_hash varchar NOT NULL,
+	allowed_scopes text[] NOT NULL DEFAULT '{}',
+	audiences text[] NOT NULL DEFAULT '{}',
+	created_at timestamp NOT NULL DEFAULT now(),
+	CONSTRAINT service_account_client_id_unique UNIQUE (client_id)
+);
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS upstream_identities;
\ No newline at end of file
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS upstream_identities (
+	id serial PRIMARY KEY,
+	user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	provider varchar NOT NULL,
+	subject varchar NOT NULL,
+	created_at timestamp NOT NULL DEFAULT now(),
+	CONSTRAINT provider_subject_unique UNIQUE (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS upstream_identities_user_id_idx ON upstream_identities (user_id);
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN IF EXISTS groups;
+ALTER TABLE users DROP COLUMN IF EXISTS auth_source;
\ No newline at end of file
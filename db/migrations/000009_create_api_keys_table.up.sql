@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+	id serial PRIMARY KEY,
+	user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	name varchar NOT NULL,
+	prefix varchar NOT NULL,
+	key_hash varchar NOT NULL,
+	scopes text[] NOT NULL DEFAULT '{}',
+	expires_at timestamp,
+	last_used_at timestamp,
+	last_used_ip varchar,
+	created_at timestamp NOT NULL DEFAULT now(),
+	CONSTRAINT key_hash_unique UNIQUE (key_hash)
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id);
\ No newline at end of file
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS signing_keys (
+	id varchar PRIMARY KEY,
+	algorithm varchar NOT NULL,
+	private_key bytea NOT NULL,
+	state varchar NOT NULL,
+	created_at timestamp NOT NULL DEFAULT now(),
+	state_changed_at timestamp NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS signing_keys_state_idx ON signing_keys (state);
\ No newline at end of file
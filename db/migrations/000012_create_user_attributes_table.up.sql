@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS user_attributes (
+	user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	name varchar NOT NULL,
+	value jsonb NOT NULL,
+	created_at timestamp NOT NULL DEFAULT now(),
+	PRIMARY KEY (user_id, name)
+);
\ No newline at end of file
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS oauth_consents (
+	user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	client_id varchar NOT NULL REFERENCES oauth_clients (client_id) ON DELETE CASCADE,
+	scopes text[] NOT NULL DEFAULT '{}',
+	created_at timestamp NOT NULL DEFAULT now(),
+	PRIMARY KEY (user_id, client_id)
+);
\ No newline at end of file
@@ -1,6 +1,7 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -11,6 +12,7 @@ import (
 	"auth/infrastructure"
 	"auth/internal/controller"
 	"auth/internal/domain"
+	"auth/internal/helper"
 	"auth/internal/repository"
 	"auth/internal/usecase"
 	"auth/internal/utils"
@@ -66,20 +68,102 @@ func RunApi() {
 
 	defer kafkaProducer.Close()
 
+	log.Println("[INFO] Loading Signing Keys")
+	keySet, err := infrastructure.OpenKeySet()
+
+	if err != nil {
+		log.Fatalf("Could not load token signing keys %s", err)
+	}
+
+	utils.UseKeySet(keySet)
+
+	log.Println("[INFO] Loading Encryption Key")
+	encryptionKey, err := infrastructure.OpenEncryptionKey()
+
+	if err != nil {
+		log.Fatalf("Could not load token encryption key %s", err)
+	}
+
+	utils.UseEncryptionKey(encryptionKey)
+
+	log.Println("[INFO] Loading Upstream Providers")
+	upstreamProviders := infrastructure.OpenUpstreamProviders()
+
+	log.Println("[INFO] Loading Directory")
+	directory := infrastructure.OpenLDAPDirectory()
+
 	log.Println("[INFO] Loading Repository")
 	userRepo := repository.NewUserRepository(dbSQL, redisConnect, kafkaProducer)
+	mfaRepo := repository.NewMfaRepository(dbSQL, redisConnect)
+	webauthnRepo := repository.NewWebauthnRepository(dbSQL)
+	oauthRepo := repository.NewOAuthRepository(dbSQL, redisConnect)
+	serviceAccountRepo := repository.NewServiceAccountRepository(dbSQL)
+	upstreamRepo := repository.NewUpstreamRepository(dbSQL)
+	apiKeyRepo := repository.NewApiKeyRepository(dbSQL)
+	signingKeyRepo := repository.NewSigningKeyRepository(dbSQL)
+	userAttributeRepo := repository.NewUserAttributeRepository(dbSQL)
 
 	log.Println("[INFO] Loading Usecase")
-	userUsecase := usecase.NewUserUseCase(userRepo)
+	userUsecase := usecase.NewUserUseCase(userRepo, mfaRepo, directory)
+	mfaUsecase := usecase.NewMfaUseCase(mfaRepo, userRepo, directory)
+	webauthnUsecase := usecase.NewWebauthnUseCase(webauthnRepo, userRepo)
+	oauthUsecase := usecase.NewOAuthUseCase(oauthRepo, userRepo)
+	serviceAccountUsecase := usecase.NewServiceAccountUseCase(serviceAccountRepo, userRepo)
+	upstreamUsecase := usecase.NewUpstreamUseCase(upstreamRepo, userRepo, mfaRepo, upstreamProviders)
+	apiKeyUsecase := usecase.NewApiKeyUseCase(apiKeyRepo, userRepo)
+	signingKeyUsecase := usecase.NewSigningKeyUseCase(signingKeyRepo)
+	userAttributeUsecase := usecase.NewUserAttributeUseCase(userAttributeRepo, userRepo)
+
+	go userUsecase.RunImpersonationAudit(context.Background())
+
+	if signingKeyUsecase.Enabled() {
+		log.Println("[INFO] Loading Signing Key Store")
+		err = signingKeyUsecase.Load(context.Background())
+
+		if err != nil {
+			log.Fatalf("Could not load signing keys from the key store %s", err)
+		}
+
+		go signingKeyUsecase.Run(context.Background())
+	}
+
+	log.Println("[INFO] Loading Claims Enrichers")
+	claimsEnrichers := []utils.ClaimsEnricher{}
+	staticClaims, err := infrastructure.OpenStaticClaims()
+
+	if err != nil {
+		log.Fatalf("Could not load static claims %s", err)
+	}
+
+	if staticClaims != nil {
+		claimsEnrichers = append(claimsEnrichers, staticClaims)
+	}
+
+	attributeClaims := usecase.NewAttributeClaimsEnricher(userAttributeRepo)
+
+	if attributeClaims.Enabled() {
+		claimsEnrichers = append(claimsEnrichers, attributeClaims)
+	}
+
+	utils.UseClaimsEnrichers(claimsEnrichers...)
 
 	log.Println("[INFO] Loading Controller")
 	userController := controller.NewUserController(userUsecase)
+	mfaController := controller.NewMfaController(mfaUsecase, userUsecase)
+	webauthnController := controller.NewWebauthnController(webauthnUsecase, userUsecase)
+	oauthController := controller.NewOAuthController(oauthUsecase, userUsecase, serviceAccountUsecase)
+	upstreamController := controller.NewUpstreamController(upstreamUsecase, userUsecase)
+	apiKeyController := controller.NewApiKeyController(apiKeyUsecase)
+	signingKeyController := controller.NewSigningKeyController(signingKeyUsecase, userUsecase)
+	serviceAccountController := controller.NewServiceAccountController(serviceAccountUsecase, userUsecase)
+	userAttributeController := controller.NewUserAttributeController(userAttributeUsecase, userUsecase)
+	oauthClientController := controller.NewOAuthClientController(oauthUsecase, userUsecase)
 
 	log.Println("[INFO] Loading Middleware")
 	SetMiddleware(app, userRepo)
 
 	log.Println("[INFO] Loading Routes")
-	api.Routes(app, userController)
+	api.Routes(app, userController, mfaController, webauthnController, oauthController, upstreamController, apiKeyController, signingKeyController, serviceAccountController, userAttributeController, oauthClientController, apiKeyUsecase, userUsecase)
 
 	log.Fatal(app.Start(fmt.Sprintf(":%s", os.Getenv("APPLICATION_PORT"))))
 }
@@ -122,6 +206,18 @@ func SetMiddleware(r *echo.Echo, userRepo repository.UserRepository) {
 	}))
 	r.Use(middleware.Recover())
 
+	// Sessions remember the client that opened them
+	r.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ctx := helper.WithClientInfo(c.Request().Context(), helper.ClientInfo{
+				IP:     c.RealIP(),
+				Device: c.Request().UserAgent(),
+			})
+			c.SetRequest(c.Request().WithContext(ctx))
+			return next(c)
+		}
+	})
+
 	// Cors
 	r.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins: []string{"*", "http://localhost"},
@@ -129,21 +225,3 @@ func SetMiddleware(r *echo.Echo, userRepo repository.UserRepository) {
 		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization},
 	}))
 }
-
-func SetPrivateMiddleware(r *echo.Echo) {
-	config := middleware.JWTConfig{
-		Claims:     &utils.JwtCustomClaims{},
-		SigningKey: []byte("secret"),
-		Skipper: func(c echo.Context) bool {
-			// Skip middleware if path is equal 'login'
-			if c.Request().URL.Path == "/login" {
-				return true
-			}
-			if c.Request().URL.Path == "/register" {
-				return true
-			}
-			return false
-		},
-	}
-	r.Use(middleware.JWTWithConfig(config))
-}
@@ -0,0 +1,32 @@
+package infrastructure
+
+import (
+	"auth/internal/utils"
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+// OpenStaticClaims reads JWT_STATIC_CLAIMS, a JSON object of claims every
+// session token carries. It returns nil when it is not set.
+func OpenStaticClaims() (*utils.StaticClaimsEnricher, error) {
+	value := os.Getenv("JWT_STATIC_CLAIMS")
+	if value == "" {
+		return nil, nil
+	}
+
+	claims := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(value), &claims); err != nil {
+		return nil, errors.New("JWT_STATIC_CLAIMS must be a JSON object")
+	}
+
+	// Enrichers would drop them silently, a typo should stop the start
+	// instead
+	for name := range claims {
+		if utils.ReservedClaim(name) {
+			return nil, errors.New("JWT_STATIC_CLAIMS may not set the reserved claim " + name)
+		}
+	}
+
+	return utils.NewStaticClaimsEnricher(claims), nil
+}
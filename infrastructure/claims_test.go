@@ -0,0 +1,26 @@
+package infrastructure
+
+import "testing"
+
+func TestOpenStaticClaims(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		ok    bool
+	}{
+		{"unset", "", true},
+		{"custom claims", `{"tenant":"acme","tier":2}`, true},
+		{"not an object", `["tenant"]`, false},
+		{"reserved claim", `{"tenant":"acme","sub":"admin"}`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("JWT_STATIC_CLAIMS", tt.value)
+
+			if _, err := OpenStaticClaims(); tt.ok != (err == nil) {
+				t.Errorf("expected ok %t, got %v", tt.ok, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package infrastructure
+
+import (
+	"auth/internal/utils"
+	"errors"
+	"os"
+)
+
+// OpenEncryptionKey reads the JWT_ENCRYPTION_* environment. It returns nil
+// when JWT_ENCRYPTION_ALG is not set, session tokens are then only signed.
+func OpenEncryptionKey() (*utils.EncryptionKey, error) {
+	algorithm := os.Getenv("JWT_ENCRYPTION_ALG")
+	if algorithm == "" {
+		return nil, nil
+	}
+
+	pemData := []byte(os.Getenv("JWT_ENCRYPTION_KEY"))
+	if path := os.Getenv("JWT_ENCRYPTION_KEY_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		pemData = data
+	}
+
+	if len(pemData) == 0 {
+		return nil, errors.New("JWT_ENCRYPTION_KEY or JWT_ENCRYPTION_KEY_FILE must be set for " + algorithm)
+	}
+
+	privateKey, err := utils.ParsePrivateKeyPEM(pemData)
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.NewEncryptionKey(os.Getenv("JWT_ENCRYPTION_KEY_ID"), algorithm, privateKey)
+}
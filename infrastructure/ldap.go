@@ -0,0 +1,159 @@
+package infrastructure
+
+import (
+	"auth/internal/domain"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ldapTimeout bounds every directory round trip, go-ldap takes no context
+const ldapTimeout = 10 * time.Second
+
+// LDAPConfig configures an LDAP directory. UserFilter takes the escaped
+// username in place of its %s.
+type LDAPConfig struct {
+	URL               string
+	StartTLS          bool
+	BindDN            string
+	BindPassword      string
+	BaseDN            string
+	UserFilter        string
+	UsernameAttribute string
+	EmailAttribute    string
+	NameAttribute     string
+	GroupsAttribute   string
+}
+
+// LDAPDirectory authenticates users with a search followed by a bind as the
+// entry that was found
+type LDAPDirectory struct {
+	config LDAPConfig
+}
+
+func NewLDAPDirectory(config LDAPConfig) *LDAPDirectory {
+	return &LDAPDirectory{config: config}
+}
+
+// OpenLDAPDirectory reads the LDAP_* environment. It returns nil when
+// LDAP_URL is not set, leaving local passwords as the only backend.
+func OpenLDAPDirectory() domain.Directory {
+	if os.Getenv("LDAP_URL") == "" {
+		return nil
+	}
+
+	config := LDAPConfig{
+		URL:               os.Getenv("LDAP_URL"),
+		StartTLS:          os.Getenv("LDAP_START_TLS") == "true",
+		BindDN:            os.Getenv("LDAP_BIND_DN"),
+		BindPassword:      os.Getenv("LDAP_BIND_PASSWORD"),
+		BaseDN:            os.Getenv("LDAP_BASE_DN"),
+		UserFilter:        ldapEnv("LDAP_USER_FILTER", "(&(objectClass=person)(uid=%s))"),
+		UsernameAttribute: ldapEnv("LDAP_ATTRIBUTE_USERNAME", "uid"),
+		EmailAttribute:    ldapEnv("LDAP_ATTRIBUTE_EMAIL", "mail"),
+		NameAttribute:     ldapEnv("LDAP_ATTRIBUTE_NAME", "cn"),
+		GroupsAttribute:   ldapEnv("LDAP_ATTRIBUTE_GROUPS", "memberOf"),
+	}
+
+	return NewLDAPDirectory(config)
+}
+
+func (d *LDAPDirectory) Authenticate(ctx context.Context, username string, password string) (*domain.DirectoryEntry, error) {
+	// An empty password would be an unauthenticated bind, which most servers
+	// accept without checking anything
+	if username == "" || password == "" {
+		return nil, domain.ErrDirectoryInvalidCredentials
+	}
+
+	conn, err := d.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if d.config.BindDN != "" {
+		if err := conn.Bind(d.config.BindDN, d.config.BindPassword); err != nil {
+			return nil, err
+		}
+	}
+
+	search := ldap.NewSearchRequest(
+		d.config.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(ldapTimeout.Seconds()), false,
+		fmt.Sprintf(d.config.UserFilter, ldap.EscapeFilter(username)),
+		[]string{d.config.UsernameAttribute, d.config.EmailAttribute, d.config.NameAttribute, d.config.GroupsAttribute},
+		nil,
+	)
+
+	res, err := conn.Search(search)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, err
+	}
+
+	if res == nil || len(res.Entries) == 0 {
+		return nil, domain.ErrDirectoryUserNotFound
+	}
+
+	// A filter matching several entries is a misconfiguration, never guess
+	if len(res.Entries) > 1 {
+		return nil, errors.New("directory: username matches more than one entry")
+	}
+
+	entry := res.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, domain.ErrDirectoryInvalidCredentials
+		}
+		return nil, err
+	}
+
+	return &domain.DirectoryEntry{
+		DN:       entry.DN,
+		Username: entry.GetAttributeValue(d.config.UsernameAttribute),
+		Email:    entry.GetAttributeValue(d.config.EmailAttribute),
+		Name:     entry.GetAttributeValue(d.config.NameAttribute),
+		Groups:   entry.GetAttributeValues(d.config.GroupsAttribute),
+	}, nil
+}
+
+func (d *LDAPDirectory) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(d.config.URL, ldap.DialWithDialer(&net.Dialer{Timeout: ldapTimeout}))
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetTimeout(ldapTimeout)
+
+	if d.config.StartTLS {
+		serverURL, err := url.Parse(d.config.URL)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		if err := conn.StartTLS(&tls.Config{ServerName: strings.Split(serverURL.Host, ":")[0]}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+func ldapEnv(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+
+	return fallback
+}
@@ -0,0 +1,269 @@
+package infrastructure
+
+import (
+	"auth/internal/domain"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// testDirectoryEntry is an entry of the stand-in directory, found by its uid
+type testDirectoryEntry struct {
+	dn         string
+	password   string
+	attributes map[string][]string
+}
+
+// testDirectory is a stand-in LDAP server. It understands just enough of the
+// protocol for LDAPDirectory: simple binds, searches on uid and unbinds.
+type testDirectory struct {
+	listener net.Listener
+	entries  map[string]testDirectoryEntry
+
+	mu      sync.Mutex
+	binds   []string
+	filters []string
+}
+
+func newTestDirectory(t *testing.T) *testDirectory {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	directory := &testDirectory{
+		listener: listener,
+		entries: map[string]testDirectoryEntry{
+			"jane": {
+				dn:       "uid=jane,ou=people,dc=example,dc=com",
+				password: "secret",
+				attributes: map[string][]string{
+					"uid":      {"jane"},
+					"mail":     {"jane@example.com"},
+					"cn":       {"Jane Doe"},
+					"memberOf": {"cn=staff,ou=groups,dc=example,dc=com", "cn=admins,ou=groups,dc=example,dc=com"},
+				},
+			},
+		},
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go directory.serve(conn)
+		}
+	}()
+
+	t.Cleanup(func() { listener.Close() })
+
+	return directory
+}
+
+func (d *testDirectory) directory() *LDAPDirectory {
+	return NewLDAPDirectory(LDAPConfig{
+		URL:               "ldap://" + d.listener.Addr().String(),
+		BindDN:            "cn=readonly,dc=example,dc=com",
+		BindPassword:      "readonly",
+		BaseDN:            "ou=people,dc=example,dc=com",
+		UserFilter:        "(&(objectClass=person)(uid=%s))",
+		UsernameAttribute: "uid",
+		EmailAttribute:    "mail",
+		NameAttribute:     "cn",
+		GroupsAttribute:   "memberOf",
+	})
+}
+
+func (d *testDirectory) serve(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+
+		messageID := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := op.Children[1].Value.(string)
+			password := op.Children[2].Data.String()
+
+			d.mu.Lock()
+			d.binds = append(d.binds, dn)
+			d.mu.Unlock()
+
+			code := ldap.LDAPResultInvalidCredentials
+			if d.checkPassword(dn, password) {
+				code = ldap.LDAPResultSuccess
+			}
+
+			conn.Write(ldapResponse(messageID, ldapResult(ldap.ApplicationBindResponse, code)).Bytes())
+		case ldap.ApplicationSearchRequest:
+			filter, _ := ldap.DecompileFilter(op.Children[6])
+
+			d.mu.Lock()
+			d.filters = append(d.filters, filter)
+			d.mu.Unlock()
+
+			if entry, ok := d.entries[equalityValue(op.Children[6], "uid")]; ok {
+				conn.Write(ldapResponse(messageID, ldapSearchEntry(entry)).Bytes())
+			}
+
+			conn.Write(ldapResponse(messageID, ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess)).Bytes())
+		default:
+			return
+		}
+	}
+}
+
+func (d *testDirectory) checkPassword(dn string, password string) bool {
+	if dn == "cn=readonly,dc=example,dc=com" {
+		return password == "readonly"
+	}
+
+	for _, entry := range d.entries {
+		if entry.dn == dn {
+			return password != "" && entry.password == password
+		}
+	}
+
+	return false
+}
+
+// equalityValue finds the value an equality filter compares attribute to,
+// as it went over the wire
+func equalityValue(filter *ber.Packet, attribute string) string {
+	if filter.ClassType == ber.ClassContext && filter.Tag == ldap.FilterEqualityMatch {
+		if filter.Children[0].Data.String() == attribute {
+			return filter.Children[1].Data.String()
+		}
+		return ""
+	}
+
+	for _, child := range filter.Children {
+		if value := equalityValue(child, attribute); value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
+
+func ldapResponse(messageID int64, op *ber.Packet) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+	packet.AppendChild(op)
+	return packet
+}
+
+func ldapResult(tag ber.Tag, code int) *ber.Packet {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return result
+}
+
+func ldapSearchEntry(entry testDirectoryEntry) *ber.Packet {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, "Object Name"))
+
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, values := range entry.attributes {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		}
+
+		attribute.AppendChild(set)
+		attributes.AppendChild(attribute)
+	}
+
+	result.AppendChild(attributes)
+	return result
+}
+
+func TestLDAPDirectoryAuthenticate(t *testing.T) {
+	server := newTestDirectory(t)
+
+	entry, err := server.directory().Authenticate(context.Background(), "jane", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entry.DN != "uid=jane,ou=people,dc=example,dc=com" || entry.Username != "jane" {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+	if entry.Email != "jane@example.com" || entry.Name != "Jane Doe" {
+		t.Errorf("attributes not mapped: %+v", entry)
+	}
+	if len(entry.Groups) != 2 || entry.Groups[0] != "cn=staff,ou=groups,dc=example,dc=com" {
+		t.Errorf("groups not mapped: %v", entry.Groups)
+	}
+}
+
+func TestLDAPDirectoryWrongPassword(t *testing.T) {
+	server := newTestDirectory(t)
+
+	_, err := server.directory().Authenticate(context.Background(), "jane", "wrong")
+	if !errors.Is(err, domain.ErrDirectoryInvalidCredentials) {
+		t.Fatalf("expected invalid credentials, got %v", err)
+	}
+}
+
+func TestLDAPDirectoryUnknownUser(t *testing.T) {
+	server := newTestDirectory(t)
+
+	_, err := server.directory().Authenticate(context.Background(), "john", "secret")
+	if !errors.Is(err, domain.ErrDirectoryUserNotFound) {
+		t.Fatalf("expected user not found, got %v", err)
+	}
+}
+
+func TestLDAPDirectoryEscapesFilter(t *testing.T) {
+	server := newTestDirectory(t)
+
+	// Unescaped, this would match every entry with a uid
+	_, err := server.directory().Authenticate(context.Background(), "jane*)(uid=*", "secret")
+	if !errors.Is(err, domain.ErrDirectoryUserNotFound) {
+		t.Fatalf("expected user not found, got %v", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	want := `(&(objectClass=person)(uid=jane\2a\29\28uid=\2a))`
+	if len(server.filters) != 1 || server.filters[0] != want {
+		t.Errorf("expected filter %s, got %v", want, server.filters)
+	}
+}
+
+func TestLDAPDirectoryEmptyPassword(t *testing.T) {
+	server := newTestDirectory(t)
+
+	_, err := server.directory().Authenticate(context.Background(), "jane", "")
+	if !errors.Is(err, domain.ErrDirectoryInvalidCredentials) {
+		t.Fatalf("expected invalid credentials, got %v", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	// Refused before the directory is ever asked
+	if len(server.binds) != 0 {
+		t.Errorf("expected no bind, got %v", server.binds)
+	}
+}
@@ -0,0 +1,42 @@
+package infrastructure
+
+import (
+	"auth/internal/utils"
+	"errors"
+	"os"
+)
+
+// OpenKeySet reads the JWT_SIGNING_* environment. It returns nil when
+// JWT_SIGNING_ALG is HS256 or not set, tokens are then signed with JWT_KEY,
+// and when the keys come from the key store instead.
+func OpenKeySet() (utils.KeySet, error) {
+	algorithm := os.Getenv("JWT_SIGNING_ALG")
+	if algorithm == "" || algorithm == utils.AlgHS256 || os.Getenv("JWT_SIGNING_KEY_STORE") == "postgres" {
+		return nil, nil
+	}
+
+	pemData := []byte(os.Getenv("JWT_SIGNING_KEY"))
+	if path := os.Getenv("JWT_SIGNING_KEY_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		pemData = data
+	}
+
+	if len(pemData) == 0 {
+		return nil, errors.New("JWT_SIGNING_KEY or JWT_SIGNING_KEY_FILE must be set for " + algorithm)
+	}
+
+	privateKey, err := utils.ParsePrivateKeyPEM(pemData)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := utils.NewSigningKey(os.Getenv("JWT_SIGNING_KEY_ID"), algorithm, privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.NewStaticKeySet(key), nil
+}
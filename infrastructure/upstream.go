@@ -0,0 +1,184 @@
+package infrastructure
+
+import (
+	"auth/internal/domain"
+	"auth/internal/helper"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// discoveryRetry is how long a provider that could not be discovered is left
+// alone before the next attempt
+const discoveryRetry = 30 * time.Second
+
+// OIDCProvider is an upstream OpenID Connect identity provider. Its id_tokens
+// are verified against the keys it publishes in its JWKS.
+type OIDCProvider struct {
+	name   string
+	issuer string
+	// ctx is kept for fetching the provider's keys, it is never cancelled
+	ctx context.Context
+
+	mu       sync.Mutex
+	config   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+	err      error
+	retryAt  time.Time
+}
+
+// NewOIDCProvider runs discovery against an issuer. The context is kept for
+// fetching the provider's keys later on, it must not be cancelled. When the
+// issuer cannot be reached the provider is still returned, along with the
+// error, and discovery is tried again when it is used.
+func NewOIDCProvider(ctx context.Context, name string, issuer string, clientID string, clientSecret string, redirectURL string, scopes []string) (*OIDCProvider, error) {
+	provider := &OIDCProvider{
+		name:   name,
+		issuer: issuer,
+		ctx:    ctx,
+		config: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+			Scopes:       scopes,
+		},
+	}
+
+	return provider, provider.discover()
+}
+
+// discover looks up the issuer's endpoints and keys once it succeeds, and
+// at most every discoveryRetry until then
+func (p *OIDCProvider) discover() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.verifier != nil {
+		return nil
+	}
+
+	if time.Now().Before(p.retryAt) {
+		return p.err
+	}
+
+	provider, err := oidc.NewProvider(p.ctx, p.issuer)
+	if err != nil {
+		p.err = err
+		p.retryAt = time.Now().Add(discoveryRetry)
+		return err
+	}
+
+	p.config.Endpoint = provider.Endpoint()
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: p.config.ClientID})
+	p.err = nil
+
+	return nil
+}
+
+func (p *OIDCProvider) Name() string {
+	return p.name
+}
+
+func (p *OIDCProvider) AuthCodeURL(state string, nonce string, codeVerifier string) (string, error) {
+	if err := p.discover(); err != nil {
+		return "", err
+	}
+
+	return p.config.AuthCodeURL(state,
+		oidc.Nonce(nonce),
+		oauth2.SetAuthURLParam("code_challenge", helper.PKCEChallenge(codeVerifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	), nil
+}
+
+// Exchange redeems an authorization code and verifies the id_token that
+// comes with it, signature, issuer, audience, expiry and nonce included.
+func (p *OIDCProvider) Exchange(ctx context.Context, code string, codeVerifier string, nonce string) (*domain.UpstreamIdentity, error) {
+	if err := p.discover(); err != nil {
+		return nil, err
+	}
+
+	token, err := p.config.Exchange(ctx, code, oauth2.SetAuthURLParam("code_verifier", codeVerifier))
+	if err != nil {
+		return nil, err
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("upstream: no id_token in token response")
+	}
+
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if idToken.Nonce != nonce {
+		return nil, errors.New("upstream: id_token nonce mismatch")
+	}
+
+	var claims struct {
+		Email             string `json:"email"`
+		EmailVerified     bool   `json:"email_verified"`
+		Name              string `json:"name"`
+		PreferredUsername string `json:"preferred_username"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+
+	return &domain.UpstreamIdentity{
+		Provider:          p.name,
+		Subject:           idToken.Subject,
+		Email:             claims.Email,
+		EmailVerified:     claims.EmailVerified,
+		Name:              claims.Name,
+		PreferredUsername: claims.PreferredUsername,
+	}, nil
+}
+
+// OpenUpstreamProviders sets up the providers listed in UPSTREAM_PROVIDERS.
+// Each provider NAME is configured by UPSTREAM_NAME_ISSUER,
+// UPSTREAM_NAME_CLIENT_ID, UPSTREAM_NAME_CLIENT_SECRET and optionally
+// UPSTREAM_NAME_SCOPES. A provider that cannot be reached yet is discovered
+// again when somebody signs in through it.
+func OpenUpstreamProviders() map[string]domain.UpstreamProvider {
+	providers := map[string]domain.UpstreamProvider{}
+
+	for _, name := range strings.Split(os.Getenv("UPSTREAM_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		prefix := "UPSTREAM_" + strings.ToUpper(name) + "_"
+		scopes := strings.Fields(os.Getenv(prefix + "SCOPES"))
+		if len(scopes) == 0 {
+			scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+		}
+
+		redirectURL := strings.TrimRight(os.Getenv("APPLICATION_URL"), "/") + "/login/upstream/" + name + "/callback"
+
+		provider, err := NewOIDCProvider(context.Background(), name,
+			os.Getenv(prefix+"ISSUER"),
+			os.Getenv(prefix+"CLIENT_ID"),
+			os.Getenv(prefix+"CLIENT_SECRET"),
+			redirectURL,
+			scopes,
+		)
+		if err != nil {
+			log.Printf("Upstream provider %s unreachable, retrying on use: %s", name, err)
+		}
+
+		providers[name] = provider
+	}
+
+	return providers
+}
@@ -0,0 +1,203 @@
+package infrastructure
+
+import (
+	"auth/internal/helper"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+)
+
+// testIssuer is a stand-in OpenID Connect provider. It hands out an id_token
+// for code "good" once the PKCE verifier matches the challenge it was given.
+type testIssuer struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	nonce     string
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := &testIssuer{key: key}
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                issuer.server.URL,
+			"authorization_endpoint":                issuer.server.URL + "/authorize",
+			"token_endpoint":                        issuer.server.URL + "/token",
+			"jwks_uri":                              issuer.server.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != "good" || !helper.VerifyPKCE(r.Form.Get("code_verifier"), issuer.challenge) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "upstream-access-token",
+			"token_type":   "Bearer",
+			"expires_in":   300,
+			"id_token":     issuer.idToken(t, "client", issuer.nonce),
+		})
+	})
+
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+
+	return issuer
+}
+
+func (i *testIssuer) idToken(t *testing.T, audience string, nonce string) string {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: i.key}, (&jose.SignerOptions{}).WithHeader("kid", "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := map[string]any{
+		"iss":                i.server.URL,
+		"sub":                "upstream-user",
+		"aud":                audience,
+		"exp":                time.Now().Add(time.Minute).Unix(),
+		"iat":                time.Now().Unix(),
+		"nonce":              nonce,
+		"email":              "jane@example.com",
+		"email_verified":     true,
+		"name":               "Jane",
+		"preferred_username": "jane",
+	}
+
+	token, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func newTestProvider(t *testing.T, issuer *testIssuer) *OIDCProvider {
+	provider, err := NewOIDCProvider(context.Background(), "company", issuer.server.URL, "client", "secret", "http://localhost/login/upstream/company/callback", []string{"openid", "email", "profile"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return provider
+}
+
+func TestOIDCProviderAuthCodeURL(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := newTestProvider(t, issuer)
+
+	rawURL, err := provider.AuthCodeURL("state", "nonce", "verifier-verifier-verifier-verifier-verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authURL, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := authURL.Query()
+	if authURL.Path != "/authorize" || query.Get("state") != "state" || query.Get("nonce") != "nonce" {
+		t.Errorf("unexpected authorization url %s", authURL)
+	}
+	if query.Get("code_challenge") != helper.PKCEChallenge("verifier-verifier-verifier-verifier-verifier") || query.Get("code_challenge_method") != "S256" {
+		t.Errorf("missing PKCE challenge in %s", authURL)
+	}
+}
+
+func TestOIDCProviderExchange(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := newTestProvider(t, issuer)
+
+	verifier := "verifier-verifier-verifier-verifier-verifier"
+	issuer.challenge = helper.PKCEChallenge(verifier)
+	issuer.nonce = "nonce"
+
+	identity, err := provider.Exchange(context.Background(), "good", verifier, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if identity.Provider != "company" || identity.Subject != "upstream-user" {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+	if identity.Email != "jane@example.com" || !identity.EmailVerified || identity.PreferredUsername != "jane" {
+		t.Errorf("unexpected claims %+v", identity)
+	}
+}
+
+func TestOIDCProviderExchangeNonceMismatch(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := newTestProvider(t, issuer)
+
+	verifier := "verifier-verifier-verifier-verifier-verifier"
+	issuer.challenge = helper.PKCEChallenge(verifier)
+	issuer.nonce = "replayed"
+
+	if _, err := provider.Exchange(context.Background(), "good", verifier, "nonce"); err == nil {
+		t.Fatal("expected a nonce mismatch to be rejected")
+	}
+}
+
+func TestOIDCProviderExchangeWrongVerifier(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := newTestProvider(t, issuer)
+
+	issuer.challenge = helper.PKCEChallenge("verifier-verifier-verifier-verifier-verifier")
+	issuer.nonce = "nonce"
+
+	if _, err := provider.Exchange(context.Background(), "good", "another-verifier-another-verifier-another", "nonce"); err == nil {
+		t.Fatal("expected a wrong code verifier to be rejected")
+	}
+}
+
+func TestOIDCProviderRetriesDiscovery(t *testing.T) {
+	issuer := newTestIssuer(t)
+	down := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(down.Close)
+
+	// Point at an issuer that is not there yet
+	provider, err := NewOIDCProvider(context.Background(), "company", down.URL, "client", "secret", "http://localhost/login/upstream/company/callback", []string{"openid"})
+	if err == nil {
+		t.Fatal("expected discovery to fail")
+	}
+
+	if _, err := provider.AuthCodeURL("state", "nonce", "verifier"); err == nil {
+		t.Fatal("expected no retry before the retry window passed")
+	}
+
+	provider.issuer = issuer.server.URL
+	provider.retryAt = time.Time{}
+
+	if _, err := provider.AuthCodeURL("state", "nonce", "verifier"); err != nil {
+		t.Fatalf("expected discovery to be retried, got %s", err)
+	}
+}
@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"auth/internal/domain"
+	"auth/internal/usecase"
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type createapikeyresponse struct {
+	Error   bool           `json:"error"`
+	Message string         `json:"message"`
+	Key     string         `json:"key"`
+	ApiKey  *domain.ApiKey `json:"api_key"`
+}
+
+type apikeysresponse struct {
+	Error   bool             `json:"error"`
+	Message string           `json:"message"`
+	ApiKeys []*domain.ApiKey `json:"api_keys"`
+}
+
+// interface
+type ApiKeyController interface {
+	Create(ec echo.Context) error
+	List(ec echo.Context) error
+	Revoke(ec echo.Context) error
+}
+
+// implement interface
+type ApiKeyControllerImpl struct {
+	ApiKeyUsecase usecase.ApiKeyUseCase
+}
+
+func NewApiKeyController(apiKeyUsecase usecase.ApiKeyUseCase) ApiKeyController {
+	return &ApiKeyControllerImpl{
+		ApiKeyUsecase: apiKeyUsecase,
+	}
+}
+
+func (ac *ApiKeyControllerImpl) Create(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	// Validation
+	u := new(domain.CreateApiKeyValidation)
+	if err := c.Bind(u); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(u); err != nil {
+		return err
+	}
+
+	// Get JWT Content
+	user := c.Get("user").(domain.User)
+
+	key, apiKey, err := ac.ApiKeyUsecase.Create(ctx, &user, u)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusUnprocessableEntity, response)
+	}
+
+	response := createapikeyresponse{
+		Error:   false,
+		Message: "Simpan api key ini, api key tidak akan ditampilkan lagi",
+		Key:     key,
+		ApiKey:  apiKey,
+	}
+
+	return c.JSON(http.StatusCreated, response)
+}
+
+func (ac *ApiKeyControllerImpl) List(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	// Get JWT Content
+	user := c.Get("user").(domain.User)
+
+	apiKeys, err := ac.ApiKeyUsecase.List(ctx, &user)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusUnprocessableEntity, response)
+	}
+
+	response := apikeysresponse{
+		Error:   false,
+		Message: "Berhasil mengambil data",
+		ApiKeys: apiKeys,
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (ac *ApiKeyControllerImpl) Revoke(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	id, err := strconv.Atoi(c.Param("id"))
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "id tidak valid")
+	}
+
+	// Get JWT Content
+	user := c.Get("user").(domain.User)
+
+	err = ac.ApiKeyUsecase.Revoke(ctx, &user, id)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusNotFound, response)
+	}
+
+	response := messageresponse{
+		Error:   false,
+		Message: "Api key berhasil dicabut",
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
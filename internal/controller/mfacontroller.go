@@ -0,0 +1,206 @@
+package controller
+
+import (
+	"auth/internal/domain"
+	"auth/internal/usecase"
+	"auth/internal/utils"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type enrolltotpresponse struct {
+	Error   bool   `json:"error"`
+	Message string `json:"message"`
+	Secret  string `json:"secret"`
+	Uri     string `json:"uri"`
+}
+
+type recoverycoderesponse struct {
+	Error         bool     `json:"error"`
+	Message       string   `json:"message"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// interface
+type MfaController interface {
+	EnrollTOTP(ec echo.Context) error
+	ConfirmTOTP(ec echo.Context) error
+	DisableTOTP(ec echo.Context) error
+	LoginMfa(ec echo.Context) error
+}
+
+// implement interface
+type MfaControllerImpl struct {
+	MfaUsecase  usecase.MfaUseCase
+	UserUsecase usecase.UserUseCase
+}
+
+func NewMfaController(mfaUsecase usecase.MfaUseCase, userUsecase usecase.UserUseCase) MfaController {
+	return &MfaControllerImpl{
+		MfaUsecase:  mfaUsecase,
+		UserUsecase: userUsecase,
+	}
+}
+
+func (mc *MfaControllerImpl) EnrollTOTP(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	// Get JWT Content
+	user := c.Get("user").(domain.User)
+
+	secret, uri, err := mc.MfaUsecase.EnrollTOTP(ctx, &user)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusUnprocessableEntity, response)
+	}
+
+	response := enrolltotpresponse{
+		Error:   false,
+		Message: "Scan kode QR lalu konfirmasi dengan kode dari aplikasi authenticator",
+		Secret:  secret,
+		Uri:     uri,
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (mc *MfaControllerImpl) ConfirmTOTP(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	// Validation
+	u := new(domain.ConfirmTOTPValidation)
+	if err := c.Bind(u); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(u); err != nil {
+		return err
+	}
+
+	// Get JWT Content
+	user := c.Get("user").(domain.User)
+
+	recoveryCodes, err := mc.MfaUsecase.ConfirmTOTP(ctx, &user, u.Code)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusUnprocessableEntity, response)
+	}
+
+	response := recoverycoderesponse{
+		Error:         false,
+		Message:       "MFA aktif, simpan recovery code berikut",
+		RecoveryCodes: recoveryCodes,
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (mc *MfaControllerImpl) DisableTOTP(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	// Validation
+	u := new(domain.DisableTOTPValidation)
+	if err := c.Bind(u); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(u); err != nil {
+		return err
+	}
+
+	// Get JWT Content
+	user := c.Get("user").(domain.User)
+
+	err := mc.MfaUsecase.DisableTOTP(ctx, &user, u)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusUnprocessableEntity, response)
+	}
+
+	response := messageresponse{
+		Error:   false,
+		Message: "MFA berhasil dinonaktifkan",
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (mc *MfaControllerImpl) LoginMfa(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	// Validation
+	u := new(domain.LoginMfaValidation)
+	if err := c.Bind(u); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(u); err != nil {
+		return err
+	}
+
+	// Check second factor
+	login, uuidGen, err := mc.MfaUsecase.LoginMfa(ctx, u)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusUnauthorized, response)
+	}
+
+	// Generate Token
+	token, err := utils.GenerateToken(ctx, login, uuidGen)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+
+	refreshToken, err := mc.UserUsecase.IssueRefreshToken(ctx, login, uuidGen)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+
+	response := loginresponse{
+		Error:        false,
+		Message:      "Berhasil login",
+		Data:         login,
+		Token:        token,
+		RefreshToken: refreshToken,
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
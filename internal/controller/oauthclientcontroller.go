@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"auth/internal/domain"
+	"auth/internal/usecase"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type createoauthclientresponse struct {
+	Error        bool                `json:"error"`
+	Message      string              `json:"message"`
+	ClientSecret string              `json:"client_secret,omitempty"`
+	Client       *domain.OAuthClient `json:"client"`
+}
+
+// interface
+type OAuthClientController interface {
+	Create(ec echo.Context) error
+}
+
+// implement interface
+type OAuthClientControllerImpl struct {
+	OAuthUsecase usecase.OAuthUseCase
+	UserUsecase  usecase.UserUseCase
+}
+
+func NewOAuthClientController(oauthUsecase usecase.OAuthUseCase, userUsecase usecase.UserUseCase) OAuthClientController {
+	return &OAuthClientControllerImpl{
+		OAuthUsecase: oauthUsecase,
+		UserUsecase:  userUsecase,
+	}
+}
+
+func (oc *OAuthClientControllerImpl) Create(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	// Get JWT Content
+	user := c.Get("user").(domain.User)
+
+	if err := oc.UserUsecase.RequireAdmin(ctx, &user); err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusForbidden, response)
+	}
+
+	// Validation
+	u := new(domain.CreateOAuthClientValidation)
+	if err := c.Bind(u); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(u); err != nil {
+		return err
+	}
+
+	secret, client, err := oc.OAuthUsecase.CreateClient(ctx, u)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusUnprocessableEntity, response)
+	}
+
+	message := "Client berhasil didaftarkan"
+	if secret != "" {
+		message = "Simpan client secret ini, client secret tidak akan ditampilkan lagi"
+	}
+
+	response := createoauthclientresponse{
+		Error:        false,
+		Message:      message,
+		ClientSecret: secret,
+		Client:       client,
+	}
+
+	return c.JSON(http.StatusCreated, response)
+}
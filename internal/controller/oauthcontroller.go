@@ -0,0 +1,649 @@
+package controller
+
+import (
+	"auth/internal/domain"
+	"auth/internal/usecase"
+	"auth/internal/utils"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/exp/slices"
+)
+
+// sessionCookie keeps the browser signed in between /authorize requests
+const sessionCookie = "auth_session"
+
+type deviceverification struct {
+	UserCode   string `json:"user_code"`
+	ClientID   string `json:"client_id"`
+	ClientName string `json:"client_name"`
+	Scope      string `json:"scope"`
+}
+
+type deviceverificationresponse struct {
+	Error   bool               `json:"error"`
+	Message string             `json:"message"`
+	Data    deviceverification `json:"data"`
+}
+
+// consentrequiredresponse asks the user to allow the client the scopes, by
+// posting the same request again with consent=allow or consent=deny
+type consentrequiredresponse struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+	ClientName  string `json:"client_name"`
+	Scope       string `json:"scope"`
+}
+
+type mfaloginrequiredresponse struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+	MfaToken    string `json:"mfa_token"`
+}
+
+// interface
+type OAuthController interface {
+	Authorize(ec echo.Context) error
+	Token(ec echo.Context) error
+	UserInfo(ec echo.Context) error
+	OpenIDConfiguration(ec echo.Context) error
+	JWKS(ec echo.Context) error
+	Introspect(ec echo.Context) error
+	Revoke(ec echo.Context) error
+	DeviceCode(ec echo.Context) error
+	DeviceVerification(ec echo.Context) error
+	VerifyDevice(ec echo.Context) error
+}
+
+// implement interface
+type OAuthControllerImpl struct {
+	OAuthUsecase          usecase.OAuthUseCase
+	UserUsecase           usecase.UserUseCase
+	ServiceAccountUsecase usecase.ServiceAccountUseCase
+}
+
+func NewOAuthController(oauthUsecase usecase.OAuthUseCase, userUsecase usecase.UserUseCase, serviceAccountUsecase usecase.ServiceAccountUseCase) OAuthController {
+	return &OAuthControllerImpl{
+		OAuthUsecase:          oauthUsecase,
+		UserUsecase:           userUsecase,
+		ServiceAccountUsecase: serviceAccountUsecase,
+	}
+}
+
+func (oc *OAuthControllerImpl) Authorize(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	u := new(domain.AuthorizeValidation)
+	if err := c.Bind(u); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	// Until client and redirect_uri are verified, errors go to the user
+	// agent and never to the redirect_uri
+	client, err := oc.OAuthUsecase.GetClientForRedirect(ctx, u.ClientID, u.RedirectURI)
+
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	scope, err := oc.OAuthUsecase.ValidateAuthorize(client, u)
+
+	if err != nil {
+		return redirectOAuthError(c, u, err)
+	}
+
+	user, authTime, err := oc.authenticate(c, ctx, u)
+
+	if err != nil {
+		return err
+	}
+
+	if user == nil {
+		return nil
+	}
+
+	// Only a POST answers the prompt, a link cannot grant access
+	if c.Request().Method == http.MethodPost && u.Consent == "deny" {
+		return redirectOAuthError(c, u, &domain.OAuthError{Code: "access_denied", Description: "pengguna menolak memberi akses"})
+	}
+
+	if c.Request().Method == http.MethodPost && u.Consent == "allow" {
+		err = oc.OAuthUsecase.GrantConsent(ctx, client, user, scope)
+	} else {
+		var consented bool
+		consented, err = oc.OAuthUsecase.HasConsent(ctx, client, user, scope)
+
+		if err == nil && !consented {
+			response := consentrequiredresponse{
+				Code:        "consent_required",
+				Description: "izinkan aplikasi mengakses akun anda",
+				ClientName:  client.Name,
+				Scope:       scope,
+			}
+			return c.JSON(http.StatusUnauthorized, response)
+		}
+	}
+
+	if err != nil {
+		return redirectOAuthError(c, u, &domain.OAuthError{Code: "server_error"})
+	}
+
+	code, err := oc.OAuthUsecase.Authorize(ctx, client, u, scope, user, authTime)
+
+	if err != nil {
+		return redirectOAuthError(c, u, &domain.OAuthError{Code: "server_error"})
+	}
+
+	redirect, _ := url.Parse(u.RedirectURI)
+	query := redirect.Query()
+	query.Set("code", code)
+	if u.State != "" {
+		query.Set("state", u.State)
+	}
+	redirect.RawQuery = query.Encode()
+
+	return c.Redirect(http.StatusFound, redirect.String())
+}
+
+// authenticate finds the user behind an /authorize request, through the
+// existing session or by logging in with the submitted credentials, and when
+// that authentication happened. It writes the response itself and returns a
+// nil user when nobody is signed in.
+func (oc *OAuthControllerImpl) authenticate(c echo.Context, ctx context.Context, u *domain.AuthorizeValidation) (*domain.User, time.Time, error) {
+	token := strings.TrimPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+	if cookie, err := c.Cookie(sessionCookie); err == nil && token == "" {
+		token = cookie.Value
+	}
+
+	if token != "" {
+		claims, err := utils.ParseToken(token)
+
+		// An admin acting as the user may not grant clients access, nor
+		// may a client holding a token of the user grant itself more
+		if err == nil && claims.Act == nil && claims.ClientID == "" {
+			user, err := oc.UserUsecase.Profile(ctx, claims.Uuid)
+
+			// Tokens signed before iat was added have no auth time
+			if err == nil && claims.IssuedAt != nil {
+				return user, claims.IssuedAt.Time, nil
+			}
+
+			if err == nil {
+				return user, time.Time{}, nil
+			}
+		}
+	}
+
+	if c.Request().Method != http.MethodPost || u.Username == "" {
+		return nil, time.Time{}, c.JSON(http.StatusUnauthorized, &domain.OAuthError{Code: "login_required", Description: "silakan login terlebih dahulu"})
+	}
+
+	user, uuidGen, mfaToken, err := oc.UserUsecase.Login(ctx, &domain.LoginValidation{
+		Username: u.Username,
+		Password: u.Password,
+	})
+
+	if err != nil {
+		return nil, time.Time{}, c.JSON(http.StatusUnauthorized, &domain.OAuthError{Code: "access_denied", Description: err.Error()})
+	}
+
+	// Finish with /login/mfa, then retry with the Bearer token
+	if mfaToken != "" {
+		response := mfaloginrequiredresponse{
+			Code:        "mfa_required",
+			Description: "Masukkan kode MFA",
+			MfaToken:    mfaToken,
+		}
+		return nil, time.Time{}, c.JSON(http.StatusUnauthorized, response)
+	}
+
+	sessionToken, err := utils.GenerateToken(ctx, user, uuidGen)
+
+	if err != nil {
+		return nil, time.Time{}, c.JSON(http.StatusInternalServerError, &domain.OAuthError{Code: "server_error"})
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     sessionCookie,
+		Value:    sessionToken.(string),
+		Path:     "/authorize",
+		MaxAge:   int(utils.TokenExpire().Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(os.Getenv("APPLICATION_URL"), "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return user, time.Now(), nil
+}
+
+func (oc *OAuthControllerImpl) Token(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	// Token responses carry credentials, they must never be cached
+	c.Response().Header().Set("Cache-Control", "no-store")
+	c.Response().Header().Set("Pragma", "no-cache")
+
+	u := new(domain.TokenValidation)
+	if err := c.Bind(u); err != nil {
+		return c.JSON(http.StatusBadRequest, &domain.OAuthError{Code: "invalid_request", Description: err.Error()})
+	}
+
+	if !slices.Contains([]string{"authorization_code", "refresh_token", "client_credentials", domain.GrantTypeDeviceCode}, u.GrantType) {
+		return c.JSON(http.StatusBadRequest, &domain.OAuthError{Code: "unsupported_grant_type"})
+	}
+
+	// Client credentials come in the Authorization header or the body
+	clientID, clientSecret, basic := c.Request().BasicAuth()
+	if basic {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = u.ClientID, u.ClientSecret
+	}
+
+	// Service accounts are not OAuth clients, they only ever use this grant
+	if u.GrantType == "client_credentials" {
+		return oc.clientCredentials(c, ctx, u, clientID, clientSecret, basic)
+	}
+
+	client, err := oc.OAuthUsecase.AuthenticateClient(ctx, clientID, clientSecret)
+
+	if err != nil {
+		if basic {
+			c.Response().Header().Set("WWW-Authenticate", `Basic realm="token"`)
+		}
+		return c.JSON(http.StatusUnauthorized, err)
+	}
+
+	if !slices.Contains(client.GrantTypes, u.GrantType) {
+		return c.JSON(http.StatusBadRequest, &domain.OAuthError{Code: "unauthorized_client"})
+	}
+
+	var (
+		user         *domain.User
+		uuidGen      string
+		scope        string
+		refreshToken string
+		nonce        string
+		authTime     int64
+	)
+
+	switch u.GrantType {
+	case "authorization_code":
+		var authorization *domain.AuthorizationCode
+		user, uuidGen, authorization, err = oc.OAuthUsecase.ExchangeCode(ctx, client, u)
+
+		if err == nil {
+			scope, nonce, authTime = authorization.Scope, authorization.Nonce, authorization.AuthTime
+		}
+
+		if err == nil && slices.Contains(client.GrantTypes, "refresh_token") {
+			refreshToken, err = oc.UserUsecase.IssueClientRefreshToken(ctx, user, uuidGen, client.ClientID, scope)
+		}
+	case domain.GrantTypeDeviceCode:
+		var device *domain.DeviceAuthorization
+		user, uuidGen, device, err = oc.OAuthUsecase.ExchangeDeviceCode(ctx, client, u.DeviceCode)
+
+		if err == nil {
+			scope = device.Scope
+		}
+
+		if err == nil && slices.Contains(client.GrantTypes, "refresh_token") {
+			refreshToken, err = oc.UserUsecase.IssueClientRefreshToken(ctx, user, uuidGen, client.ClientID, scope)
+		}
+	case "refresh_token":
+		user, uuidGen, refreshToken, scope, err = oc.UserUsecase.Refresh(ctx, u.RefreshToken, client.ClientID)
+
+		if err != nil {
+			err = &domain.OAuthError{Code: "invalid_grant", Description: err.Error()}
+		}
+	}
+
+	if err != nil {
+		var oauthErr *domain.OAuthError
+		if errors.As(err, &oauthErr) {
+			return c.JSON(http.StatusBadRequest, oauthErr)
+		}
+		return c.JSON(http.StatusInternalServerError, &domain.OAuthError{Code: "server_error"})
+	}
+
+	// Generate Token
+	token, err := utils.GenerateScopedToken(ctx, user, uuidGen, client.ClientID, scope)
+
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, &domain.OAuthError{Code: "server_error"})
+	}
+
+	response := domain.TokenResponse{
+		AccessToken:  token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(utils.TokenExpire().Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        scope,
+	}
+
+	// OpenID Connect clients get an id_token as well, refreshed ones carry no
+	// nonce or auth_time since no new authentication took place
+	if slices.Contains(strings.Fields(scope), "openid") {
+		response.IDToken, err = utils.GenerateIDToken(oc.OAuthUsecase.UserInfo(user, scope), client.ClientID, nonce, authTime)
+
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, &domain.OAuthError{Code: "server_error"})
+		}
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (oc *OAuthControllerImpl) UserInfo(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	_, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	// Get JWT Content
+	user := c.Get("user").(domain.User)
+	scope, _ := c.Get("scope").(string)
+	clientID, _ := c.Get("client_id").(string)
+
+	// First party tokens from /login are not limited by any scope, API keys
+	// are limited by theirs
+	if clientID == "" && c.Get("api_key") == nil {
+		scope = "openid profile email"
+	}
+
+	if !slices.Contains(strings.Fields(scope), "openid") {
+		c.Response().Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+		return c.JSON(http.StatusForbidden, &domain.OAuthError{Code: "insufficient_scope", Description: "token tidak memiliki scope openid"})
+	}
+
+	return c.JSON(http.StatusOK, oc.OAuthUsecase.UserInfo(&user, scope))
+}
+
+func (oc *OAuthControllerImpl) OpenIDConfiguration(c echo.Context) error {
+	return c.JSON(http.StatusOK, oc.OAuthUsecase.OpenIDConfiguration())
+}
+
+// JWKS publishes the public keys our tokens are signed with. Verifiers cache
+// it, a rotated key shows up here before it signs anything.
+func (oc *OAuthControllerImpl) JWKS(c echo.Context) error {
+	c.Response().Header().Set("Cache-Control", "public, max-age=300")
+
+	return c.JSON(http.StatusOK, utils.JWKS())
+}
+
+func (oc *OAuthControllerImpl) Introspect(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	c.Response().Header().Set("Cache-Control", "no-store")
+
+	u := new(domain.IntrospectValidation)
+	if err := c.Bind(u); err != nil {
+		return c.JSON(http.StatusBadRequest, &domain.OAuthError{Code: "invalid_request", Description: err.Error()})
+	}
+
+	// Only service accounts may introspect, users have /profile
+	clientID, clientSecret, basic := c.Request().BasicAuth()
+	if basic {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = u.ClientID, u.ClientSecret
+	}
+
+	_, err := oc.ServiceAccountUsecase.Authenticate(ctx, clientID, clientSecret)
+
+	if err != nil {
+		c.Response().Header().Set("WWW-Authenticate", `Basic realm="introspect"`)
+		return c.JSON(http.StatusUnauthorized, err)
+	}
+
+	if u.Token == "" {
+		return c.JSON(http.StatusBadRequest, &domain.OAuthError{Code: "invalid_request", Description: "token wajib diisi"})
+	}
+
+	return c.JSON(http.StatusOK, oc.OAuthUsecase.Introspect(ctx, u.Token))
+}
+
+func (oc *OAuthControllerImpl) Revoke(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	u := new(domain.RevokeValidation)
+	if err := c.Bind(u); err != nil {
+		return c.JSON(http.StatusBadRequest, &domain.OAuthError{Code: "invalid_request", Description: err.Error()})
+	}
+
+	clientID, clientSecret, basic := c.Request().BasicAuth()
+	if basic {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = u.ClientID, u.ClientSecret
+	}
+
+	// OAuth clients can only revoke their own tokens, without a client_id
+	// only first party tokens from /login are revoked
+	if clientID != "" {
+		_, err := oc.OAuthUsecase.AuthenticateClient(ctx, clientID, clientSecret)
+
+		if err != nil {
+			if basic {
+				c.Response().Header().Set("WWW-Authenticate", `Basic realm="revoke"`)
+			}
+			return c.JSON(http.StatusUnauthorized, err)
+		}
+	}
+
+	if u.Token == "" {
+		return c.JSON(http.StatusBadRequest, &domain.OAuthError{Code: "invalid_request", Description: "token wajib diisi"})
+	}
+
+	oc.UserUsecase.Revoke(ctx, u.Token, u.TokenTypeHint, clientID)
+
+	// Unknown and already revoked tokens get the same answer, see RFC 7009
+	// section 2.2
+	return c.NoContent(http.StatusOK)
+}
+
+func (oc *OAuthControllerImpl) DeviceCode(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	c.Response().Header().Set("Cache-Control", "no-store")
+
+	u := new(domain.DeviceCodeValidation)
+	if err := c.Bind(u); err != nil {
+		return c.JSON(http.StatusBadRequest, &domain.OAuthError{Code: "invalid_request", Description: err.Error()})
+	}
+
+	clientID, clientSecret, basic := c.Request().BasicAuth()
+	if basic {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = u.ClientID, u.ClientSecret
+	}
+
+	client, err := oc.OAuthUsecase.AuthenticateClient(ctx, clientID, clientSecret)
+
+	if err != nil {
+		if basic {
+			c.Response().Header().Set("WWW-Authenticate", `Basic realm="device"`)
+		}
+		return c.JSON(http.StatusUnauthorized, err)
+	}
+
+	response, err := oc.OAuthUsecase.StartDeviceAuthorization(ctx, client, u.Scope)
+
+	if err != nil {
+		var oauthErr *domain.OAuthError
+		if errors.As(err, &oauthErr) {
+			return c.JSON(http.StatusBadRequest, oauthErr)
+		}
+		return c.JSON(http.StatusInternalServerError, &domain.OAuthError{Code: "server_error"})
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// DeviceVerification shows the signed in user which client a user code
+// belongs to, before they approve it with VerifyDevice
+func (oc *OAuthControllerImpl) DeviceVerification(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	// Validation
+	u := new(domain.DeviceVerificationValidation)
+	if err := c.Bind(u); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(u); err != nil {
+		return err
+	}
+
+	device, client, err := oc.OAuthUsecase.GetDeviceAuthorization(ctx, u.UserCode)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusNotFound, response)
+	}
+
+	response := deviceverificationresponse{
+		Error:   false,
+		Message: "Berhasil mengambil data",
+		Data: deviceverification{
+			UserCode:   device.UserCode,
+			ClientID:   client.ClientID,
+			ClientName: client.Name,
+			Scope:      device.Scope,
+		},
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (oc *OAuthControllerImpl) VerifyDevice(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	// Validation
+	u := new(domain.DeviceVerificationValidation)
+	if err := c.Bind(u); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(u); err != nil {
+		return err
+	}
+
+	// Only the user's own sessions may approve, never a token held by some
+	// other OAuth client
+	if clientID, _ := c.Get("client_id").(string); clientID != "" {
+		response := errorresponse{
+			Error:   true,
+			Message: "token client tidak dapat menyetujui perangkat",
+		}
+		return c.JSON(http.StatusForbidden, response)
+	}
+
+	// Get JWT Content
+	user := c.Get("user").(domain.User)
+
+	err := oc.OAuthUsecase.VerifyDevice(ctx, u.UserCode, &user, u.Approve)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusBadRequest, response)
+	}
+
+	message := "Perangkat ditolak"
+	if u.Approve {
+		message = "Perangkat disetujui"
+	}
+
+	response := messageresponse{
+		Error:   false,
+		Message: message,
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (oc *OAuthControllerImpl) clientCredentials(c echo.Context, ctx context.Context, u *domain.TokenValidation, clientID string, clientSecret string, basic bool) error {
+	token, scope, expire, err := oc.ServiceAccountUsecase.IssueToken(ctx, clientID, clientSecret, u.Scope, u.Audience)
+
+	if err != nil {
+		oauthErr := &domain.OAuthError{Code: "server_error"}
+		if !errors.As(err, &oauthErr) {
+			return c.JSON(http.StatusInternalServerError, oauthErr)
+		}
+
+		if oauthErr.Code == "invalid_client" {
+			if basic {
+				c.Response().Header().Set("WWW-Authenticate", `Basic realm="token"`)
+			}
+			return c.JSON(http.StatusUnauthorized, oauthErr)
+		}
+
+		return c.JSON(http.StatusBadRequest, oauthErr)
+	}
+
+	// No refresh token, a service can always ask for a new one
+	response := domain.TokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(expire.Seconds()),
+		Scope:       scope,
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// redirectOAuthError sends an authorization error back to the client, see
+// RFC 6749 section 4.1.2.1
+func redirectOAuthError(c echo.Context, u *domain.AuthorizeValidation, err error) error {
+	oauthErr := &domain.OAuthError{Code: "server_error"}
+	errors.As(err, &oauthErr)
+
+	redirect, _ := url.Parse(u.RedirectURI)
+	query := redirect.Query()
+	query.Set("error", oauthErr.Code)
+	if oauthErr.Description != "" {
+		query.Set("error_description", oauthErr.Description)
+	}
+	if u.State != "" {
+		query.Set("state", u.State)
+	}
+	redirect.RawQuery = query.Encode()
+
+	return c.Redirect(http.StatusFound, redirect.String())
+}
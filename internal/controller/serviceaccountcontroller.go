@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"auth/internal/domain"
+	"auth/internal/usecase"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type createserviceaccountresponse struct {
+	Error          bool                   `json:"error"`
+	Message        string                 `json:"message"`
+	ClientSecret   string                 `json:"client_secret"`
+	ServiceAccount *domain.ServiceAccount `json:"service_account"`
+}
+
+// interface
+type ServiceAccountController interface {
+	Create(ec echo.Context) error
+}
+
+// implement interface
+type ServiceAccountControllerImpl struct {
+	ServiceAccountUsecase usecase.ServiceAccountUseCase
+	UserUsecase           usecase.UserUseCase
+}
+
+func NewServiceAccountController(serviceAccountUsecase usecase.ServiceAccountUseCase, userUsecase usecase.UserUseCase) ServiceAccountController {
+	return &ServiceAccountControllerImpl{
+		ServiceAccountUsecase: serviceAccountUsecase,
+		UserUsecase:           userUsecase,
+	}
+}
+
+func (sc *ServiceAccountControllerImpl) Create(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	// Get JWT Content
+	user := c.Get("user").(domain.User)
+
+	if err := sc.UserUsecase.RequireAdmin(ctx, &user); err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusForbidden, response)
+	}
+
+	// Validation
+	u := new(domain.CreateServiceAccountValidation)
+	if err := c.Bind(u); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(u); err != nil {
+		return err
+	}
+
+	secret, account, err := sc.ServiceAccountUsecase.Create(ctx, u)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusUnprocessableEntity, response)
+	}
+
+	response := createserviceaccountresponse{
+		Error:          false,
+		Message:        "Simpan client secret ini, client secret tidak akan ditampilkan lagi",
+		ClientSecret:   secret,
+		ServiceAccount: account,
+	}
+
+	return c.JSON(http.StatusCreated, response)
+}
@@ -0,0 +1,71 @@
+package controller
+
+import (
+	"auth/internal/domain"
+	"auth/internal/usecase"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type signingkeysresponse struct {
+	Error   bool                 `json:"error"`
+	Message string               `json:"message"`
+	Keys    []*domain.SigningKey `json:"keys"`
+}
+
+// interface
+type SigningKeyController interface {
+	Rotate(ec echo.Context) error
+}
+
+// implement interface
+type SigningKeyControllerImpl struct {
+	SigningKeyUsecase usecase.SigningKeyUseCase
+	UserUsecase       usecase.UserUseCase
+}
+
+func NewSigningKeyController(signingKeyUsecase usecase.SigningKeyUseCase, userUsecase usecase.UserUseCase) SigningKeyController {
+	return &SigningKeyControllerImpl{
+		SigningKeyUsecase: signingKeyUsecase,
+		UserUsecase:       userUsecase,
+	}
+}
+
+func (sc *SigningKeyControllerImpl) Rotate(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	// Get JWT Content
+	user := c.Get("user").(domain.User)
+
+	if err := sc.UserUsecase.RequireAdmin(ctx, &user); err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusForbidden, response)
+	}
+
+	keys, err := sc.SigningKeyUsecase.Rotate(ctx)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusUnprocessableEntity, response)
+	}
+
+	response := signingkeysresponse{
+		Error:   false,
+		Message: "Signing key berhasil dirotasi",
+		Keys:    keys,
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,143 @@
+package controller
+
+import (
+	"auth/internal/domain"
+	"auth/internal/usecase"
+	"auth/internal/utils"
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// upstreamStateCookie binds a provider callback to the browser that started
+// the login
+const upstreamStateCookie = "upstream_state"
+
+// interface
+type UpstreamController interface {
+	Begin(ec echo.Context) error
+	Callback(ec echo.Context) error
+}
+
+// implement interface
+type UpstreamControllerImpl struct {
+	UpstreamUsecase usecase.UpstreamUseCase
+	UserUsecase     usecase.UserUseCase
+}
+
+func NewUpstreamController(upstreamUsecase usecase.UpstreamUseCase, userUsecase usecase.UserUseCase) UpstreamController {
+	return &UpstreamControllerImpl{
+		UpstreamUsecase: upstreamUsecase,
+		UserUsecase:     userUsecase,
+	}
+}
+
+func (uc *UpstreamControllerImpl) Begin(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	redirectURL, state, err := uc.UpstreamUsecase.Begin(ctx, c.Param("provider"))
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusNotFound, response)
+	}
+
+	// Lax, the provider sends the user back with a top level GET
+	c.SetCookie(&http.Cookie{
+		Name:     upstreamStateCookie,
+		Value:    state,
+		Path:     "/login/upstream",
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(os.Getenv("APPLICATION_URL"), "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return c.Redirect(http.StatusFound, redirectURL)
+}
+
+func (uc *UpstreamControllerImpl) Callback(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	u := new(domain.UpstreamCallbackValidation)
+	if err := c.Bind(u); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	browserState := ""
+	if cookie, err := c.Cookie(upstreamStateCookie); err == nil {
+		browserState = cookie.Value
+	}
+
+	// The state is spent either way
+	c.SetCookie(&http.Cookie{
+		Name:   upstreamStateCookie,
+		Path:   "/login/upstream",
+		MaxAge: -1,
+	})
+
+	login, uuidGen, mfaToken, err := uc.UpstreamUsecase.Callback(ctx, c.Param("provider"), u, browserState)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusUnauthorized, response)
+	}
+
+	// Second factor required, finish with /login/mfa
+	if mfaToken != "" {
+		response := mfarequiredresponse{
+			Error:       false,
+			Message:     "Masukkan kode MFA",
+			MfaRequired: true,
+			MfaToken:    mfaToken,
+		}
+		return c.JSON(http.StatusOK, response)
+	}
+
+	// Generate Token
+	token, err := utils.GenerateToken(ctx, login, uuidGen)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+
+	refreshToken, err := uc.UserUsecase.IssueRefreshToken(ctx, login, uuidGen)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+
+	response := loginresponse{
+		Error:        false,
+		Message:      "Berhasil login",
+		Data:         login,
+		Token:        token,
+		RefreshToken: refreshToken,
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,186 @@
+package controller
+
+import (
+	"auth/internal/domain"
+	"auth/internal/usecase"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type userattributesresponse struct {
+	Error      bool                    `json:"error"`
+	Message    string                  `json:"message"`
+	Attributes []*domain.UserAttribute `json:"attributes"`
+}
+
+type userattributeresponse struct {
+	Error     bool                  `json:"error"`
+	Message   string                `json:"message"`
+	Attribute *domain.UserAttribute `json:"attribute"`
+}
+
+// interface
+type UserAttributeController interface {
+	List(ec echo.Context) error
+	Set(ec echo.Context) error
+	Delete(ec echo.Context) error
+}
+
+// implement interface
+type UserAttributeControllerImpl struct {
+	UserAttributeUsecase usecase.UserAttributeUseCase
+	UserUsecase          usecase.UserUseCase
+}
+
+func NewUserAttributeController(userAttributeUsecase usecase.UserAttributeUseCase, userUsecase usecase.UserUseCase) UserAttributeController {
+	return &UserAttributeControllerImpl{
+		UserAttributeUsecase: userAttributeUsecase,
+		UserUsecase:          userUsecase,
+	}
+}
+
+// attributeStatus is the status an attribute error is answered with
+func attributeStatus(err error, fallback int) int {
+	if errors.Is(err, domain.ErrUserNotFound) || errors.Is(err, domain.ErrUserAttributeNotFound) {
+		return http.StatusNotFound
+	}
+
+	return fallback
+}
+
+func (ac *UserAttributeControllerImpl) List(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	// Get JWT Content
+	admin := c.Get("user").(domain.User)
+
+	if err := ac.UserUsecase.RequireAdmin(ctx, &admin); err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusForbidden, response)
+	}
+
+	userID, err := strconv.Atoi(c.Param("id"))
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "user id tidak valid")
+	}
+
+	attributes, err := ac.UserAttributeUsecase.List(ctx, userID)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(attributeStatus(err, http.StatusInternalServerError), response)
+	}
+
+	response := userattributesresponse{
+		Error:      false,
+		Message:    "Berhasil mengambil atribut user",
+		Attributes: attributes,
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (ac *UserAttributeControllerImpl) Set(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	// Get JWT Content
+	admin := c.Get("user").(domain.User)
+
+	if err := ac.UserUsecase.RequireAdmin(ctx, &admin); err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusForbidden, response)
+	}
+
+	userID, err := strconv.Atoi(c.Param("id"))
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "user id tidak valid")
+	}
+
+	// Validation
+	u := new(domain.SetUserAttributeValidation)
+	if err := c.Bind(u); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(u); err != nil {
+		return err
+	}
+
+	attribute, err := ac.UserAttributeUsecase.Set(ctx, userID, c.Param("name"), u.Value)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(attributeStatus(err, http.StatusUnprocessableEntity), response)
+	}
+
+	response := userattributeresponse{
+		Error:     false,
+		Message:   "Atribut berhasil disimpan, berlaku pada token berikutnya",
+		Attribute: attribute,
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (ac *UserAttributeControllerImpl) Delete(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	// Get JWT Content
+	admin := c.Get("user").(domain.User)
+
+	if err := ac.UserUsecase.RequireAdmin(ctx, &admin); err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusForbidden, response)
+	}
+
+	userID, err := strconv.Atoi(c.Param("id"))
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "user id tidak valid")
+	}
+
+	if err := ac.UserAttributeUsecase.Delete(ctx, userID, c.Param("name")); err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(attributeStatus(err, http.StatusInternalServerError), response)
+	}
+
+	response := errorresponse{
+		Error:   false,
+		Message: "Atribut berhasil dihapus",
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
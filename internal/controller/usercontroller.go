@@ -5,18 +5,23 @@ import (
 	"auth/internal/usecase"
 	"auth/internal/utils"
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
 )
 
 type registerresponse struct {
-	Error   bool   `json:"error"`
-	Message string `json:"message"`
-	Data    any    `json:"data"`
-	Token   any    `json:"token"`
+	Error        bool   `json:"error"`
+	Message      string `json:"message"`
+	Data         any    `json:"data"`
+	Token        any    `json:"token"`
+	RefreshToken string `json:"refresh_token"`
 }
 
 type profileresponse struct {
@@ -26,10 +31,49 @@ type profileresponse struct {
 }
 
 type loginresponse struct {
-	Error   bool `json:"error"`
-	Message any  `json:"message"`
-	Data    any  `json:"data"`
-	Token   any  `json:"token"`
+	Error        bool   `json:"error"`
+	Message      any    `json:"message"`
+	Data         any    `json:"data"`
+	Token        any    `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type mfarequiredresponse struct {
+	Error       bool   `json:"error"`
+	Message     string `json:"message"`
+	MfaRequired bool   `json:"mfa_required"`
+	MfaToken    string `json:"mfa_token"`
+}
+
+type magiclinkresponse struct {
+	Error   bool   `json:"error"`
+	Message string `json:"message"`
+	Nonce   string `json:"nonce"`
+}
+
+type impersonateresponse struct {
+	Error     bool         `json:"error"`
+	Message   string       `json:"message"`
+	Data      *domain.User `json:"data"`
+	Token     any          `json:"token"`
+	ExpiresIn int          `json:"expires_in"`
+}
+
+type sessionsresponse struct {
+	Error   bool              `json:"error"`
+	Message string            `json:"message"`
+	Data    []*domain.Session `json:"data"`
+}
+
+type revokedresponse struct {
+	Error   bool     `json:"error"`
+	Message string   `json:"message"`
+	Revoked []string `json:"revoked"`
+}
+
+type messageresponse struct {
+	Error   bool   `json:"error"`
+	Message string `json:"message"`
 }
 
 type errorresponse struct {
@@ -43,6 +87,19 @@ type UserController interface {
 	Register(ec echo.Context) error
 	Profile(ec echo.Context) error
 	Logout(ec echo.Context) error
+	RefreshToken(ec echo.Context) error
+	VerifyEmail(ec echo.Context) error
+	ResendVerification(ec echo.Context) error
+	ForgotPassword(ec echo.Context) error
+	ResetPassword(ec echo.Context) error
+	ChangePassword(ec echo.Context) error
+	RequestMagicLink(ec echo.Context) error
+	ConsumeMagicLink(ec echo.Context) error
+	Impersonate(ec echo.Context) error
+	Sessions(ec echo.Context) error
+	RevokeSession(ec echo.Context) error
+	LogoutAll(ec echo.Context) error
+	AdminLogoutAll(ec echo.Context) error
 }
 
 // implement interface
@@ -83,14 +140,44 @@ func (uc *UserControllerImpl) Register(c echo.Context) error {
 		return c.JSON(http.StatusUnprocessableEntity, response)
 	}
 
+	// The account has to be verified before it gets a session
+	if uuid == "" {
+		response := registerresponse{
+			Error:   false,
+			Message: "Berhasil mendaftar, silakan verifikasi email",
+			Data:    user,
+		}
+
+		return c.JSON(http.StatusOK, response)
+	}
+
 	// Generate Token
-	token, _ := utils.GenerateToken(user, uuid)
+	token, err := utils.GenerateToken(ctx, user, uuid)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+
+	refreshToken, err := uc.UserUsecase.IssueRefreshToken(ctx, user, uuid)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
 
 	response := registerresponse{
-		Error:   false,
-		Message: "Berhasil mendaftar",
-		Data:    user,
-		Token:   token,
+		Error:        false,
+		Message:      "Berhasil mendaftar",
+		Data:         user,
+		Token:        token,
+		RefreshToken: refreshToken,
 	}
 
 	return c.JSON(http.StatusOK, response)
@@ -112,7 +199,7 @@ func (uc *UserControllerImpl) Login(c echo.Context) error {
 	}
 
 	// Check credentials
-	login, uuidGen, err := uc.UserUsecase.Login(ctx, u)
+	login, uuidGen, mfaToken, err := uc.UserUsecase.Login(ctx, u)
 
 	if err != nil {
 		response := errorresponse{
@@ -122,14 +209,44 @@ func (uc *UserControllerImpl) Login(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, response)
 	}
 
+	// Second factor required, finish with /login/mfa
+	if mfaToken != "" {
+		response := mfarequiredresponse{
+			Error:       false,
+			Message:     "Masukkan kode MFA",
+			MfaRequired: true,
+			MfaToken:    mfaToken,
+		}
+		return c.JSON(http.StatusOK, response)
+	}
+
 	// Generate Token
-	token, _ := utils.GenerateToken(login, uuidGen)
+	token, err := utils.GenerateToken(ctx, login, uuidGen)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+
+	refreshToken, err := uc.UserUsecase.IssueRefreshToken(ctx, login, uuidGen)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
 
 	response := loginresponse{
-		Error:   false,
-		Message: "Berhasil login",
-		Data:    login,
-		Token:   token,
+		Error:        false,
+		Message:      "Berhasil login",
+		Data:         login,
+		Token:        token,
+		RefreshToken: refreshToken,
 	}
 
 	return c.JSON(http.StatusOK, response)
@@ -162,7 +279,13 @@ func (uc *UserControllerImpl) Logout(c echo.Context) error {
 	// Get JWT Content
 	uuid := c.Get("uuid").(string)
 
-	uc.UserUsecase.Logout(con, uuid)
+	// Logging out of an impersonated session ends the impersonation
+	if actor, _ := c.Get("act").(string); actor != "" {
+		adminID, _ := strconv.Atoi(actor)
+		uc.UserUsecase.StopImpersonation(con, uuid, adminID)
+	} else {
+		uc.UserUsecase.Logout(con, uuid)
+	}
 
 	response := &profileresponse{
 		Error:   false,
@@ -171,3 +294,532 @@ func (uc *UserControllerImpl) Logout(c echo.Context) error {
 
 	return c.JSON(http.StatusOK, response)
 }
+
+func (uc *UserControllerImpl) RefreshToken(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	// Validation
+	u := new(domain.RefreshValidation)
+	if err := c.Bind(u); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(u); err != nil {
+		return err
+	}
+
+	// Rotate refresh token
+	user, uuidGen, refreshToken, _, err := uc.UserUsecase.Refresh(ctx, u.RefreshToken, "")
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusUnauthorized, response)
+	}
+
+	// Generate Token
+	token, err := utils.GenerateToken(ctx, user, uuidGen)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+
+	response := loginresponse{
+		Error:        false,
+		Message:      "Berhasil memperbarui token",
+		Data:         user,
+		Token:        token,
+		RefreshToken: refreshToken,
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (uc *UserControllerImpl) VerifyEmail(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	// Validation
+	u := new(domain.VerifyEmailValidation)
+	if err := c.Bind(u); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(u); err != nil {
+		return err
+	}
+
+	err := uc.UserUsecase.VerifyEmail(ctx, u.Token)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusUnprocessableEntity, response)
+	}
+
+	response := messageresponse{
+		Error:   false,
+		Message: "Email berhasil diverifikasi",
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (uc *UserControllerImpl) ResendVerification(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	// Validation
+	u := new(domain.ResendVerificationValidation)
+	if err := c.Bind(u); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(u); err != nil {
+		return err
+	}
+
+	err := uc.UserUsecase.ResendVerification(ctx, u.Email)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+
+	response := messageresponse{
+		Error:   false,
+		Message: "Jika email terdaftar dan belum diverifikasi, link verifikasi telah dikirim",
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (uc *UserControllerImpl) ForgotPassword(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	// Validation
+	u := new(domain.ForgotPasswordValidation)
+	if err := c.Bind(u); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(u); err != nil {
+		return err
+	}
+
+	err := uc.UserUsecase.ForgotPassword(ctx, u.Email)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+
+	response := messageresponse{
+		Error:   false,
+		Message: "Jika email terdaftar, link reset password telah dikirim",
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (uc *UserControllerImpl) ResetPassword(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	// Validation
+	u := new(domain.ResetPasswordValidation)
+	if err := c.Bind(u); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(u); err != nil {
+		return err
+	}
+
+	err := uc.UserUsecase.ResetPassword(ctx, u)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusUnprocessableEntity, response)
+	}
+
+	response := messageresponse{
+		Error:   false,
+		Message: "Password berhasil direset",
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (uc *UserControllerImpl) ChangePassword(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	// Validation
+	u := new(domain.ChangePasswordValidation)
+	if err := c.Bind(u); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(u); err != nil {
+		return err
+	}
+
+	// Get JWT Content
+	user := c.Get("user").(domain.User)
+	uuid := c.Get("uuid").(string)
+
+	err := uc.UserUsecase.ChangePassword(ctx, user.ID, uuid, u)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusUnprocessableEntity, response)
+	}
+
+	response := messageresponse{
+		Error:   false,
+		Message: "Password berhasil diubah",
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// magicLinkNonceCookie binds a magic link to the browser that asked for it
+const magicLinkNonceCookie = "magic_link_nonce"
+
+func (uc *UserControllerImpl) RequestMagicLink(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	// Validation
+	u := new(domain.MagicLinkValidation)
+	if err := c.Bind(u); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(u); err != nil {
+		return err
+	}
+
+	nonce, err := uc.UserUsecase.RequestMagicLink(ctx, u.Email)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     magicLinkNonceCookie,
+		Value:    nonce,
+		Path:     "/login/magic-link",
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(os.Getenv("APPLICATION_URL"), "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	response := magiclinkresponse{
+		Error:   false,
+		Message: "Jika email terdaftar, link login telah dikirim",
+		Nonce:   nonce,
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (uc *UserControllerImpl) ConsumeMagicLink(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	// Validation
+	u := new(domain.ConsumeMagicLinkValidation)
+	if err := c.Bind(u); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(u); err != nil {
+		return err
+	}
+
+	// Browsers send the nonce back as a cookie, other clients in the body
+	if cookie, err := c.Cookie(magicLinkNonceCookie); err == nil && u.Nonce == "" {
+		u.Nonce = cookie.Value
+	}
+
+	login, uuidGen, mfaToken, err := uc.UserUsecase.ConsumeMagicLink(ctx, u)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusUnauthorized, response)
+	}
+
+	// The nonce is spent either way
+	c.SetCookie(&http.Cookie{
+		Name:   magicLinkNonceCookie,
+		Path:   "/login/magic-link",
+		MaxAge: -1,
+	})
+
+	// Second factor required, finish with /login/mfa
+	if mfaToken != "" {
+		response := mfarequiredresponse{
+			Error:       false,
+			Message:     "Masukkan kode MFA",
+			MfaRequired: true,
+			MfaToken:    mfaToken,
+		}
+		return c.JSON(http.StatusOK, response)
+	}
+
+	// Generate Token
+	token, err := utils.GenerateToken(ctx, login, uuidGen)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+
+	refreshToken, err := uc.UserUsecase.IssueRefreshToken(ctx, login, uuidGen)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+
+	response := loginresponse{
+		Error:        false,
+		Message:      "Berhasil login",
+		Data:         login,
+		Token:        token,
+		RefreshToken: refreshToken,
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (uc *UserControllerImpl) Impersonate(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	userID, err := strconv.Atoi(c.Param("userID"))
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "user id tidak valid")
+	}
+
+	// Get JWT Content
+	admin := c.Get("user").(domain.User)
+
+	user, uuidGen, expire, err := uc.UserUsecase.Impersonate(ctx, &admin, userID)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusForbidden, response)
+	}
+
+	// No refresh token, the session ends when the token expires
+	token, err := utils.GenerateImpersonationToken(ctx, user, uuidGen, &admin, expire)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+
+	response := impersonateresponse{
+		Error:     false,
+		Message:   "Berhasil impersonasi",
+		Data:      user,
+		Token:     token,
+		ExpiresIn: int(expire.Seconds()),
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (uc *UserControllerImpl) Sessions(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	// Get JWT Content
+	user := c.Get("user").(domain.User)
+	uuid := c.Get("uuid").(string)
+
+	sessions, err := uc.UserUsecase.ListSessions(ctx, user.ID, uuid)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+
+	response := sessionsresponse{
+		Error:   false,
+		Message: "Berhasil mengambil data",
+		Data:    sessions,
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (uc *UserControllerImpl) RevokeSession(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	// Get JWT Content
+	user := c.Get("user").(domain.User)
+
+	err := uc.UserUsecase.RevokeSession(ctx, user.ID, c.Param("id"))
+
+	if errors.Is(err, domain.ErrSessionNotFound) {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusNotFound, response)
+	}
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: "Gagal mencabut sesi",
+		}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+
+	response := messageresponse{
+		Error:   false,
+		Message: "Sesi berhasil dicabut",
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (uc *UserControllerImpl) LogoutAll(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	// Get JWT Content
+	user := c.Get("user").(domain.User)
+
+	revoked, err := uc.UserUsecase.LogoutAll(ctx, user.ID)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+
+	response := revokedresponse{
+		Error:   false,
+		Message: "Berhasil logout dari semua perangkat",
+		Revoked: revoked,
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (uc *UserControllerImpl) AdminLogoutAll(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	userID, err := strconv.Atoi(c.Param("id"))
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "user id tidak valid")
+	}
+
+	// Get JWT Content
+	admin := c.Get("user").(domain.User)
+
+	revoked, err := uc.UserUsecase.AdminLogoutAll(ctx, &admin, userID)
+
+	if errors.Is(err, domain.ErrNotAdmin) || errors.Is(err, domain.ErrUserNotFound) {
+		status := http.StatusForbidden
+		if errors.Is(err, domain.ErrUserNotFound) {
+			status = http.StatusNotFound
+		}
+
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(status, response)
+	}
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: "Gagal mencabut sesi user",
+		}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+
+	response := revokedresponse{
+		Error:   false,
+		Message: "Semua sesi user berhasil dicabut",
+		Revoked: revoked,
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,199 @@
+package controller
+
+import (
+	"auth/internal/domain"
+	"auth/internal/usecase"
+	"auth/internal/utils"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type webauthnoptionsresponse struct {
+	Error     bool   `json:"error"`
+	Message   string `json:"message"`
+	PublicKey any    `json:"publicKey"`
+}
+
+type webauthncredentialresponse struct {
+	Error      bool                       `json:"error"`
+	Message    string                     `json:"message"`
+	Credential *domain.WebauthnCredential `json:"credential"`
+}
+
+// interface
+type WebauthnController interface {
+	BeginRegistration(ec echo.Context) error
+	FinishRegistration(ec echo.Context) error
+	BeginLogin(ec echo.Context) error
+	FinishLogin(ec echo.Context) error
+}
+
+// implement interface
+type WebauthnControllerImpl struct {
+	WebauthnUsecase usecase.WebauthnUseCase
+	UserUsecase     usecase.UserUseCase
+}
+
+func NewWebauthnController(webauthnUsecase usecase.WebauthnUseCase, userUsecase usecase.UserUseCase) WebauthnController {
+	return &WebauthnControllerImpl{
+		WebauthnUsecase: webauthnUsecase,
+		UserUsecase:     userUsecase,
+	}
+}
+
+func (wc *WebauthnControllerImpl) BeginRegistration(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	// Get JWT Content
+	user := c.Get("user").(domain.User)
+
+	options, err := wc.WebauthnUsecase.BeginRegistration(ctx, &user)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+
+	response := webauthnoptionsresponse{
+		Error:     false,
+		Message:   "Berhasil membuat challenge passkey",
+		PublicKey: options,
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (wc *WebauthnControllerImpl) FinishRegistration(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	// Validation
+	u := new(domain.WebauthnRegistrationValidation)
+	if err := c.Bind(u); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(u); err != nil {
+		return err
+	}
+
+	// Get JWT Content
+	user := c.Get("user").(domain.User)
+
+	credential, err := wc.WebauthnUsecase.FinishRegistration(ctx, &user, u)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusUnprocessableEntity, response)
+	}
+
+	response := webauthncredentialresponse{
+		Error:      false,
+		Message:    "Passkey berhasil didaftarkan",
+		Credential: credential,
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (wc *WebauthnControllerImpl) BeginLogin(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	// Validation
+	u := new(domain.WebauthnLoginBeginValidation)
+	if err := c.Bind(u); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	options, err := wc.WebauthnUsecase.BeginLogin(ctx, u.Username)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+
+	response := webauthnoptionsresponse{
+		Error:     false,
+		Message:   "Berhasil membuat challenge passkey",
+		PublicKey: options,
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (wc *WebauthnControllerImpl) FinishLogin(c echo.Context) error {
+	// Convert echo context
+	con := c.Request().Context()
+	ctx, cancel := context.WithTimeout(con, 10000*time.Second)
+	defer cancel()
+
+	// Validation
+	u := new(domain.WebauthnLoginValidation)
+	if err := c.Bind(u); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(u); err != nil {
+		return err
+	}
+
+	// Check assertion
+	login, uuidGen, err := wc.WebauthnUsecase.FinishLogin(ctx, u)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusUnauthorized, response)
+	}
+
+	// Generate Token
+	token, err := utils.GenerateToken(ctx, login, uuidGen)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+
+	refreshToken, err := wc.UserUsecase.IssueRefreshToken(ctx, login, uuidGen)
+
+	if err != nil {
+		response := errorresponse{
+			Error:   true,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+
+	response := loginresponse{
+		Error:        false,
+		Message:      "Berhasil login",
+		Data:         login,
+		Token:        token,
+		RefreshToken: refreshToken,
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
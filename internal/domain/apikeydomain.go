@@ -0,0 +1,32 @@
+package domain
+
+import "time"
+
+// ApiKeyPrefix starts every API key, so they are told apart from JWTs at a
+// glance and can be found by secret scanners
+const ApiKeyPrefix = "pat_"
+
+// ApiKeyScopes are the scopes a key may be limited to. A key created without
+// any gets all of them.
+var ApiKeyScopes = []string{"openid", "profile", "email"}
+
+// ApiKey is a long-lived credential a user created for scripts. Only the hash
+// of the key is stored, Prefix is kept to recognise it in listings.
+type ApiKey struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `json:"last_used_ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type CreateApiKeyValidation struct {
+	Name          string   `json:"name" validate:"required"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days" validate:"gte=0"`
+}
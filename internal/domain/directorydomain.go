@@ -0,0 +1,28 @@
+package domain
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrDirectoryUserNotFound is returned when no directory entry matches the
+// submitted username
+var ErrDirectoryUserNotFound = errors.New("directory: user not found")
+
+// ErrDirectoryInvalidCredentials is returned when the directory refuses the
+// password
+var ErrDirectoryInvalidCredentials = errors.New("directory: invalid credentials")
+
+// Directory is an external user directory that checks passwords itself
+type Directory interface {
+	Authenticate(ctx context.Context, username string, password string) (*DirectoryEntry, error)
+}
+
+// DirectoryEntry holds the attributes of a directory user we keep locally
+type DirectoryEntry struct {
+	DN       string
+	Username string
+	Email    string
+	Name     string
+	Groups   []string
+}
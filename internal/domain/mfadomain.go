@@ -0,0 +1,26 @@
+package domain
+
+import "time"
+
+type TOTP struct {
+	UserID      int
+	Secret      string
+	ConfirmedAt *time.Time
+}
+
+type (
+	ConfirmTOTPValidation struct {
+		Code string `json:"code" validate:"required"`
+	}
+
+	DisableTOTPValidation struct {
+		// Not asked of users who only sign in through a provider
+		Password string `json:"password"`
+		Code     string `json:"code" validate:"required"`
+	}
+
+	LoginMfaValidation struct {
+		MfaToken string `json:"mfa_token" validate:"required"`
+		Code     string `json:"code" validate:"required"`
+	}
+)
@@ -0,0 +1,203 @@
+package domain
+
+import "time"
+
+// OAuthClient is an application registered to use the OAuth endpoints.
+// Public clients, such as SPAs and mobile apps, have no secret.
+type OAuthClient struct {
+	ID            int       `json:"id"`
+	ClientID      string    `json:"client_id"`
+	Name          string    `json:"name"`
+	SecretHash    string    `json:"-"`
+	RedirectURIs  []string  `json:"redirect_uris"`
+	AllowedScopes []string  `json:"allowed_scopes"`
+	GrantTypes    []string  `json:"grant_types"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// OAuthScopes are the scopes a client may be allowed
+var OAuthScopes = []string{"openid", "profile", "email"}
+
+// OAuthClientGrantTypes are the grants a client may be registered for, a
+// client registered without any gets the authorization code grants
+var OAuthClientGrantTypes = []string{"authorization_code", "refresh_token", GrantTypeDeviceCode}
+
+type CreateOAuthClientValidation struct {
+	ClientID     string   `json:"client_id" validate:"required"`
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes"`
+	GrantTypes   []string `json:"grant_types"`
+	// Public clients get no secret, PKCE protects their codes instead
+	Public bool `json:"public"`
+}
+
+// AuthorizationCode is what we remember about an issued authorization code.
+// Nonce and AuthTime are carried over into the id_token.
+type AuthorizationCode struct {
+	ClientID      string `json:"client_id"`
+	RedirectURI   string `json:"redirect_uri"`
+	Scope         string `json:"scope"`
+	UserID        int    `json:"user_id"`
+	CodeChallenge string `json:"code_challenge"`
+	Nonce         string `json:"nonce,omitempty"`
+	AuthTime      int64  `json:"auth_time,omitempty"`
+}
+
+// GrantTypeDeviceCode is the grant_type of RFC 8628 device access token
+// requests
+const GrantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+// Device authorization statuses
+const (
+	DeviceStatusPending  = "pending"
+	DeviceStatusApproved = "approved"
+	DeviceStatusDenied   = "denied"
+)
+
+// DeviceAuthorization is what we remember about a pending device flow, see
+// RFC 8628. ID is the hash of the device code.
+type DeviceAuthorization struct {
+	ID       string `json:"id"`
+	ClientID string `json:"client_id"`
+	Scope    string `json:"scope"`
+	UserCode string `json:"user_code"`
+	Status   string `json:"status"`
+	UserID   int    `json:"user_id,omitempty"`
+	Interval int    `json:"interval"`
+}
+
+// DeviceCodeResponse is a device authorization response of RFC 8628 section
+// 3.2
+type DeviceCodeResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// OAuthError is an error response of RFC 6749 section 5.2
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *OAuthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+type (
+	AuthorizeValidation struct {
+		ResponseType        string `query:"response_type" form:"response_type"`
+		ClientID            string `query:"client_id" form:"client_id"`
+		RedirectURI         string `query:"redirect_uri" form:"redirect_uri"`
+		Scope               string `query:"scope" form:"scope"`
+		State               string `query:"state" form:"state"`
+		CodeChallenge       string `query:"code_challenge" form:"code_challenge"`
+		CodeChallengeMethod string `query:"code_challenge_method" form:"code_challenge_method"`
+		Nonce               string `query:"nonce" form:"nonce"`
+		Username            string `form:"username"`
+		Password            string `form:"password"`
+		// allow or deny, the user's answer to the consent prompt
+		Consent string `form:"consent"`
+	}
+
+	TokenValidation struct {
+		GrantType    string `form:"grant_type"`
+		Code         string `form:"code"`
+		RedirectURI  string `form:"redirect_uri"`
+		CodeVerifier string `form:"code_verifier"`
+		RefreshToken string `form:"refresh_token"`
+		DeviceCode   string `form:"device_code"`
+		Scope        string `form:"scope"`
+		Audience     string `form:"audience"`
+		ClientID     string `form:"client_id"`
+		ClientSecret string `form:"client_secret"`
+	}
+
+	DeviceCodeValidation struct {
+		ClientID     string `form:"client_id"`
+		ClientSecret string `form:"client_secret"`
+		Scope        string `form:"scope"`
+	}
+
+	DeviceVerificationValidation struct {
+		UserCode string `json:"user_code" query:"user_code" validate:"required"`
+		Approve  bool   `json:"approve"`
+	}
+
+	RevokeValidation struct {
+		Token         string `form:"token"`
+		TokenTypeHint string `form:"token_type_hint"`
+		ClientID      string `form:"client_id"`
+		ClientSecret  string `form:"client_secret"`
+	}
+
+	IntrospectValidation struct {
+		Token         string `form:"token"`
+		TokenTypeHint string `form:"token_type_hint"`
+		ClientID      string `form:"client_id"`
+		ClientSecret  string `form:"client_secret"`
+	}
+)
+
+// TokenResponse is a successful response of RFC 6749 section 5.1
+type TokenResponse struct {
+	AccessToken  any    `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      any    `json:"id_token,omitempty"`
+}
+
+// UserInfo holds the OpenID Connect standard claims of a user. Which of them
+// are set depends on the scope that was granted.
+type UserInfo struct {
+	Subject           string `json:"sub"`
+	Name              string `json:"name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+}
+
+// OpenIDConfiguration is the discovery document of OpenID Connect Discovery
+// 1.0 section 3
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+	JwksURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// Introspection is a token introspection response of RFC 7662 section 2.2.
+// Inactive tokens are reported with every other member left out. User is the
+// session's user snapshot, only set for user tokens.
+type Introspection struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	Exp       int64    `json:"exp,omitempty"`
+	Iat       int64    `json:"iat,omitempty"`
+	Sub       string   `json:"sub,omitempty"`
+	Aud       []string `json:"aud,omitempty"`
+	Iss       string   `json:"iss,omitempty"`
+	Jti       string   `json:"jti,omitempty"`
+	User      *User    `json:"user,omitempty"`
+}
@@ -0,0 +1,35 @@
+package domain
+
+import "time"
+
+// ServiceAccount is a backend service allowed to get tokens of its own through
+// the client_credentials grant. Audiences are the services its tokens may be
+// presented to.
+type ServiceAccount struct {
+	ID            int       `json:"id"`
+	ClientID      string    `json:"client_id"`
+	Name          string    `json:"name"`
+	SecretHash    string    `json:"-"`
+	AllowedScopes []string  `json:"allowed_scopes"`
+	Audiences     []string  `json:"audiences"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type CreateServiceAccountValidation struct {
+	ClientID  string   `json:"client_id" validate:"required"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	Audiences []string `json:"audiences"`
+}
+
+type PublishServiceToken struct {
+	Data   ServiceTokenAction
+	Action string
+}
+
+type ServiceTokenAction struct {
+	ClientID string
+	Scope    string
+	Audience []string
+	Exp      time.Duration
+}
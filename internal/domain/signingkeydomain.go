@@ -0,0 +1,24 @@
+package domain
+
+import "time"
+
+// States a signing key goes through. A next key is already published so that
+// verifiers know it before it signs anything, a retiring key still verifies
+// the tokens it signed while it was active.
+const (
+	SigningKeyNext     = "next"
+	SigningKeyActive   = "active"
+	SigningKeyRetiring = "retiring"
+	SigningKeyRetired  = "retired"
+)
+
+// SigningKey is a token signing key in the key store. PrivateKey is the
+// encrypted PKCS #8 key.
+type SigningKey struct {
+	ID             string    `json:"id"`
+	Algorithm      string    `json:"algorithm"`
+	PrivateKey     []byte    `json:"-"`
+	State          string    `json:"state"`
+	CreatedAt      time.Time `json:"created_at"`
+	StateChangedAt time.Time `json:"state_changed_at"`
+}
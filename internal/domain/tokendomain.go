@@ -0,0 +1,29 @@
+package domain
+
+// RefreshFamily groups every refresh token that descends from a single login.
+// Uuid is the Redis session currently backed by the family. ClientID and
+// Scope are set when the login happened through an OAuth client. A revoked
+// family is kept until it expires, so its tokens are recognised when replayed.
+type RefreshFamily struct {
+	ID       string
+	Uuid     string
+	UserID   int
+	ClientID string
+	Scope    string
+	Revoked  bool
+}
+
+type RefreshValidation struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+type PublishAuthRefreshReuse struct {
+	Data   RefreshReuseAction
+	Action string
+}
+
+type RefreshReuseAction struct {
+	Family string
+	Uuid   string
+	UserID int
+}
@@ -0,0 +1,37 @@
+package domain
+
+import "context"
+
+// UpstreamProvider is an external identity provider users can sign in with.
+// Implementations run the authorization code flow with PKCE against it.
+type UpstreamProvider interface {
+	Name() string
+	AuthCodeURL(state string, nonce string, codeVerifier string) (string, error)
+	Exchange(ctx context.Context, code string, codeVerifier string, nonce string) (*UpstreamIdentity, error)
+}
+
+// UpstreamIdentity is who a provider says the user is, Subject is only
+// unique within that provider
+type UpstreamIdentity struct {
+	Provider          string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+// UpstreamState is what we remember between sending a user to a provider and
+// the provider sending them back
+type UpstreamState struct {
+	Provider     string `json:"provider"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+}
+
+type UpstreamCallbackValidation struct {
+	State            string `query:"state"`
+	Code             string `query:"code"`
+	Error            string `query:"error"`
+	ErrorDescription string `query:"error_description"`
+}
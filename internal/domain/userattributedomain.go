@@ -0,0 +1,23 @@
+package domain
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// ErrUserAttributeNotFound is returned when a user has no attribute by the
+// name asked for
+var ErrUserAttributeNotFound = errors.New("atribut tidak ditemukan")
+
+// UserAttribute is a value kept about a user, such as their tenant or roles.
+// Attributes end up as claims of the user's tokens when
+// JWT_ATTRIBUTE_CLAIMS is true.
+type UserAttribute struct {
+	UserID int             `json:"-"`
+	Name   string          `json:"name"`
+	Value  json.RawMessage `json:"value"`
+}
+
+type SetUserAttributeValidation struct {
+	Value json.RawMessage `json:"value" validate:"required"`
+}
@@ -1,15 +1,38 @@
 package domain
 
-import "time"
+import (
+	"errors"
+	"time"
+)
+
+// ErrUserNotFound is returned when the user an admin acts on does not exist
+var ErrUserNotFound = errors.New("user tidak ditemukan")
+
+// ErrNotAdmin is returned when someone who is not an admin tries an admin
+// action
+var ErrNotAdmin = errors.New("hanya admin yang dapat melakukan aksi ini")
+
+// ErrSessionNotFound is returned when a user has no session by the uuid given
+var ErrSessionNotFound = errors.New("sesi tidak ditemukan")
 
 type User struct {
-	ID       int    `json:"id"`
-	Email    string `json:"email"`
-	Name     string `json:"name"`
-	Username string `json:"username"`
-	Password string `json:"-"`
+	ID              int        `json:"id"`
+	Email           string     `json:"email"`
+	Name            string     `json:"name"`
+	Username        string     `json:"username"`
+	Password        string     `json:"-"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	AuthSource      string     `json:"auth_source"`
+	Groups          []string   `json:"groups"`
+	IsAdmin         bool       `json:"is_admin"`
 }
 
+// Where a user's password is checked
+const (
+	AuthSourceLocal = "local"
+	AuthSourceLDAP  = "ldap"
+)
+
 type (
 	RegisterValidation struct {
 		Name     string `json:"name" validate:"required"`
@@ -22,6 +45,38 @@ type (
 		Username string `json:"username" validate:"required"`
 		Password string `json:"password" validate:"required"`
 	}
+
+	VerifyEmailValidation struct {
+		Token string `json:"token" validate:"required"`
+	}
+
+	ResendVerificationValidation struct {
+		Email string `json:"email" validate:"required,email"`
+	}
+
+	ForgotPasswordValidation struct {
+		Email string `json:"email" validate:"required,email"`
+	}
+
+	ResetPasswordValidation struct {
+		Token    string `json:"token" validate:"required"`
+		Password string `json:"password" validate:"required"`
+	}
+
+	MagicLinkValidation struct {
+		Email string `json:"email" validate:"required,email"`
+	}
+
+	ConsumeMagicLinkValidation struct {
+		Token string `json:"token" validate:"required"`
+		Nonce string `json:"nonce"`
+	}
+
+	ChangePasswordValidation struct {
+		CurrentPassword     string `json:"current_password" validate:"required"`
+		NewPassword         string `json:"new_password" validate:"required"`
+		RevokeOtherSessions bool   `json:"revoke_other_sessions"`
+	}
 )
 
 type UserResponseAuthService struct {
@@ -39,6 +94,16 @@ type LogoutAction struct {
 	Uuid string
 }
 
+type PublishAuthPasswordReset struct {
+	Data   PasswordResetAction
+	Action string
+}
+
+type PasswordResetAction struct {
+	UserID   int
+	Sessions []string
+}
+
 type PublishAuthLogin struct {
 	Data   LoginAction
 	Action string
@@ -49,3 +114,43 @@ type LoginAction struct {
 	User User
 	Exp  time.Duration
 }
+
+// MagicLink is what we remember about a sign-in link we mailed. Nonce is the
+// hash of the nonce handed to the browser that asked for it.
+type MagicLink struct {
+	UserID int    `json:"user_id"`
+	Nonce  string `json:"nonce"`
+}
+
+type PublishImpersonation struct {
+	Data   ImpersonationAction
+	Action string
+}
+
+// ImpersonationAction records who acted as whom, Uuid is the session the
+// admin was handed. Exp is the unix time the session ends, or ended.
+type ImpersonationAction struct {
+	Uuid    string
+	AdminID int
+	UserID  int
+	Exp     int64
+}
+
+// RevokedTokenKey keys the jti denylist. A revoked session's jti stays
+// listed until its token would have expired anyway. The jti is hash tagged,
+// so the entry shares its cluster slot with the session it revokes.
+func RevokedTokenKey(jti string) string {
+	return "revoked-jti:{" + jti + "}"
+}
+
+// Session is an entry of the per-user session index
+type Session struct {
+	Uuid   string `json:"uuid"`
+	Device string `json:"device"`
+	// IP is where the session was last seen from
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	// Current is set when listing, for the session asking
+	Current bool `json:"current"`
+}
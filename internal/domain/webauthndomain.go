@@ -0,0 +1,97 @@
+package domain
+
+import "time"
+
+type WebauthnCredential struct {
+	ID           int        `json:"id"`
+	UserID       int        `json:"-"`
+	Name         string     `json:"name"`
+	CredentialID []byte     `json:"credential_id"`
+	PublicKey    []byte     `json:"-"`
+	AAGUID       []byte     `json:"-"`
+	SignCount    uint32     `json:"-"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+}
+
+// Options handed to navigator.credentials.create() and .get(), binary values
+// are base64url encoded
+type (
+	WebauthnRelyingParty struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+
+	WebauthnUserEntity struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+	}
+
+	WebauthnCredentialParameter struct {
+		Type string `json:"type"`
+		Alg  int64  `json:"alg"`
+	}
+
+	WebauthnCredentialDescriptor struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+	}
+
+	WebauthnAuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	}
+
+	WebauthnCreationOptions struct {
+		Challenge              string                         `json:"challenge"`
+		RP                     WebauthnRelyingParty           `json:"rp"`
+		User                   WebauthnUserEntity             `json:"user"`
+		PubKeyCredParams       []WebauthnCredentialParameter  `json:"pubKeyCredParams"`
+		Timeout                int64                          `json:"timeout"`
+		Attestation            string                         `json:"attestation"`
+		ExcludeCredentials     []WebauthnCredentialDescriptor `json:"excludeCredentials"`
+		AuthenticatorSelection WebauthnAuthenticatorSelection `json:"authenticatorSelection"`
+	}
+
+	WebauthnRequestOptions struct {
+		Challenge        string                         `json:"challenge"`
+		RPID             string                         `json:"rpId"`
+		Timeout          int64                          `json:"timeout"`
+		UserVerification string                         `json:"userVerification"`
+		AllowCredentials []WebauthnCredentialDescriptor `json:"allowCredentials"`
+	}
+)
+
+// Credentials returned by navigator.credentials.create() and .get(), binary
+// values are base64url encoded
+type (
+	WebauthnAttestationResponse struct {
+		ClientDataJSON    string `json:"clientDataJSON" validate:"required"`
+		AttestationObject string `json:"attestationObject" validate:"required"`
+	}
+
+	WebauthnAssertionResponse struct {
+		ClientDataJSON    string `json:"clientDataJSON" validate:"required"`
+		AuthenticatorData string `json:"authenticatorData" validate:"required"`
+		Signature         string `json:"signature" validate:"required"`
+		UserHandle        string `json:"userHandle"`
+	}
+
+	WebauthnRegistrationValidation struct {
+		Name     string                      `json:"name"`
+		RawID    string                      `json:"rawId" validate:"required"`
+		Type     string                      `json:"type" validate:"required,eq=public-key"`
+		Response WebauthnAttestationResponse `json:"response"`
+	}
+
+	WebauthnLoginBeginValidation struct {
+		Username string `json:"username"`
+	}
+
+	WebauthnLoginValidation struct {
+		RawID    string                    `json:"rawId" validate:"required"`
+		Type     string                    `json:"type" validate:"required,eq=public-key"`
+		Response WebauthnAssertionResponse `json:"response"`
+	}
+)
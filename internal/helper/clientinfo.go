@@ -0,0 +1,23 @@
+package helper
+
+import "context"
+
+// ClientInfo is where a request came from, sessions it opens remember it
+type ClientInfo struct {
+	IP     string
+	Device string
+}
+
+type clientInfoKey struct{}
+
+// WithClientInfo returns a copy of ctx carrying info
+func WithClientInfo(ctx context.Context, info ClientInfo) context.Context {
+	return context.WithValue(ctx, clientInfoKey{}, info)
+}
+
+// ClientInfoFrom returns the client of the request behind ctx, it is empty
+// outside of one
+func ClientInfoFrom(ctx context.Context) ClientInfo {
+	info, _ := ctx.Value(clientInfoKey{}).(ClientInfo)
+	return info
+}
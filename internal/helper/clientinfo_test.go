@@ -0,0 +1,22 @@
+package helper
+
+import (
+	"context"
+	"testing"
+)
+
+func TestClientInfoRoundTrip(t *testing.T) {
+	info := ClientInfo{IP: "203.0.113.7", Device: "Mozilla/5.0"}
+
+	ctx := WithClientInfo(context.Background(), info)
+
+	if got := ClientInfoFrom(ctx); got != info {
+		t.Errorf("ClientInfoFrom() = %+v, want %+v", got, info)
+	}
+}
+
+func TestClientInfoOutsideOfRequest(t *testing.T) {
+	if got := ClientInfoFrom(context.Background()); got != (ClientInfo{}) {
+		t.Errorf("expected no client info, got %+v", got)
+	}
+}
@@ -0,0 +1,69 @@
+package helper
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+)
+
+// EncryptionKeyLength is the key size Encrypt expects, AES-256
+const EncryptionKeyLength = 32
+
+// ParseEncryptionKey decodes a base64 encoded key for Encrypt
+func ParseEncryptionKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+
+	if err != nil || len(key) != EncryptionKeyLength {
+		return nil, errors.New("encryption key must be 32 base64 encoded bytes")
+	}
+
+	return key, nil
+}
+
+// Encrypt seals plaintext with AES-256-GCM. The random nonce is prepended to
+// the result. additionalData is authenticated but not stored, the same value
+// must be passed to Decrypt, e.g. the ID of the row holding the ciphertext so
+// that it cannot be moved to another row.
+func Encrypt(key []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := generateRandomBytes(uint32(aead.NonceSize()))
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// Decrypt opens what Encrypt sealed
+func Decrypt(key []byte, ciphertext []byte, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+
+	return aead.Open(nil, nonce, sealed, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != EncryptionKeyLength {
+		return nil, errors.New("encryption key must be 32 bytes")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
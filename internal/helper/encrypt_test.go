@@ -0,0 +1,50 @@
+package helper
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncryptRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, EncryptionKeyLength)
+
+	ciphertext, err := Encrypt(key, []byte("private key"), []byte("kid-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(ciphertext, []byte("private key")) {
+		t.Fatal("plaintext must not be readable")
+	}
+
+	plaintext, err := Decrypt(key, ciphertext, []byte("kid-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(plaintext) != "private key" {
+		t.Errorf("got %q", plaintext)
+	}
+}
+
+func TestDecryptRejectsTampering(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, EncryptionKeyLength)
+
+	ciphertext, err := Encrypt(key, []byte("private key"), []byte("kid-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Decrypt(key, ciphertext, []byte("kid-2")); err == nil {
+		t.Error("expected other additional data to be rejected")
+	}
+
+	if _, err := Decrypt(bytes.Repeat([]byte{2}, EncryptionKeyLength), ciphertext, []byte("kid-1")); err == nil {
+		t.Error("expected another key to be rejected")
+	}
+
+	ciphertext[len(ciphertext)-1] ^= 1
+	if _, err := Decrypt(key, ciphertext, []byte("kid-1")); err == nil {
+		t.Error("expected a modified ciphertext to be rejected")
+	}
+}
@@ -0,0 +1,27 @@
+package helper
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"regexp"
+)
+
+// pkceVerifierRX is the code_verifier syntax of RFC 7636 section 4.1
+var pkceVerifierRX = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+// PKCEChallenge derives the S256 code_challenge of a code_verifier.
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// VerifyPKCE checks a code_verifier against the S256 code_challenge it was
+// sent with. The plain method is deliberately not supported.
+func VerifyPKCE(verifier string, challenge string) bool {
+	if !pkceVerifierRX.MatchString(verifier) {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(PKCEChallenge(verifier)), []byte(challenge)) == 1
+}
@@ -0,0 +1,28 @@
+package helper
+
+import (
+	"testing"
+)
+
+// Example from RFC 7636 Appendix B
+func TestVerifyPKCE(t *testing.T) {
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if PKCEChallenge(verifier) != challenge {
+		t.Fatalf("expected challenge %s got %s", challenge, PKCEChallenge(verifier))
+	}
+
+	if !VerifyPKCE(verifier, challenge) {
+		t.Error("expected verifier to match")
+	}
+
+	if VerifyPKCE("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXl", challenge) {
+		t.Error("expected other verifier to not match")
+	}
+
+	// Too short to be a valid verifier, even with a matching challenge
+	if VerifyPKCE("short", PKCEChallenge("short")) {
+		t.Error("expected malformed verifier to be rejected")
+	}
+}
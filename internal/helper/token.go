@@ -0,0 +1,84 @@
+package helper
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// DefaultTokenLength is the number of random bytes used for opaque tokens
+// such as refresh tokens. 32 bytes gives 256 bits of entropy.
+const DefaultTokenLength = 32
+
+// RandomToken returns an opaque, URL-safe token built from n random bytes.
+func RandomToken(n uint32) (token string, err error) {
+	b, err := generateRandomBytes(n)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hex encoded SHA-256 digest of an opaque token. Opaque
+// tokens carry enough entropy on their own, so a fast hash is enough to keep
+// them out of storage in plain text.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// recoveryCodeEncoding leaves out padding so codes are easy to type
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// RecoveryCode returns a human friendly one-time code such as "k3b7q-xz2mf".
+func RecoveryCode() (code string, err error) {
+	b, err := generateRandomBytes(7)
+	if err != nil {
+		return "", err
+	}
+
+	raw := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))[:10]
+
+	return raw[:5] + "-" + raw[5:], nil
+}
+
+// userCodeAlphabet has no vowels, so codes never spell words, and no digits
+// that could be mistaken for letters. See RFC 8628 section 6.1.
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+// UserCode returns a device flow user code such as "WDJB-MJHT".
+func UserCode() (code string, err error) {
+	raw := make([]byte, 0, 8)
+	for len(raw) < 8 {
+		b, err := generateRandomBytes(16)
+		if err != nil {
+			return "", err
+		}
+
+		// Bytes past the last multiple of 20 are skipped, so that every
+		// character is equally likely
+		for _, c := range b {
+			if int(c) < 256-256%len(userCodeAlphabet) && len(raw) < 8 {
+				raw = append(raw, userCodeAlphabet[int(c)%len(userCodeAlphabet)])
+			}
+		}
+	}
+
+	return string(raw[:4]) + "-" + string(raw[4:]), nil
+}
+
+// NormalizeUserCode undoes what users do when typing a user code: lower case,
+// missing or extra dashes and spaces.
+func NormalizeUserCode(code string) string {
+	code = strings.ToUpper(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+
+	if len(code) != 8 {
+		return code
+	}
+
+	return code[:4] + "-" + code[4:]
+}
@@ -0,0 +1,89 @@
+package helper
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestRandomToken(t *testing.T) {
+	tokenRX, err := regexp.Compile(`^[A-Za-z0-9_-]{43}$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token1, err := RandomToken(DefaultTokenLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !tokenRX.MatchString(token1) {
+		t.Errorf("token %q not in correct format", token1)
+	}
+
+	token2, err := RandomToken(DefaultTokenLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token1 == token2 {
+		t.Error("tokens must be unique")
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	hash := HashToken("token")
+	if hash != "3c469e9d6c5875d37a43f353d4f88e61fcf812c66eee3457465a40b0da4153e0" {
+		t.Errorf("unexpected hash %q", hash)
+	}
+
+	if HashToken("token") != hash {
+		t.Error("hash must be deterministic")
+	}
+}
+
+func TestRecoveryCode(t *testing.T) {
+	codeRX, err := regexp.Compile(`^[a-z2-7]{5}-[a-z2-7]{5}$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := RecoveryCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !codeRX.MatchString(code) {
+		t.Errorf("code %q not in correct format", code)
+	}
+}
+
+func TestUserCode(t *testing.T) {
+	codeRX, err := regexp.Compile(`^[BCDFGHJKLMNPQRSTVWXZ]{4}-[BCDFGHJKLMNPQRSTVWXZ]{4}$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := UserCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !codeRX.MatchString(code) {
+		t.Errorf("code %q not in correct format", code)
+	}
+}
+
+func TestNormalizeUserCode(t *testing.T) {
+	cases := map[string]string{
+		"WDJB-MJHT":   "WDJB-MJHT",
+		"wdjbmjht":    "WDJB-MJHT",
+		"wdjb - mjht": "WDJB-MJHT",
+		"WDJB":        "WDJB",
+	}
+
+	for input, expected := range cases {
+		if normalized := NormalizeUserCode(input); normalized != expected {
+			t.Errorf("expected %q to normalize to %q, got %q", input, expected, normalized)
+		}
+	}
+}
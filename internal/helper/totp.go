@@ -0,0 +1,112 @@
+package helper
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters. These are the defaults of RFC 6238 and the only ones most
+// authenticator apps support, so they are not configurable.
+const (
+	TOTPPeriod = 30
+	TOTPDigits = 6
+
+	// totpSecretLength is the secret size in bytes, 160 bits as RFC 4226
+	// recommends for HMAC-SHA1.
+	totpSecretLength = 20
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a new random, base32 encoded TOTP secret.
+func GenerateTOTPSecret() (secret string, err error) {
+	b, err := generateRandomBytes(totpSecretLength)
+	if err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// TOTPURI returns the otpauth:// URI authenticator apps read from a QR code.
+// See https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+func TOTPURI(issuer, account, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(TOTPDigits))
+	query.Set("period", fmt.Sprint(TOTPPeriod))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}
+
+	return u.String()
+}
+
+// TOTPCode returns the code of the secret for the time step t falls in.
+func TOTPCode(secret string, t time.Time) (code string, err error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	return hotp(key, uint64(t.Unix()/TOTPPeriod), TOTPDigits), nil
+}
+
+// ValidateTOTP checks a code against the time step of t and skew steps on
+// either side of it, to allow for clock drift. On success it also returns the
+// matching time step, so callers can refuse to accept it a second time.
+func ValidateTOTP(secret string, code string, t time.Time, skew int) (match bool, step int64, err error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return false, 0, err
+	}
+
+	current := t.Unix() / TOTPPeriod
+	for i := -skew; i <= skew; i++ {
+		step = current + int64(i)
+		if step < 0 {
+			continue
+		}
+
+		expected := hotp(key, uint64(step), TOTPDigits)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return true, step, nil
+		}
+	}
+
+	return false, 0, nil
+}
+
+// hotp implements the HOTP algorithm of RFC 4226.
+func hotp(key []byte, counter uint64, digits int) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
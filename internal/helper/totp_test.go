@@ -0,0 +1,89 @@
+package helper
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test vectors from RFC 6238 Appendix B, SHA1 mode
+func TestHOTPVectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+
+	vectors := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+
+	for unix, expected := range vectors {
+		code := hotp(key, uint64(unix/TOTPPeriod), 8)
+		if code != expected {
+			t.Errorf("at %d expected %s got %s", unix, expected, code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1111111111, 0)
+
+	code, err := TOTPCode(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	match, step, err := ValidateTOTP(secret, code, now, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !match {
+		t.Fatal("expected code to match")
+	}
+	if step != now.Unix()/TOTPPeriod {
+		t.Fatalf("expected step %d got %d", now.Unix()/TOTPPeriod, step)
+	}
+
+	// One step of clock drift is tolerated, two are not
+	match, _, _ = ValidateTOTP(secret, code, now.Add(TOTPPeriod*time.Second), 1)
+	if !match {
+		t.Error("expected code to match within skew")
+	}
+
+	match, _, _ = ValidateTOTP(secret, code, now.Add(2*TOTPPeriod*time.Second), 1)
+	if match {
+		t.Error("expected code outside skew to be rejected")
+	}
+}
+
+func TestTOTPSecretKnownValue(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	code, err := TOTPCode(strings.ToLower(secret), time.Unix(59, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code != "287082" {
+		t.Errorf("expected 287082 got %s", code)
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("Auth", "john", "JBSWY3DPEHPK3PXP")
+
+	if !strings.HasPrefix(uri, "otpauth://totp/Auth:john?") {
+		t.Errorf("unexpected uri %q", uri)
+	}
+	if !strings.Contains(uri, "secret=JBSWY3DPEHPK3PXP") {
+		t.Errorf("uri %q does not carry the secret", uri)
+	}
+}
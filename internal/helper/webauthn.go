@@ -0,0 +1,245 @@
+package helper
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// This file implements the parts of WebAuthn Level 2 the relying party has to
+// verify itself: client data, authenticator data, attestation objects and
+// assertion signatures. See https://www.w3.org/TR/webauthn-2/
+//
+// Attestation statements are not verified, we ask authenticators for "none"
+// attestation and only keep the credential public key.
+
+// Authenticator data flags
+const (
+	AuthenticatorFlagUserPresent  byte = 0x01
+	AuthenticatorFlagUserVerified byte = 0x04
+	AuthenticatorFlagAttestedData byte = 0x40
+	AuthenticatorFlagExtensions   byte = 0x80
+)
+
+// COSE algorithm identifiers we accept for credentials
+const (
+	COSEAlgES256 int64 = -7
+	COSEAlgEdDSA int64 = -8
+	COSEAlgRS256 int64 = -257
+)
+
+var (
+	// ErrInvalidClientData is returned when clientDataJSON cannot be parsed
+	ErrInvalidClientData = errors.New("webauthn: invalid client data")
+
+	// ErrInvalidAuthenticatorData is returned when authenticator data is
+	// truncated or malformed
+	ErrInvalidAuthenticatorData = errors.New("webauthn: invalid authenticator data")
+
+	// ErrUnsupportedKey is returned for COSE keys of an unsupported type or
+	// algorithm
+	ErrUnsupportedKey = errors.New("webauthn: unsupported credential public key")
+
+	// ErrInvalidSignature is returned when an assertion signature does not
+	// verify
+	ErrInvalidSignature = errors.New("webauthn: invalid signature")
+)
+
+// ClientData is the decoded clientDataJSON of a ceremony
+type ClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// AuthenticatorData is the decoded authenticator data of a ceremony. The
+// attested credential fields are only set during registration.
+type AuthenticatorData struct {
+	RPIDHash            []byte
+	Flags               byte
+	SignCount           uint32
+	AAGUID              []byte
+	CredentialID        []byte
+	CredentialPublicKey []byte
+}
+
+type attestationObject struct {
+	Fmt      string          `cbor:"fmt"`
+	AttStmt  cbor.RawMessage `cbor:"attStmt"`
+	AuthData []byte          `cbor:"authData"`
+}
+
+// DecodeBase64URL decodes base64url with or without padding, browsers and
+// client libraries disagree on which one to send.
+func DecodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// ParseClientData decodes clientDataJSON
+func ParseClientData(raw []byte) (*ClientData, error) {
+	clientData := &ClientData{}
+	if err := json.Unmarshal(raw, clientData); err != nil {
+		return nil, ErrInvalidClientData
+	}
+
+	return clientData, nil
+}
+
+// ParseAuthenticatorData decodes the binary authenticator data structure
+func ParseAuthenticatorData(raw []byte) (*AuthenticatorData, error) {
+	if len(raw) < 37 {
+		return nil, ErrInvalidAuthenticatorData
+	}
+
+	authData := &AuthenticatorData{
+		RPIDHash:  raw[:32],
+		Flags:     raw[32],
+		SignCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+
+	if authData.Flags&AuthenticatorFlagAttestedData == 0 {
+		return authData, nil
+	}
+
+	rest := raw[37:]
+	if len(rest) < 18 {
+		return nil, ErrInvalidAuthenticatorData
+	}
+
+	authData.AAGUID = rest[:16]
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+
+	if len(rest) < idLength {
+		return nil, ErrInvalidAuthenticatorData
+	}
+
+	authData.CredentialID = rest[:idLength]
+	rest = rest[idLength:]
+
+	// The public key is a single CBOR item, extensions may follow it
+	var publicKey cbor.RawMessage
+	if err := cbor.NewDecoder(bytes.NewReader(rest)).Decode(&publicKey); err != nil {
+		return nil, ErrInvalidAuthenticatorData
+	}
+	authData.CredentialPublicKey = publicKey
+
+	return authData, nil
+}
+
+// ParseAttestationObject decodes an attestation object and returns its
+// authenticator data
+func ParseAttestationObject(raw []byte) (*AuthenticatorData, error) {
+	attestation := &attestationObject{}
+	if err := cbor.Unmarshal(raw, attestation); err != nil {
+		return nil, ErrInvalidAuthenticatorData
+	}
+
+	return ParseAuthenticatorData(attestation.AuthData)
+}
+
+// ParseCOSEKey turns a COSE_Key into a Go public key and reports its algorithm
+func ParseCOSEKey(raw []byte) (publicKey crypto.PublicKey, alg int64, err error) {
+	key := map[int64]interface{}{}
+	if err := cbor.Unmarshal(raw, &key); err != nil {
+		return nil, 0, ErrUnsupportedKey
+	}
+
+	alg, _ = coseInt(key[3])
+	switch alg {
+	case COSEAlgES256:
+		crv, _ := coseInt(key[-1])
+		x, _ := key[-2].([]byte)
+		y, _ := key[-3].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, 0, ErrUnsupportedKey
+		}
+
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, 0, ErrUnsupportedKey
+		}
+
+		return pub, alg, nil
+	case COSEAlgRS256:
+		n, _ := key[-1].([]byte)
+		e, _ := key[-2].([]byte)
+		if len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, ErrUnsupportedKey
+		}
+
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, alg, nil
+	case COSEAlgEdDSA:
+		crv, _ := coseInt(key[-1])
+		x, _ := key[-2].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, 0, ErrUnsupportedKey
+		}
+
+		return ed25519.PublicKey(x), alg, nil
+	}
+
+	return nil, 0, ErrUnsupportedKey
+}
+
+// VerifyAssertionSignature checks an assertion signature, made over the
+// authenticator data followed by the SHA-256 of clientDataJSON.
+func VerifyAssertionSignature(coseKey []byte, authData []byte, clientDataJSON []byte, signature []byte) error {
+	publicKey, _, err := ParseCOSEKey(coseKey)
+	if err != nil {
+		return err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authData...), clientDataHash[:]...)
+	digest := sha256.Sum256(signed)
+
+	switch pub := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		if ecdsa.VerifyASN1(pub, digest[:], signature) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if ed25519.Verify(pub, signed, signature) {
+			return nil
+		}
+	}
+
+	return ErrInvalidSignature
+}
+
+// coseInt reads a CBOR integer, which decodes as either int64 or uint64
+func coseInt(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case uint64:
+		return int64(n), true
+	}
+
+	return 0, false
+}
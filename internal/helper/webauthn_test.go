@@ -0,0 +1,132 @@
+package helper
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+func testES256Credential(t *testing.T) (*ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	coseKey, err := cbor.Marshal(map[int]interface{}{
+		1:  2,
+		3:  COSEAlgES256,
+		-1: 1,
+		-2: key.X.FillBytes(make([]byte, 32)),
+		-3: key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key, coseKey
+}
+
+func testAuthData(rpID string, flags byte, signCount uint32, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	authData := append([]byte{}, rpIDHash[:]...)
+	authData = append(authData, flags)
+	authData = binary.BigEndian.AppendUint32(authData, signCount)
+	return append(authData, attested...)
+}
+
+func TestParseAttestationObject(t *testing.T) {
+	_, coseKey := testES256Credential(t)
+	credentialID := []byte("credential-id")
+
+	attested := make([]byte, 16)
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(credentialID)))
+	attested = append(attested, credentialID...)
+	attested = append(attested, coseKey...)
+
+	authData := testAuthData("localhost", AuthenticatorFlagUserPresent|AuthenticatorFlagAttestedData, 0, attested)
+
+	raw, err := cbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": authData,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseAttestationObject(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(parsed.CredentialID, credentialID) {
+		t.Errorf("expected credential id %q got %q", credentialID, parsed.CredentialID)
+	}
+	if !bytes.Equal(parsed.CredentialPublicKey, coseKey) {
+		t.Error("credential public key does not round trip")
+	}
+
+	if _, alg, err := ParseCOSEKey(parsed.CredentialPublicKey); err != nil || alg != COSEAlgES256 {
+		t.Fatalf("expected ES256 key, got alg %d err %v", alg, err)
+	}
+}
+
+func TestParseAuthenticatorDataTruncated(t *testing.T) {
+	authData := testAuthData("localhost", AuthenticatorFlagUserPresent|AuthenticatorFlagAttestedData, 0, []byte{1, 2, 3})
+
+	if _, err := ParseAuthenticatorData(authData); err != ErrInvalidAuthenticatorData {
+		t.Fatalf("expected error %s", ErrInvalidAuthenticatorData)
+	}
+}
+
+func TestVerifyAssertionSignatureES256(t *testing.T) {
+	key, coseKey := testES256Credential(t)
+
+	authData := testAuthData("localhost", AuthenticatorFlagUserPresent, 1, nil)
+	clientDataJSON := []byte(`{"type":"webauthn.get","challenge":"abc","origin":"http://localhost"}`)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := VerifyAssertionSignature(coseKey, authData, clientDataJSON, signature); err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := []byte(`{"type":"webauthn.get","challenge":"abd","origin":"http://localhost"}`)
+	if err := VerifyAssertionSignature(coseKey, authData, tampered, signature); err != ErrInvalidSignature {
+		t.Fatalf("expected error %s", ErrInvalidSignature)
+	}
+}
+
+func TestVerifyAssertionSignatureEdDSA(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	coseKey, err := cbor.Marshal(map[int]interface{}{1: 1, 3: COSEAlgEdDSA, -1: 6, -2: []byte(pub)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authData := testAuthData("localhost", AuthenticatorFlagUserPresent, 1, nil)
+	clientDataJSON := []byte(`{"type":"webauthn.get","challenge":"abc","origin":"http://localhost"}`)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+
+	signature := ed25519.Sign(priv, append(append([]byte{}, authData...), clientDataHash[:]...))
+
+	if err := VerifyAssertionSignature(coseKey, authData, clientDataJSON, signature); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"auth/internal/domain"
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+// ApiKeyRepository represent the API key's repository contract
+type ApiKeyRepository interface {
+	GetByUserID(ctx context.Context, userID int) ([]*domain.ApiKey, error)
+	GetOneByHash(ctx context.Context, keyHash string) (*domain.ApiKey, error)
+	Insert(ctx context.Context, input *domain.ApiKey) (*domain.ApiKey, error)
+	Delete(ctx context.Context, id int, userID int) (bool, error)
+	MarkUsed(ctx context.Context, id int, ip string) error
+}
+
+type ApiKeyRepositoryImpl struct {
+	DB *sql.DB
+}
+
+func NewApiKeyRepository(db *sql.DB) ApiKeyRepository {
+	return &ApiKeyRepositoryImpl{
+		DB: db,
+	}
+}
+
+func (m *ApiKeyRepositoryImpl) GetByUserID(ctx context.Context, userID int) (res []*domain.ApiKey, err error) {
+	rows, err := m.DB.QueryContext(ctx, `SELECT id, user_id, name, prefix, key_hash, scopes, expires_at, last_used_at, last_used_ip, created_at
+		FROM api_keys WHERE user_id=$1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	apiKeys := []*domain.ApiKey{}
+
+	for rows.Next() {
+		apiKey := &domain.ApiKey{}
+		var lastUsedIP sql.NullString
+		if err := rows.Scan(&apiKey.ID, &apiKey.UserID, &apiKey.Name, &apiKey.Prefix, &apiKey.KeyHash, pq.Array(&apiKey.Scopes),
+			&apiKey.ExpiresAt, &apiKey.LastUsedAt, &lastUsedIP, &apiKey.CreatedAt); err != nil {
+			return apiKeys, err
+		}
+		apiKey.LastUsedIP = lastUsedIP.String
+		apiKeys = append(apiKeys, apiKey)
+	}
+	if err = rows.Err(); err != nil {
+		return apiKeys, err
+	}
+	return apiKeys, nil
+}
+
+func (m *ApiKeyRepositoryImpl) GetOneByHash(ctx context.Context, keyHash string) (res *domain.ApiKey, err error) {
+	stmt, err := m.DB.PrepareContext(ctx, `SELECT id, user_id, name, prefix, key_hash, scopes, expires_at, last_used_at, last_used_ip, created_at
+		FROM api_keys WHERE key_hash=$1`)
+	if err != nil {
+		return nil, err
+	}
+
+	row := stmt.QueryRowContext(ctx, keyHash)
+	var apiKey domain.ApiKey
+	var lastUsedIP sql.NullString
+
+	err = row.Scan(
+		&apiKey.ID,
+		&apiKey.UserID,
+		&apiKey.Name,
+		&apiKey.Prefix,
+		&apiKey.KeyHash,
+		pq.Array(&apiKey.Scopes),
+		&apiKey.ExpiresAt,
+		&apiKey.LastUsedAt,
+		&lastUsedIP,
+		&apiKey.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	apiKey.LastUsedIP = lastUsedIP.String
+
+	return &apiKey, nil
+}
+
+func (m *ApiKeyRepositoryImpl) Insert(ctx context.Context, input *domain.ApiKey) (apiKey *domain.ApiKey, err error) {
+	stmt := `insert into api_keys (user_id, name, prefix, key_hash, scopes, expires_at)
+		values ($1, $2, $3, $4, coalesce($5, '{}'), $6) returning id`
+
+	var newID int
+
+	err = m.DB.QueryRowContext(ctx, stmt,
+		input.UserID,
+		input.Name,
+		input.Prefix,
+		input.KeyHash,
+		pq.Array(input.Scopes),
+		input.ExpiresAt,
+	).Scan(&newID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return m.GetOneByHash(ctx, input.KeyHash)
+}
+
+// Delete removes a key of a user and reports whether there was one
+func (m *ApiKeyRepositoryImpl) Delete(ctx context.Context, id int, userID int) (bool, error) {
+	res, err := m.DB.ExecContext(ctx, `delete from api_keys where id = $1 and user_id = $2`, id, userID)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+
+	return affected > 0, err
+}
+
+// MarkUsed records when and from where a key was last used. Repeated use from
+// the same address is only written once a minute.
+func (m *ApiKeyRepositoryImpl) MarkUsed(ctx context.Context, id int, ip string) error {
+	stmt := `update api_keys set last_used_at = now(), last_used_ip = $2
+		where id = $1 and (last_used_at is null or last_used_at < now() - interval '1 minute' or last_used_ip is distinct from $2)`
+
+	_, err := m.DB.ExecContext(ctx, stmt, id, ip)
+
+	return err
+}
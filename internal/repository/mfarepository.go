@@ -0,0 +1,196 @@
+package repository
+
+import (
+	"auth/internal/domain"
+	"auth/internal/helper"
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// MfaRepository represent the second factor's repository contract
+type MfaRepository interface {
+	GetTOTP(ctx context.Context, userID int) (*domain.TOTP, error)
+	SaveTOTP(ctx context.Context, userID int, secret string) error
+	ConfirmTOTP(ctx context.Context, userID int) error
+	DeleteTOTP(ctx context.Context, userID int) error
+	MarkTOTPUsed(ctx context.Context, userID int, step int64) (bool, error)
+	ReplaceRecoveryCodes(ctx context.Context, userID int, codes []string) error
+	UseRecoveryCode(ctx context.Context, userID int, code string) (bool, error)
+	RememberChallenge(ctx context.Context, token string, userID int, expire time.Duration) error
+	GetChallenge(ctx context.Context, token string) (int, error)
+	DeleteChallenge(ctx context.Context, token string)
+	CountAttempt(ctx context.Context, key string, expire time.Duration) (int64, error)
+	ClearAttempts(ctx context.Context, key string)
+}
+
+type MfaRepositoryImpl struct {
+	DB    *sql.DB
+	Redis *redis.Client
+}
+
+func NewMfaRepository(db *sql.DB, Redis *redis.Client) MfaRepository {
+	return &MfaRepositoryImpl{
+		DB:    db,
+		Redis: Redis,
+	}
+}
+
+func (m *MfaRepositoryImpl) GetTOTP(ctx context.Context, userID int) (res *domain.TOTP, err error) {
+	stmt, err := m.DB.PrepareContext(ctx, "SELECT user_id, secret, confirmed_at FROM user_totp WHERE user_id=$1")
+	if err != nil {
+		return nil, err
+	}
+
+	row := stmt.QueryRowContext(ctx, userID)
+	var totp domain.TOTP
+
+	err = row.Scan(
+		&totp.UserID,
+		&totp.Secret,
+		&totp.ConfirmedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &totp, nil
+}
+
+// SaveTOTP stores a new, unconfirmed secret. Enrolling again replaces a
+// secret that was never confirmed, but never one that is in use.
+func (m *MfaRepositoryImpl) SaveTOTP(ctx context.Context, userID int, secret string) (err error) {
+	stmt := `insert into user_totp (user_id, secret) values ($1, $2)
+		on conflict (user_id) do update set secret = excluded.secret, created_at = now()
+		where user_totp.confirmed_at is null`
+
+	res, err := m.DB.ExecContext(ctx, stmt, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (m *MfaRepositoryImpl) ConfirmTOTP(ctx context.Context, userID int) (err error) {
+	stmt := `update user_totp set confirmed_at = now() where user_id = $1`
+
+	_, err = m.DB.ExecContext(ctx, stmt, userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *MfaRepositoryImpl) DeleteTOTP(ctx context.Context, userID int) (err error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, `delete from user_recovery_codes where user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, `delete from user_totp where user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MarkTOTPUsed remembers a time step a user logged in with, and reports false
+// when it was already used, so an observed code cannot be replayed.
+func (m *MfaRepositoryImpl) MarkTOTPUsed(ctx context.Context, userID int, step int64) (bool, error) {
+	key := "totp-used:" + strconv.Itoa(userID) + ":" + strconv.FormatInt(step, 10)
+	// Codes are accepted one step either side, so three periods is enough
+	return m.Redis.SetNX(ctx, key, 1, 3*helper.TOTPPeriod*time.Second).Result()
+}
+
+// ReplaceRecoveryCodes swaps all recovery codes of a user for the given
+// hashes.
+func (m *MfaRepositoryImpl) ReplaceRecoveryCodes(ctx context.Context, userID int, codes []string) (err error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, `delete from user_recovery_codes where user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	for _, code := range codes {
+		if _, err = tx.ExecContext(ctx, `insert into user_recovery_codes (user_id, code_hash) values ($1, $2)`, userID, code); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UseRecoveryCode burns an unused recovery code, and reports whether there
+// was one to burn.
+func (m *MfaRepositoryImpl) UseRecoveryCode(ctx context.Context, userID int, code string) (bool, error) {
+	stmt := `update user_recovery_codes set used_at = now()
+		where id = (select id from user_recovery_codes where user_id = $1 and code_hash = $2 and used_at is null limit 1)`
+
+	res, err := m.DB.ExecContext(ctx, stmt, userID, code)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+func (m *MfaRepositoryImpl) RememberChallenge(ctx context.Context, token string, userID int, expire time.Duration) error {
+	return m.Redis.Set(ctx, "mfa-challenge:"+helper.HashToken(token), userID, expire).Err()
+}
+
+func (m *MfaRepositoryImpl) GetChallenge(ctx context.Context, token string) (int, error) {
+	return m.Redis.Get(ctx, "mfa-challenge:"+helper.HashToken(token)).Int()
+}
+
+func (m *MfaRepositoryImpl) DeleteChallenge(ctx context.Context, token string) {
+	m.Redis.Del(ctx, "mfa-challenge:"+helper.HashToken(token))
+}
+
+// countAttempt increments a counter and starts its expiry window with the
+// first increment, in one step so a counter can never be left without expiry
+var countAttempt = redis.NewScript(`
+local count = redis.call('INCR', KEYS[1])
+if count == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return count
+`)
+
+// CountAttempt increments an attempt counter and returns its new value. The
+// counter starts its expiry window on the first attempt.
+func (m *MfaRepositoryImpl) CountAttempt(ctx context.Context, key string, expire time.Duration) (int64, error) {
+	return countAttempt.Run(ctx, m.Redis, []string{"mfa-attempts:" + key}, expire.Milliseconds()).Int64()
+}
+
+func (m *MfaRepositoryImpl) ClearAttempts(ctx context.Context, key string) {
+	m.Redis.Del(ctx, "mfa-attempts:"+key)
+}
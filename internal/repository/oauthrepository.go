@@ -0,0 +1,253 @@
+package repository
+
+import (
+	"auth/internal/domain"
+	"auth/internal/helper"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/lib/pq"
+)
+
+// OAuthRepository represent the OAuth client registry's repository contract
+type OAuthRepository interface {
+	GetClient(ctx context.Context, clientID string) (*domain.OAuthClient, error)
+	InsertClient(ctx context.Context, input *domain.OAuthClient) (*domain.OAuthClient, error)
+	GetConsent(ctx context.Context, userID int, clientID string) ([]string, error)
+	SaveConsent(ctx context.Context, userID int, clientID string, scopes []string) error
+	RememberAuthorizationCode(ctx context.Context, code string, authorization *domain.AuthorizationCode, expire time.Duration) error
+	ConsumeAuthorizationCode(ctx context.Context, code string) (*domain.AuthorizationCode, error)
+	RememberDeviceAuthorization(ctx context.Context, device *domain.DeviceAuthorization, expire time.Duration) error
+	GetDeviceAuthorization(ctx context.Context, deviceCode string) (*domain.DeviceAuthorization, error)
+	GetDeviceAuthorizationByUserCode(ctx context.Context, userCode string) (*domain.DeviceAuthorization, error)
+	UpdateDeviceAuthorization(ctx context.Context, device *domain.DeviceAuthorization) error
+	ConsumeDeviceAuthorization(ctx context.Context, deviceCode string) (*domain.DeviceAuthorization, error)
+	ThrottleDevicePoll(ctx context.Context, deviceCode string, interval time.Duration) (allowed bool, err error)
+	SlowDownDevicePoll(ctx context.Context, deviceCode string, increase int) (interval int, err error)
+}
+
+type OAuthRepositoryImpl struct {
+	DB    *sql.DB
+	Redis *redis.Client
+}
+
+func NewOAuthRepository(db *sql.DB, Redis *redis.Client) OAuthRepository {
+	return &OAuthRepositoryImpl{
+		DB:    db,
+		Redis: Redis,
+	}
+}
+
+func (m *OAuthRepositoryImpl) GetClient(ctx context.Context, clientID string) (res *domain.OAuthClient, err error) {
+	stmt, err := m.DB.PrepareContext(ctx, `SELECT id, client_id, name, secret_hash, redirect_uris, allowed_scopes, grant_types, created_at
+		FROM oauth_clients WHERE client_id=$1`)
+	if err != nil {
+		return nil, err
+	}
+
+	row := stmt.QueryRowContext(ctx, clientID)
+	var client domain.OAuthClient
+	var name, secretHash sql.NullString
+
+	err = row.Scan(
+		&client.ID,
+		&client.ClientID,
+		&name,
+		&secretHash,
+		pq.Array(&client.RedirectURIs),
+		pq.Array(&client.AllowedScopes),
+		pq.Array(&client.GrantTypes),
+		&client.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	client.Name = name.String
+	client.SecretHash = secretHash.String
+
+	return &client, nil
+}
+
+func (m *OAuthRepositoryImpl) InsertClient(ctx context.Context, input *domain.OAuthClient) (client *domain.OAuthClient, err error) {
+	stmt := `insert into oauth_clients (client_id, name, secret_hash, redirect_uris, allowed_scopes, grant_types)
+		values ($1, $2, nullif($3, ''), $4, $5, $6) returning id`
+
+	var newID int
+
+	err = m.DB.QueryRowContext(ctx, stmt,
+		input.ClientID,
+		input.Name,
+		input.SecretHash,
+		pq.Array(input.RedirectURIs),
+		pq.Array(input.AllowedScopes),
+		pq.Array(input.GrantTypes),
+	).Scan(&newID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return m.GetClient(ctx, input.ClientID)
+}
+
+// GetConsent returns the scopes a user granted a client, none when the user
+// never did
+func (m *OAuthRepositoryImpl) GetConsent(ctx context.Context, userID int, clientID string) (scopes []string, err error) {
+	stmt, err := m.DB.PrepareContext(ctx, "SELECT scopes FROM oauth_consents WHERE user_id=$1 AND client_id=$2")
+	if err != nil {
+		return nil, err
+	}
+
+	err = stmt.QueryRowContext(ctx, userID, clientID).Scan(pq.Array(&scopes))
+
+	if err == sql.ErrNoRows {
+		return []string{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return scopes, nil
+}
+
+// SaveConsent adds scopes to what a user granted a client
+func (m *OAuthRepositoryImpl) SaveConsent(ctx context.Context, userID int, clientID string, scopes []string) (err error) {
+	stmt := `insert into oauth_consents (user_id, client_id, scopes) values ($1, $2, $3)
+		on conflict (user_id, client_id) do update
+		set scopes = array(select distinct unnest(oauth_consents.scopes || excluded.scopes)), created_at = now()`
+
+	_, err = m.DB.ExecContext(ctx, stmt, userID, clientID, pq.Array(scopes))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *OAuthRepositoryImpl) RememberAuthorizationCode(ctx context.Context, code string, authorization *domain.AuthorizationCode, expire time.Duration) error {
+	authorizationModel, _ := json.Marshal(authorization)
+	return m.Redis.Set(ctx, "oauth-code:"+helper.HashToken(code), authorizationModel, expire).Err()
+}
+
+// ConsumeAuthorizationCode reads and deletes a code in one step, so that a
+// code can only ever be exchanged once.
+func (m *OAuthRepositoryImpl) ConsumeAuthorizationCode(ctx context.Context, code string) (res *domain.AuthorizationCode, err error) {
+	authorizationModel, err := m.Redis.GetDel(ctx, "oauth-code:"+helper.HashToken(code)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	res = &domain.AuthorizationCode{}
+	err = json.Unmarshal([]byte(authorizationModel), res)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// RememberDeviceAuthorization stores a pending device flow under its device
+// code hash, with the user code pointing at it
+func (m *OAuthRepositoryImpl) RememberDeviceAuthorization(ctx context.Context, device *domain.DeviceAuthorization, expire time.Duration) error {
+	deviceModel, _ := json.Marshal(device)
+
+	pipe := m.Redis.TxPipeline()
+	pipe.Set(ctx, "device-code:"+device.ID, deviceModel, expire)
+	pipe.Set(ctx, "device-user-code:"+device.UserCode, device.ID, expire)
+	_, err := pipe.Exec(ctx)
+
+	return err
+}
+
+func (m *OAuthRepositoryImpl) GetDeviceAuthorization(ctx context.Context, deviceCode string) (res *domain.DeviceAuthorization, err error) {
+	return m.getDeviceAuthorization(ctx, helper.HashToken(deviceCode))
+}
+
+func (m *OAuthRepositoryImpl) GetDeviceAuthorizationByUserCode(ctx context.Context, userCode string) (res *domain.DeviceAuthorization, err error) {
+	id, err := m.Redis.Get(ctx, "device-user-code:"+userCode).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return m.getDeviceAuthorization(ctx, id)
+}
+
+// UpdateDeviceAuthorization records the user's decision. The user code is
+// spent, it cannot be approved or denied twice.
+func (m *OAuthRepositoryImpl) UpdateDeviceAuthorization(ctx context.Context, device *domain.DeviceAuthorization) error {
+	deviceModel, _ := json.Marshal(device)
+
+	pipe := m.Redis.TxPipeline()
+	pipe.SetArgs(ctx, "device-code:"+device.ID, deviceModel, redis.SetArgs{KeepTTL: true, Mode: "XX"})
+	pipe.Del(ctx, "device-user-code:"+device.UserCode)
+	_, err := pipe.Exec(ctx)
+
+	return err
+}
+
+// ConsumeDeviceAuthorization reads and deletes a device flow in one step, so
+// that its tokens can only be collected once
+func (m *OAuthRepositoryImpl) ConsumeDeviceAuthorization(ctx context.Context, deviceCode string) (res *domain.DeviceAuthorization, err error) {
+	deviceModel, err := m.Redis.GetDel(ctx, "device-code:"+helper.HashToken(deviceCode)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	res = &domain.DeviceAuthorization{}
+	err = json.Unmarshal([]byte(deviceModel), res)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// ThrottleDevicePoll reports whether a device may poll again, at most once
+// per interval
+func (m *OAuthRepositoryImpl) ThrottleDevicePoll(ctx context.Context, deviceCode string, interval time.Duration) (allowed bool, err error) {
+	return m.Redis.SetNX(ctx, "device-poll:"+helper.HashToken(deviceCode), 1, interval).Result()
+}
+
+// slowDownDevicePoll raises the interval of the device flow KEYS[1] by
+// ARGV[1] seconds and makes the device wait that long from now on, in one
+// step so a concurrent decision of the user is not overwritten. It returns
+// the new interval, or false when the flow is gone.
+var slowDownDevicePoll = redis.NewScript(`
+local device = redis.call('GET', KEYS[1])
+if not device then
+	return false
+end
+local decoded = cjson.decode(device)
+decoded.interval = decoded.interval + tonumber(ARGV[1])
+redis.call('SET', KEYS[1], cjson.encode(decoded), 'KEEPTTL')
+redis.call('SET', KEYS[2], 1, 'EX', decoded.interval)
+return decoded.interval
+`)
+
+// SlowDownDevicePoll raises the interval of a device that polled too fast,
+// the raised interval applies to every later poll
+func (m *OAuthRepositoryImpl) SlowDownDevicePoll(ctx context.Context, deviceCode string, increase int) (interval int, err error) {
+	hash := helper.HashToken(deviceCode)
+	return slowDownDevicePoll.Run(ctx, m.Redis, []string{"device-code:" + hash, "device-poll:" + hash}, increase).Int()
+}
+
+func (m *OAuthRepositoryImpl) getDeviceAuthorization(ctx context.Context, id string) (res *domain.DeviceAuthorization, err error) {
+	deviceModel, err := m.Redis.Get(ctx, "device-code:"+id).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	res = &domain.DeviceAuthorization{}
+	err = json.Unmarshal([]byte(deviceModel), res)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
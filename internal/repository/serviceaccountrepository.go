@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"auth/internal/domain"
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+// ServiceAccountRepository represent the service account's repository contract
+type ServiceAccountRepository interface {
+	GetByClientID(ctx context.Context, clientID string) (*domain.ServiceAccount, error)
+	Insert(ctx context.Context, input *domain.ServiceAccount) (*domain.ServiceAccount, error)
+}
+
+type ServiceAccountRepositoryImpl struct {
+	DB *sql.DB
+}
+
+func NewServiceAccountRepository(db *sql.DB) ServiceAccountRepository {
+	return &ServiceAccountRepositoryImpl{
+		DB: db,
+	}
+}
+
+func (m *ServiceAccountRepositoryImpl) GetByClientID(ctx context.Context, clientID string) (res *domain.ServiceAccount, err error) {
+	stmt, err := m.DB.PrepareContext(ctx, `SELECT id, client_id, name, secret_hash, allowed_scopes, audiences, created_at
+		FROM service_accounts WHERE client_id=$1`)
+	if err != nil {
+		return nil, err
+	}
+
+	row := stmt.QueryRowContext(ctx, clientID)
+	var account domain.ServiceAccount
+	var name sql.NullString
+
+	err = row.Scan(
+		&account.ID,
+		&account.ClientID,
+		&name,
+		&account.SecretHash,
+		pq.Array(&account.AllowedScopes),
+		pq.Array(&account.Audiences),
+		&account.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	account.Name = name.String
+
+	return &account, nil
+}
+
+func (m *ServiceAccountRepositoryImpl) Insert(ctx context.Context, input *domain.ServiceAccount) (account *domain.ServiceAccount, err error) {
+	stmt := `insert into service_accounts (client_id, name, secret_hash, allowed_scopes, audiences)
+		values ($1, $2, $3, $4, $5) returning id`
+
+	var newID int
+
+	err = m.DB.QueryRowContext(ctx, stmt,
+		input.ClientID,
+		input.Name,
+		input.SecretHash,
+		pq.Array(input.AllowedScopes),
+		pq.Array(input.Audiences),
+	).Scan(&newID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return m.GetByClientID(ctx, input.ClientID)
+}
@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"auth/internal/domain"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// SigningKeyRepository represent the signing key store's repository contract
+type SigningKeyRepository interface {
+	GetUsable(ctx context.Context) ([]*domain.SigningKey, error)
+	Rotate(ctx context.Context, next *domain.SigningKey, activeBefore time.Time) (bool, error)
+	Retire(ctx context.Context, retiringBefore time.Time) error
+}
+
+type SigningKeyRepositoryImpl struct {
+	DB *sql.DB
+}
+
+func NewSigningKeyRepository(db *sql.DB) SigningKeyRepository {
+	return &SigningKeyRepositoryImpl{
+		DB: db,
+	}
+}
+
+// GetUsable lists every key that is not retired, oldest first
+func (m *SigningKeyRepositoryImpl) GetUsable(ctx context.Context) (res []*domain.SigningKey, err error) {
+	rows, err := m.DB.QueryContext(ctx, `SELECT id, algorithm, private_key, state, created_at, state_changed_at
+		FROM signing_keys WHERE state <> $1 ORDER BY created_at`, domain.SigningKeyRetired)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*domain.SigningKey{}
+
+	for rows.Next() {
+		key := &domain.SigningKey{}
+		if err := rows.Scan(&key.ID, &key.Algorithm, &key.PrivateKey, &key.State, &key.CreatedAt, &key.StateChangedAt); err != nil {
+			return keys, err
+		}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return keys, err
+	}
+	return keys, nil
+}
+
+// Rotate moves every key one state on: the active key starts retiring, the
+// next key becomes active and next is added as the new next key. It only
+// rotates when there is no active key or the active key became active before
+// activeBefore, and reports whether it did. The table is locked, so instances
+// rotating at the same time do not rotate twice.
+func (m *SigningKeyRepositoryImpl) Rotate(ctx context.Context, next *domain.SigningKey, activeBefore time.Time) (bool, error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, `lock table signing_keys in exclusive mode`); err != nil {
+		return false, err
+	}
+
+	var activatedAt time.Time
+	err = tx.QueryRowContext(ctx, `select state_changed_at from signing_keys where state = $1`, domain.SigningKeyActive).Scan(&activatedAt)
+
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+
+	if err == nil && !activatedAt.Before(activeBefore) {
+		return false, nil
+	}
+
+	if _, err = tx.ExecContext(ctx, `update signing_keys set state = $1, state_changed_at = now() where state = $2`,
+		domain.SigningKeyRetiring, domain.SigningKeyActive); err != nil {
+		return false, err
+	}
+
+	if _, err = tx.ExecContext(ctx, `update signing_keys set state = $1, state_changed_at = now() where state = $2`,
+		domain.SigningKeyActive, domain.SigningKeyNext); err != nil {
+		return false, err
+	}
+
+	if _, err = tx.ExecContext(ctx, `insert into signing_keys (id, algorithm, private_key, state) values ($1, $2, $3, $4)`,
+		next.ID, next.Algorithm, next.PrivateKey, domain.SigningKeyNext); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// Retire stops verifying with keys that have been retiring since before
+// retiringBefore. Their private key is wiped, it has no use anymore.
+func (m *SigningKeyRepositoryImpl) Retire(ctx context.Context, retiringBefore time.Time) error {
+	_, err := m.DB.ExecContext(ctx, `update signing_keys set state = $1, state_changed_at = now(), private_key = ''::bytea
+		where state = $2 and state_changed_at < $3`, domain.SigningKeyRetired, domain.SigningKeyRetiring, retiringBefore)
+
+	return err
+}
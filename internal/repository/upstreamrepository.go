@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+)
+
+// UpstreamRepository represent the repository contract of identities linked
+// from upstream providers
+type UpstreamRepository interface {
+	GetUserID(ctx context.Context, provider string, subject string) (int, error)
+	LinkIdentity(ctx context.Context, userID int, provider string, subject string) error
+}
+
+type UpstreamRepositoryImpl struct {
+	DB *sql.DB
+}
+
+func NewUpstreamRepository(db *sql.DB) UpstreamRepository {
+	return &UpstreamRepositoryImpl{
+		DB: db,
+	}
+}
+
+func (m *UpstreamRepositoryImpl) GetUserID(ctx context.Context, provider string, subject string) (userID int, err error) {
+	stmt, err := m.DB.PrepareContext(ctx, `SELECT user_id FROM upstream_identities WHERE provider=$1 AND subject=$2`)
+	if err != nil {
+		return 0, err
+	}
+
+	err = stmt.QueryRowContext(ctx, provider, subject).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+func (m *UpstreamRepositoryImpl) LinkIdentity(ctx context.Context, userID int, provider string, subject string) error {
+	stmt := `insert into upstream_identities (user_id, provider, subject) values ($1, $2, $3)`
+
+	_, err := m.DB.ExecContext(ctx, stmt, userID, provider, subject)
+
+	return err
+}
@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"auth/internal/domain"
+	"context"
+	"database/sql"
+)
+
+// UserAttributeRepository represent the user attribute's repository contract
+type UserAttributeRepository interface {
+	GetByUserID(ctx context.Context, userID int) ([]*domain.UserAttribute, error)
+	Set(ctx context.Context, attribute *domain.UserAttribute) error
+	Delete(ctx context.Context, userID int, name string) error
+}
+
+type UserAttributeRepositoryImpl struct {
+	DB *sql.DB
+}
+
+func NewUserAttributeRepository(db *sql.DB) UserAttributeRepository {
+	return &UserAttributeRepositoryImpl{
+		DB: db,
+	}
+}
+
+func (m *UserAttributeRepositoryImpl) GetByUserID(ctx context.Context, userID int) (res []*domain.UserAttribute, err error) {
+	rows, err := m.DB.QueryContext(ctx, `SELECT user_id, name, value FROM user_attributes WHERE user_id=$1 ORDER BY name`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attributes := []*domain.UserAttribute{}
+
+	for rows.Next() {
+		attribute := &domain.UserAttribute{}
+		if err := rows.Scan(&attribute.UserID, &attribute.Name, &attribute.Value); err != nil {
+			return attributes, err
+		}
+		attributes = append(attributes, attribute)
+	}
+	if err = rows.Err(); err != nil {
+		return attributes, err
+	}
+	return attributes, nil
+}
+
+// Set adds an attribute to a user or replaces its value
+func (m *UserAttributeRepositoryImpl) Set(ctx context.Context, attribute *domain.UserAttribute) error {
+	stmt := `INSERT INTO user_attributes (user_id, name, value) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, name) DO UPDATE SET value = EXCLUDED.value`
+	_, err := m.DB.ExecContext(ctx, stmt, attribute.UserID, attribute.Name, string(attribute.Value))
+	return err
+}
+
+// Delete removes an attribute of a user, sql.ErrNoRows if it has none by
+// that name
+func (m *UserAttributeRepositoryImpl) Delete(ctx context.Context, userID int, name string) error {
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM user_attributes WHERE user_id=$1 AND name=$2`, userID, name)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
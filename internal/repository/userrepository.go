@@ -2,9 +2,11 @@ package repository
 
 import (
 	"auth/internal/domain"
+	"auth/internal/helper"
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"os"
 	"strconv"
@@ -12,6 +14,7 @@ import (
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/go-redis/redis/v8"
+	"github.com/lib/pq"
 )
 
 // UserRepository represent the user's repository contract
@@ -19,15 +22,142 @@ type UserRepository interface {
 	GetAll(context.Context) ([]*domain.User, error)
 	GetOneByID(ctx context.Context, id int) (*domain.User, error)
 	GetOneByUsername(ctx context.Context, username string) (*domain.User, error)
+	GetOneByEmail(ctx context.Context, email string) (*domain.User, error)
 	Insert(ctx context.Context, input *domain.User) (*domain.User, error)
 	Update(ctx context.Context, id int, user *domain.User) (*domain.User, error)
 	Delete(ctx context.Context, id int) error
+	MarkEmailVerified(ctx context.Context, id int) error
+	SyncDirectoryUser(ctx context.Context, id int, user *domain.User) (*domain.User, error)
 	RememberUUID(ctx context.Context, user *domain.User, uuid string) error
+	RememberImpersonatedUUID(ctx context.Context, user *domain.User, uuid string, expire time.Duration) error
+	TrackImpersonation(ctx context.Context, action *domain.ImpersonationAction) error
+	EndImpersonation(ctx context.Context, uuid string) (*domain.ImpersonationAction, error)
+	ClaimExpiredImpersonations(ctx context.Context, now time.Time) ([]*domain.ImpersonationAction, error)
 	GetUUID(ctx context.Context, uuid string) (string, error)
 	Publish(ctx context.Context, data string, topic string) error
 	DeleteUUID(ctx context.Context, uuid string)
+	DeleteUserSessions(ctx context.Context, userID int) ([]string, error)
+	DeleteUserRefreshFamilies(ctx context.Context, userID int) ([]string, error)
+	GetUserSessions(ctx context.Context, userID int) ([]*domain.Session, error)
+	TouchSession(ctx context.Context, userID int, uuid string, ip string) error
+	RememberRefreshToken(ctx context.Context, token string, family *domain.RefreshFamily) error
+	RotateRefreshToken(ctx context.Context, token string, family *domain.RefreshFamily) error
+	UseRefreshToken(ctx context.Context, token string, clientID string) (family string, reused bool, err error)
+	GetRefreshToken(ctx context.Context, token string) (family string, clientID string, err error)
+	GetRefreshFamily(ctx context.Context, family string) (*domain.RefreshFamily, error)
+	GetRefreshFamilyBySession(ctx context.Context, uuid string) (string, error)
+	DeleteRefreshFamily(ctx context.Context, family string)
+	RememberToken(ctx context.Context, key string, value string, expire time.Duration) error
+	ConsumeToken(ctx context.Context, key string) (string, error)
 }
 
+// useRefreshToken marks a refresh token as used and reports whether it had
+// already been used before, in a single round trip so that two concurrent
+// refreshes cannot both win. Tokens presented by another client than the one
+// they were issued to are treated as unknown and left untouched.
+var useRefreshToken = redis.NewScript(`
+local family = redis.call('HGET', KEYS[1], 'family')
+if not family or (redis.call('HGET', KEYS[1], 'client_id') or '') ~= ARGV[2] then
+	return false
+end
+local fresh = redis.call('HSETNX', KEYS[1], 'used', ARGV[1])
+return {family, fresh}
+`)
+
+// revokeSession deletes the session KEYS[1] and denylists its jti for as
+// long as it would have lived. It returns the session, or nil when there was
+// none.
+var revokeSession = redis.NewScript(`
+local value = redis.call('GET', KEYS[1])
+if not value then
+	return false
+end
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl > 0 then
+	redis.call('SET', KEYS[2], 1, 'PX', ttl)
+end
+redis.call('DEL', KEYS[1])
+return value
+`)
+
+// deleteIndexedSessions deletes the sessions of a user's session index
+// KEYS[1] and the index itself in one step. KEYS[2..] are the sessions the
+// index listed and their denylist entries, in pairs. When a session was
+// indexed meanwhile nothing is deleted and false is returned, so the caller
+// can look again. The jti of every session is denylisted for as long as it
+// would have lived.
+var deleteIndexedSessions = redis.NewScript(`
+local listed = {}
+for i = 2, #KEYS, 2 do
+	listed[KEYS[i]] = true
+end
+for _, uuid in ipairs(redis.call('HKEYS', KEYS[1])) do
+	if not listed[uuid] then
+		return false
+	end
+end
+local revoked = {}
+for i = 2, #KEYS, 2 do
+	local ttl = redis.call('PTTL', KEYS[i])
+	if ttl > 0 then
+		redis.call('SET', KEYS[i + 1], 1, 'PX', ttl)
+	end
+	if redis.call('DEL', KEYS[i]) == 1 then
+		table.insert(revoked, KEYS[i])
+	end
+end
+redis.call('DEL', KEYS[1])
+return revoked
+`)
+
+// sessionIndexRetries bounds how often DeleteUserSessions looks at an index
+// that keeps changing under it
+const sessionIndexRetries = 5
+
+// deleteSessionOf deletes a session only if it belongs to the user ARGV[1]
+var deleteSessionOf = redis.NewScript(`
+local value = redis.call('GET', KEYS[1])
+if not value then
+	return 0
+end
+local ok, user = pcall(cjson.decode, value)
+if not ok or type(user) ~= 'table' or user.id ~= tonumber(ARGV[1]) then
+	return 0
+end
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl > 0 then
+	redis.call('SET', KEYS[2], 1, 'PX', ttl)
+end
+redis.call('DEL', KEYS[1])
+return 1
+`)
+
+// sessionKeyPattern matches the uuids sessions are stored under
+const sessionKeyPattern = "????????-????-????-????-????????????"
+
+// rotateRefreshToken adds the next token of a family. The token lives only as
+// long as the family, which keeps the lifetime it started with. Revoked and
+// expired families are not rotated.
+var rotateRefreshToken = redis.NewScript(`
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl <= 0 or redis.call('HGET', KEYS[1], 'revoked') then
+	return false
+end
+redis.call('HSET', KEYS[2], 'family', ARGV[1], 'client_id', ARGV[2])
+redis.call('PEXPIRE', KEYS[2], ttl)
+redis.call('HSET', KEYS[1], 'uuid', ARGV[3])
+redis.call('SET', KEYS[3], ARGV[1], 'PX', ttl)
+return 1
+`)
+
+// revokeRefreshFamily marks a family revoked without touching its expiry
+var revokeRefreshFamily = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	redis.call('HSET', KEYS[1], 'revoked', 1)
+end
+return 1
+`)
+
 type UserRepositoryImpl struct {
 	DB    *sql.DB
 	Redis *redis.Client
@@ -69,7 +199,7 @@ func (m *UserRepositoryImpl) GetAll(context context.Context) (res []*domain.User
 }
 
 func (m *UserRepositoryImpl) GetOneByID(context context.Context, id int) (res *domain.User, err error) {
-	stmt, err := m.DB.PrepareContext(context, "SELECT id, email, name, username, password FROM users WHERE id=$1")
+	stmt, err := m.DB.PrepareContext(context, "SELECT id, coalesce(email, ''), name, username, password, email_verified_at, auth_source, groups, is_admin FROM users WHERE id=$1")
 	if err != nil {
 		return nil, err
 	}
@@ -83,6 +213,10 @@ func (m *UserRepositoryImpl) GetOneByID(context context.Context, id int) (res *d
 		&user.Name,
 		&user.Username,
 		&user.Password,
+		&user.EmailVerifiedAt,
+		&user.AuthSource,
+		pq.Array(&user.Groups),
+		&user.IsAdmin,
 	)
 
 	if err != nil {
@@ -93,7 +227,7 @@ func (m *UserRepositoryImpl) GetOneByID(context context.Context, id int) (res *d
 }
 
 func (m *UserRepositoryImpl) GetOneByUsername(ctx context.Context, username string) (res *domain.User, err error) {
-	stmt, err := m.DB.PrepareContext(ctx, "SELECT id, name, email, username, password FROM users WHERE username=$1")
+	stmt, err := m.DB.PrepareContext(ctx, "SELECT id, name, coalesce(email, ''), username, password, email_verified_at, auth_source, groups, is_admin FROM users WHERE username=$1")
 	if err != nil {
 		return nil, err
 	}
@@ -107,6 +241,38 @@ func (m *UserRepositoryImpl) GetOneByUsername(ctx context.Context, username stri
 		&user.Email,
 		&user.Username,
 		&user.Password,
+		&user.EmailVerifiedAt,
+		&user.AuthSource,
+		pq.Array(&user.Groups),
+		&user.IsAdmin,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (m *UserRepositoryImpl) GetOneByEmail(ctx context.Context, email string) (res *domain.User, err error) {
+	stmt, err := m.DB.PrepareContext(ctx, "SELECT id, name, coalesce(email, ''), username, password, email_verified_at, auth_source, groups, is_admin FROM users WHERE email=$1")
+	if err != nil {
+		return nil, err
+	}
+
+	row := stmt.QueryRowContext(ctx, email)
+	var user domain.User
+
+	err = row.Scan(
+		&user.ID,
+		&user.Name,
+		&user.Email,
+		&user.Username,
+		&user.Password,
+		&user.EmailVerifiedAt,
+		&user.AuthSource,
+		pq.Array(&user.Groups),
+		&user.IsAdmin,
 	)
 
 	if err != nil {
@@ -117,8 +283,10 @@ func (m *UserRepositoryImpl) GetOneByUsername(ctx context.Context, username stri
 }
 
 func (m *UserRepositoryImpl) Insert(ctx context.Context, input *domain.User) (user *domain.User, err error) {
-	stmt := `insert into users (name, email, username, password)
-		values ($1, $2, $3, $4) returning id`
+	// Users without an address store NULL, the unique constraint allows
+	// any number of them
+	stmt := `insert into users (name, email, username, password, auth_source, groups)
+		values ($1, nullif($2, ''), $3, $4, coalesce(nullif($5, ''), 'local'), coalesce($6, '{}')) returning id`
 
 	var newID int
 
@@ -127,6 +295,8 @@ func (m *UserRepositoryImpl) Insert(ctx context.Context, input *domain.User) (us
 		input.Email,
 		input.Username,
 		input.Password,
+		input.AuthSource,
+		pq.Array(input.Groups),
 	).Scan(&newID)
 
 	if err != nil {
@@ -146,7 +316,7 @@ func (m *UserRepositoryImpl) Update(ctx context.Context, id int, update *domain.
 	stmt := `update users set
 		name = $1,
 		username = $2,
-		password = $3,
+		password = $3
 		where id = $4
 	`
 
@@ -181,12 +351,56 @@ func (m *UserRepositoryImpl) Delete(ctx context.Context, id int) (err error) {
 	return nil
 }
 
+func (m *UserRepositoryImpl) MarkEmailVerified(ctx context.Context, id int) (err error) {
+	stmt := `update users set email_verified_at = now() where id = $1 and email_verified_at is null`
+
+	_, err = m.DB.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SyncDirectoryUser copies the attributes a directory owns onto the local row
+// of one of its users. The directory vouches for the address it hands out.
+func (m *UserRepositoryImpl) SyncDirectoryUser(ctx context.Context, id int, sync *domain.User) (user *domain.User, err error) {
+	stmt := `update users set
+		name = $1,
+		email = nullif($2, ''),
+		email_verified_at = case when nullif($2, '') is null then null else coalesce(email_verified_at, now()) end,
+		groups = coalesce($3, '{}')
+		where id = $4`
+
+	_, err = m.DB.ExecContext(ctx, stmt,
+		sync.Name,
+		sync.Email,
+		pq.Array(sync.Groups),
+		id,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return m.GetOneByID(ctx, id)
+}
+
 func (m *UserRepositoryImpl) RememberUUID(ctx context.Context, user *domain.User, uuid string) error {
 	userModel, _ := json.Marshal(user)
+	createdAt := time.Now()
 	jwtHourExpire := os.Getenv("JWT_EXPIRE_HOUR")
 	convJwtHour, _ := strconv.Atoi(jwtHourExpire)
 	time := time.Hour * time.Duration(convJwtHour)
 	m.Redis.Set(ctx, uuid, userModel, time).Err()
+	// Index the session under its user, so all of them can be found again
+	// without knowing their uuid
+	sessionModel, _ := json.Marshal(newSession(ctx, uuid, createdAt))
+	indexKey := "user-sessions:" + strconv.Itoa(user.ID)
+	m.Redis.HSet(ctx, indexKey, uuid, sessionModel)
+	if ttl, _ := m.Redis.TTL(ctx, indexKey).Result(); ttl < time {
+		m.Redis.Expire(ctx, indexKey, time)
+	}
 	publishLogin := &domain.PublishAuthLogin{
 		Action: "login",
 		Data: domain.LoginAction{
@@ -203,13 +417,422 @@ func (m *UserRepositoryImpl) RememberUUID(ctx context.Context, user *domain.User
 	return err
 }
 
+// RememberImpersonatedUUID stores a session an admin opened as user. It is
+// indexed like any other session, so revoking the user's sessions ends it too,
+// but it is not announced as a login.
+func (m *UserRepositoryImpl) RememberImpersonatedUUID(ctx context.Context, user *domain.User, uuid string, expire time.Duration) error {
+	userModel, _ := json.Marshal(user)
+	err := m.Redis.Set(ctx, uuid, userModel, expire).Err()
+	if err != nil {
+		return err
+	}
+	sessionModel, _ := json.Marshal(newSession(ctx, uuid, time.Now()))
+	indexKey := "user-sessions:" + strconv.Itoa(user.ID)
+	m.Redis.HSet(ctx, indexKey, uuid, sessionModel)
+	if ttl, _ := m.Redis.TTL(ctx, indexKey).Result(); ttl < expire {
+		m.Redis.Expire(ctx, indexKey, expire)
+	}
+	return nil
+}
+
+// Running impersonations, by the time they end and by uuid. The hash tag
+// keeps both keys on one slot of a Redis Cluster.
+const (
+	impersonationEndsKey = "{impersonations}:ends"
+	impersonationsKey    = "{impersonations}:actions"
+)
+
+// endImpersonation takes an impersonation off the running list and returns
+// it, nothing when it was not running anymore
+var endImpersonation = redis.NewScript(`
+local action = redis.call('HGET', KEYS[2], ARGV[1])
+if not action then
+	return false
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('HDEL', KEYS[2], ARGV[1])
+return action
+`)
+
+// claimExpiredImpersonations takes every impersonation that ended by ARGV[1]
+// off the running list, so only one instance reports each of them
+var claimExpiredImpersonations = redis.NewScript(`
+local claimed = {}
+for _, uuid in ipairs(redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])) do
+	local action = redis.call('HGET', KEYS[2], uuid)
+	redis.call('ZREM', KEYS[1], uuid)
+	redis.call('HDEL', KEYS[2], uuid)
+	if action then
+		table.insert(claimed, action)
+	end
+end
+return claimed
+`)
+
+// TrackImpersonation lists an impersonation as running until its Exp
+func (m *UserRepositoryImpl) TrackImpersonation(ctx context.Context, action *domain.ImpersonationAction) error {
+	actionModel, _ := json.Marshal(action)
+
+	_, err := m.Redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, impersonationEndsKey, &redis.Z{Score: float64(action.Exp), Member: action.Uuid})
+		pipe.HSet(ctx, impersonationsKey, action.Uuid, actionModel)
+		return nil
+	})
+
+	return err
+}
+
+// EndImpersonation takes an impersonation off the running list. It returns
+// nil when the impersonation was not running.
+func (m *UserRepositoryImpl) EndImpersonation(ctx context.Context, uuid string) (*domain.ImpersonationAction, error) {
+	res, err := endImpersonation.Run(ctx, m.Redis, []string{impersonationEndsKey, impersonationsKey}, uuid).Text()
+
+	if err == redis.Nil {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	action := &domain.ImpersonationAction{}
+	err = json.Unmarshal([]byte(res), action)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return action, nil
+}
+
+// ClaimExpiredImpersonations takes the impersonations that ran out by now
+// off the running list and returns them
+func (m *UserRepositoryImpl) ClaimExpiredImpersonations(ctx context.Context, now time.Time) ([]*domain.ImpersonationAction, error) {
+	res, err := claimExpiredImpersonations.Run(ctx, m.Redis, []string{impersonationEndsKey, impersonationsKey}, now.Unix()).StringSlice()
+
+	if err != nil {
+		return nil, err
+	}
+
+	actions := []*domain.ImpersonationAction{}
+	for _, actionModel := range res {
+		action := &domain.ImpersonationAction{}
+		if json.Unmarshal([]byte(actionModel), action) == nil {
+			actions = append(actions, action)
+		}
+	}
+
+	return actions, nil
+}
+
 func (m *UserRepositoryImpl) GetUUID(ctx context.Context, uuid string) (res string, err error) {
 	res, err = m.Redis.Get(ctx, uuid).Result()
 	return res, err
 }
 
+// DeleteUUID ends a session. Its jti is denylisted for as long as its token
+// lives, stateless sessions are not looked up and would outlive it otherwise.
 func (m *UserRepositoryImpl) DeleteUUID(ctx context.Context, uuid string) {
-	m.Redis.Del(ctx, uuid)
+	res, _ := m.revokeSession(ctx, uuid)
+	if res != "" {
+		user := domain.User{}
+		json.Unmarshal([]byte(res), &user)
+		m.Redis.HDel(ctx, "user-sessions:"+strconv.Itoa(user.ID), uuid)
+	}
+}
+
+// revokeSession deletes a session and denylists its jti in one step, and
+// returns the user it belonged to. A session that is already gone is "".
+func (m *UserRepositoryImpl) revokeSession(ctx context.Context, uuid string) (string, error) {
+	res, err := revokeSession.Run(ctx, m.Redis, []string{uuid, domain.RevokedTokenKey(uuid)}).Text()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return res, err
+}
+
+// DeleteUserSessions deletes every session of a user and returns the uuids
+// of those still alive. Sessions opened before they were indexed are found by
+// scanning the keyspace, each is deleted only after checking whose it is.
+// The index goes last, in one step, so a session opened during the scan is
+// deleted with it.
+func (m *UserRepositoryImpl) DeleteUserSessions(ctx context.Context, userID int) ([]string, error) {
+	revoked := []string{}
+
+	iter := m.Redis.Scan(ctx, 0, sessionKeyPattern, 1000).Iterator()
+	for iter.Next(ctx) {
+		uuid := iter.Val()
+		deleted, err := deleteSessionOf.Run(ctx, m.Redis, []string{uuid, domain.RevokedTokenKey(uuid)}, userID).Int()
+		if err != nil {
+			return revoked, err
+		}
+		if deleted == 1 {
+			revoked = append(revoked, uuid)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return revoked, err
+	}
+
+	indexKey := "user-sessions:" + strconv.Itoa(userID)
+	for try := 0; try < sessionIndexRetries; try++ {
+		uuids, err := m.Redis.HKeys(ctx, indexKey).Result()
+		if err != nil {
+			return revoked, err
+		}
+
+		keys := []string{indexKey}
+		for _, uuid := range uuids {
+			keys = append(keys, uuid, domain.RevokedTokenKey(uuid))
+		}
+
+		indexed, err := deleteIndexedSessions.Run(ctx, m.Redis, keys).StringSlice()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return revoked, err
+		}
+
+		return append(revoked, indexed...), nil
+	}
+
+	return revoked, errors.New("the session index kept changing")
+}
+
+// DeleteUserRefreshFamilies revokes every refresh token family of a user and
+// returns their ids. Revoked families stay until they expire, like those of
+// DeleteRefreshFamily.
+func (m *UserRepositoryImpl) DeleteUserRefreshFamilies(ctx context.Context, userID int) ([]string, error) {
+	indexKey := "user-refresh-families:" + strconv.Itoa(userID)
+	families, err := m.Redis.SMembers(ctx, indexKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, family := range families {
+		if err := m.revokeRefreshFamily(ctx, family); err != nil {
+			return nil, err
+		}
+		if err := m.Redis.SRem(ctx, indexKey, family).Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return families, nil
+}
+
+// GetUserSessions lists the indexed sessions of a user that are still alive.
+// Entries whose session already expired are pruned on the way.
+func (m *UserRepositoryImpl) GetUserSessions(ctx context.Context, userID int) (res []*domain.Session, err error) {
+	indexKey := "user-sessions:" + strconv.Itoa(userID)
+	values, err := m.Redis.HGetAll(ctx, indexKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for uuid, value := range values {
+		exists, err := m.Redis.Exists(ctx, uuid).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		if exists == 0 {
+			m.Redis.HDel(ctx, indexKey, uuid)
+			continue
+		}
+
+		session := &domain.Session{}
+		json.Unmarshal([]byte(value), session)
+		session.Uuid = uuid
+		res = append(res, session)
+	}
+
+	return res, nil
+}
+
+// touchSession updates the index entry ARGV[1] of a session, only if it is
+// still indexed. Logging out takes a session out of the index, so a touch
+// racing it neither brings the session back nor recreates a deleted index
+// without its expiry.
+var touchSession = redis.NewScript(`
+if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+return 1
+`)
+
+// TouchSession records that a session was just used from ip. It writes at
+// most once a sessionTouchInterval unless the ip changed.
+func (m *UserRepositoryImpl) TouchSession(ctx context.Context, userID int, uuid string, ip string) error {
+	indexKey := "user-sessions:" + strconv.Itoa(userID)
+	value, err := m.Redis.HGet(ctx, indexKey, uuid).Result()
+	if err != nil {
+		return err
+	}
+
+	session := &domain.Session{}
+	json.Unmarshal([]byte(value), session)
+
+	now := time.Now()
+	if session.IP == ip && now.Sub(session.LastSeenAt) < sessionTouchInterval {
+		return nil
+	}
+
+	session.IP = ip
+	session.LastSeenAt = now
+	sessionModel, _ := json.Marshal(session)
+
+	return touchSession.Run(ctx, m.Redis, []string{indexKey}, uuid, sessionModel).Err()
+}
+
+// sessionTouchInterval is how stale the last seen time of a session may get
+const sessionTouchInterval = time.Minute
+
+// newSession is the index entry of a session opened by the request behind ctx
+func newSession(ctx context.Context, uuid string, createdAt time.Time) *domain.Session {
+	client := helper.ClientInfoFrom(ctx)
+
+	return &domain.Session{
+		Uuid:       uuid,
+		Device:     client.Device,
+		IP:         client.IP,
+		CreatedAt:  createdAt,
+		LastSeenAt: createdAt,
+	}
+}
+
+// Used when REFRESH_TOKEN_EXPIRE_HOUR is not set
+const defaultRefreshTokenExpireHour = 720
+
+// refreshTokenExpire is the absolute lifetime of a refresh token family
+func refreshTokenExpire() time.Duration {
+	refreshHourExpire := os.Getenv("REFRESH_TOKEN_EXPIRE_HOUR")
+	convRefreshHour, _ := strconv.Atoi(refreshHourExpire)
+	if convRefreshHour <= 0 {
+		convRefreshHour = defaultRefreshTokenExpireHour
+	}
+	return time.Hour * time.Duration(convRefreshHour)
+}
+
+// RememberRefreshToken starts a family with its first token
+func (m *UserRepositoryImpl) RememberRefreshToken(ctx context.Context, token string, family *domain.RefreshFamily) error {
+	expire := refreshTokenExpire()
+	familyKey := "refresh-family:" + family.ID
+	tokenKey := "refresh-token:" + helper.HashToken(token)
+
+	pipe := m.Redis.TxPipeline()
+	pipe.HSet(ctx, tokenKey, "family", family.ID, "client_id", family.ClientID)
+	pipe.Expire(ctx, tokenKey, expire)
+	pipe.HSet(ctx, familyKey, "uuid", family.Uuid, "user_id", family.UserID, "client_id", family.ClientID, "scope", family.Scope)
+	pipe.Expire(ctx, familyKey, expire)
+	pipe.Set(ctx, "refresh-session:"+family.Uuid, family.ID, expire)
+	// Index the family under its user, a newer family always lives longest
+	pipe.SAdd(ctx, "user-refresh-families:"+strconv.Itoa(family.UserID), family.ID)
+	pipe.Expire(ctx, "user-refresh-families:"+strconv.Itoa(family.UserID), expire)
+	_, err := pipe.Exec(ctx)
+
+	return err
+}
+
+// RotateRefreshToken adds the next token of an existing family and moves it
+// to family.Uuid. It returns redis.Nil when the family is revoked or expired.
+func (m *UserRepositoryImpl) RotateRefreshToken(ctx context.Context, token string, family *domain.RefreshFamily) error {
+	keys := []string{
+		"refresh-family:" + family.ID,
+		"refresh-token:" + helper.HashToken(token),
+		"refresh-session:" + family.Uuid,
+	}
+
+	return rotateRefreshToken.Run(ctx, m.Redis, keys, family.ID, family.ClientID, family.Uuid).Err()
+}
+
+func (m *UserRepositoryImpl) UseRefreshToken(ctx context.Context, token string, clientID string) (family string, reused bool, err error) {
+	res, err := useRefreshToken.Run(ctx, m.Redis, []string{"refresh-token:" + helper.HashToken(token)}, time.Now().Unix(), clientID).Slice()
+	if err != nil {
+		return "", false, err
+	}
+
+	family, _ = res[0].(string)
+	fresh, _ := res[1].(int64)
+
+	return family, fresh == 0, nil
+}
+
+// GetRefreshToken looks up a refresh token without using it
+func (m *UserRepositoryImpl) GetRefreshToken(ctx context.Context, token string) (family string, clientID string, err error) {
+	values, err := m.Redis.HMGet(ctx, "refresh-token:"+helper.HashToken(token), "family", "client_id").Result()
+	if err != nil {
+		return "", "", err
+	}
+
+	family, _ = values[0].(string)
+	clientID, _ = values[1].(string)
+
+	if family == "" {
+		return "", "", redis.Nil
+	}
+
+	return family, clientID, nil
+}
+
+func (m *UserRepositoryImpl) GetRefreshFamily(ctx context.Context, family string) (res *domain.RefreshFamily, err error) {
+	values, err := m.Redis.HGetAll(ctx, "refresh-family:"+family).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(values) == 0 {
+		return nil, redis.Nil
+	}
+
+	userID, _ := strconv.Atoi(values["user_id"])
+
+	return &domain.RefreshFamily{
+		ID:       family,
+		Uuid:     values["uuid"],
+		UserID:   userID,
+		ClientID: values["client_id"],
+		Scope:    values["scope"],
+		Revoked:  values["revoked"] != "",
+	}, nil
+}
+
+func (m *UserRepositoryImpl) GetRefreshFamilyBySession(ctx context.Context, uuid string) (res string, err error) {
+	res, err = m.Redis.Get(ctx, "refresh-session:"+uuid).Result()
+	return res, err
+}
+
+// DeleteRefreshFamily revokes a family. It and its tokens stay until the
+// family would have expired, so a replayed token is still told apart from an
+// unknown one.
+func (m *UserRepositoryImpl) DeleteRefreshFamily(ctx context.Context, family string) {
+	m.revokeRefreshFamily(ctx, family)
+}
+
+func (m *UserRepositoryImpl) revokeRefreshFamily(ctx context.Context, family string) error {
+	uuid, err := m.Redis.HGet(ctx, "refresh-family:"+family, "uuid").Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	if uuid != "" {
+		if err := m.Redis.Del(ctx, "refresh-session:"+uuid).Err(); err != nil {
+			return err
+		}
+	}
+	return revokeRefreshFamily.Run(ctx, m.Redis, []string{"refresh-family:" + family}).Err()
+}
+
+func (m *UserRepositoryImpl) RememberToken(ctx context.Context, key string, value string, expire time.Duration) error {
+	return m.Redis.Set(ctx, key, value, expire).Err()
+}
+
+// ConsumeToken reads and deletes a key in one step, so that whatever it holds
+// can only be used once.
+func (m *UserRepositoryImpl) ConsumeToken(ctx context.Context, key string) (res string, err error) {
+	res, err = m.Redis.GetDel(ctx, key).Result()
+	return res, err
 }
 
 func (m *UserRepositoryImpl) Publish(ctx context.Context, data string, topic string) error {
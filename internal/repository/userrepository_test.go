@@ -0,0 +1,381 @@
+package repository
+
+import (
+	"auth/internal/domain"
+	"auth/internal/helper"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func newTestUserRepository(t *testing.T) (*UserRepositoryImpl, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return &UserRepositoryImpl{Redis: client}, server
+}
+
+func TestRefreshFamilyKeepsItsLifetime(t *testing.T) {
+	t.Setenv("REFRESH_TOKEN_EXPIRE_HOUR", "10")
+
+	repo, server := newTestUserRepository(t)
+	ctx := context.Background()
+
+	family := &domain.RefreshFamily{ID: "family", Uuid: "first", UserID: 1}
+	if err := repo.RememberRefreshToken(ctx, "first-token", family); err != nil {
+		t.Fatal(err)
+	}
+
+	server.FastForward(4 * time.Hour)
+
+	family.Uuid = "second"
+	if err := repo.RotateRefreshToken(ctx, "second-token", family); err != nil {
+		t.Fatal(err)
+	}
+
+	if ttl := server.TTL("refresh-family:family"); ttl != 6*time.Hour {
+		t.Errorf("expected rotation to keep the family expiry, got %s", ttl)
+	}
+	if ttl := server.TTL("refresh-token:" + helper.HashToken("second-token")); ttl != 6*time.Hour {
+		t.Errorf("expected the new token to expire with its family, got %s", ttl)
+	}
+	if ttl := server.TTL("refresh-session:second"); ttl != 6*time.Hour {
+		t.Errorf("expected the session link to expire with its family, got %s", ttl)
+	}
+}
+
+func TestRevokedRefreshFamilyIsRecognised(t *testing.T) {
+	repo, server := newTestUserRepository(t)
+	ctx := context.Background()
+
+	family := &domain.RefreshFamily{ID: "family", Uuid: "session", UserID: 1}
+	if err := repo.RememberRefreshToken(ctx, "token", family); err != nil {
+		t.Fatal(err)
+	}
+
+	repo.DeleteRefreshFamily(ctx, "family")
+
+	if server.Exists("refresh-session:session") {
+		t.Error("expected the session link to be gone")
+	}
+
+	familyID, _, err := repo.GetRefreshToken(ctx, "token")
+	if err != nil || familyID != "family" {
+		t.Fatalf("expected the token to still name its family, got %q %v", familyID, err)
+	}
+
+	revoked, err := repo.GetRefreshFamily(ctx, familyID)
+	if err != nil || !revoked.Revoked {
+		t.Fatalf("expected a revoked family, got %+v %v", revoked, err)
+	}
+	if ttl := server.TTL("refresh-family:family"); ttl <= 0 {
+		t.Errorf("expected the revoked family to keep its expiry, got %s", ttl)
+	}
+
+	family.Uuid = "other"
+	if err := repo.RotateRefreshToken(ctx, "next-token", family); !errors.Is(err, redis.Nil) {
+		t.Errorf("expected a revoked family not to rotate, got %v", err)
+	}
+}
+
+func TestRefreshTokenExpireDefault(t *testing.T) {
+	t.Setenv("REFRESH_TOKEN_EXPIRE_HOUR", "")
+
+	if expire := refreshTokenExpire(); expire != defaultRefreshTokenExpireHour*time.Hour {
+		t.Errorf("expected the default lifetime, got %s", expire)
+	}
+}
+
+func TestImpersonationEndsOnce(t *testing.T) {
+	repo, _ := newTestUserRepository(t)
+	ctx := context.Background()
+
+	action := &domain.ImpersonationAction{Uuid: "session", AdminID: 1, UserID: 2, Exp: time.Now().Add(time.Hour).Unix()}
+	if err := repo.TrackImpersonation(ctx, action); err != nil {
+		t.Fatal(err)
+	}
+
+	ended, err := repo.EndImpersonation(ctx, "session")
+	if err != nil || ended == nil || ended.AdminID != 1 || ended.UserID != 2 {
+		t.Fatalf("expected the running impersonation, got %+v %v", ended, err)
+	}
+
+	ended, err = repo.EndImpersonation(ctx, "session")
+	if err != nil || ended != nil {
+		t.Errorf("expected nothing left to end, got %+v %v", ended, err)
+	}
+}
+
+func TestExpiredImpersonationsAreClaimedOnce(t *testing.T) {
+	repo, _ := newTestUserRepository(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	repo.TrackImpersonation(ctx, &domain.ImpersonationAction{Uuid: "over", AdminID: 1, UserID: 2, Exp: now.Add(-time.Minute).Unix()})
+	repo.TrackImpersonation(ctx, &domain.ImpersonationAction{Uuid: "running", AdminID: 1, UserID: 3, Exp: now.Add(time.Minute).Unix()})
+
+	claimed, err := repo.ClaimExpiredImpersonations(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 || claimed[0].Uuid != "over" {
+		t.Fatalf("expected only the expired impersonation, got %+v", claimed)
+	}
+
+	claimed, _ = repo.ClaimExpiredImpersonations(ctx, now)
+	if len(claimed) != 0 {
+		t.Errorf("expected an impersonation to be claimed once, got %+v", claimed)
+	}
+
+	if ended, _ := repo.EndImpersonation(ctx, "running"); ended == nil {
+		t.Error("expected the running impersonation to be left alone")
+	}
+}
+
+func TestDeleteUUIDDenylistsTheSession(t *testing.T) {
+	repo, server := newTestUserRepository(t)
+	ctx := context.Background()
+
+	// Indexed like a login, without announcing it
+	if err := repo.RememberImpersonatedUUID(ctx, &domain.User{ID: 1}, "session", 2*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	server.FastForward(30 * time.Minute)
+	repo.DeleteUUID(ctx, "session")
+
+	if server.Exists("session") {
+		t.Error("expected the session to be gone")
+	}
+	if server.HGet("user-sessions:1", "session") != "" {
+		t.Error("expected the session to leave the index")
+	}
+	if ttl := server.TTL(domain.RevokedTokenKey("session")); ttl != 90*time.Minute {
+		t.Errorf("expected the jti to be denylisted for the rest of the token lifetime, got %s", ttl)
+	}
+
+	server.FastForward(90 * time.Minute)
+
+	if server.Exists(domain.RevokedTokenKey("session")) {
+		t.Error("expected the denylist entry to expire with the token")
+	}
+}
+
+func TestDeleteUUIDOfAnExpiredSession(t *testing.T) {
+	repo, server := newTestUserRepository(t)
+
+	repo.DeleteUUID(context.Background(), "gone")
+
+	if server.Exists(domain.RevokedTokenKey("gone")) {
+		t.Error("expected nothing to denylist for a session that already ended")
+	}
+}
+
+func TestTouchSessionRecordsTheIP(t *testing.T) {
+	repo, server := newTestUserRepository(t)
+	ctx := context.Background()
+
+	if err := repo.RememberImpersonatedUUID(ctx, &domain.User{ID: 1}, "session", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.TouchSession(ctx, 1, "session", "203.0.113.7"); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := repo.GetUserSessions(ctx, 1)
+	if err != nil || len(sessions) != 1 || sessions[0].IP != "203.0.113.7" {
+		t.Fatalf("expected the session to be seen from the new ip, got %+v %v", sessions, err)
+	}
+	if ttl := server.TTL("user-sessions:1"); ttl != time.Hour {
+		t.Errorf("expected the index to keep its expiry, got %s", ttl)
+	}
+}
+
+func TestTouchSessionRacingLogout(t *testing.T) {
+	repo, server := newTestUserRepository(t)
+	ctx := context.Background()
+
+	repo.RememberImpersonatedUUID(ctx, &domain.User{ID: 1}, "session", time.Hour)
+	repo.RememberImpersonatedUUID(ctx, &domain.User{ID: 1}, "other", time.Hour)
+
+	// The session was read before the logout and is written after it
+	repo.DeleteUUID(ctx, "session")
+	touchSession.Run(ctx, repo.Redis, []string{"user-sessions:1"}, "session", "{}")
+
+	if server.HGet("user-sessions:1", "session") != "" {
+		t.Error("expected a logged out session to stay out of the index")
+	}
+
+	server.Del("user-sessions:1")
+	touchSession.Run(ctx, repo.Redis, []string{"user-sessions:1"}, "other", "{}")
+
+	if server.Exists("user-sessions:1") {
+		t.Error("expected a deleted index not to be recreated")
+	}
+}
+
+func TestRevokeSessionScript(t *testing.T) {
+	repo, server := newTestUserRepository(t)
+	ctx := context.Background()
+
+	server.Set("session", `{"id":1}`)
+	server.SetTTL("session", time.Hour)
+	server.Set("forever", `{"id":1}`)
+
+	value, err := revokeSession.Run(ctx, repo.Redis, []string{"session", domain.RevokedTokenKey("session")}).Text()
+	if err != nil || value != `{"id":1}` {
+		t.Fatalf("expected the revoked session back, got %q %v", value, err)
+	}
+	if server.Exists("session") || server.TTL(domain.RevokedTokenKey("session")) != time.Hour {
+		t.Error("expected the session to be swapped for a denylist entry")
+	}
+
+	// Without an expiry there is no token lifetime to denylist for
+	revokeSession.Run(ctx, repo.Redis, []string{"forever", domain.RevokedTokenKey("forever")})
+	if server.Exists("forever") || server.Exists(domain.RevokedTokenKey("forever")) {
+		t.Error("expected a session without expiry to be deleted and not denylisted")
+	}
+
+	if _, err := revokeSession.Run(ctx, repo.Redis, []string{"gone", domain.RevokedTokenKey("gone")}).Text(); !errors.Is(err, redis.Nil) {
+		t.Errorf("expected nothing to revoke, got %v", err)
+	}
+}
+
+func TestDeleteUserSessions(t *testing.T) {
+	repo, server := newTestUserRepository(t)
+	ctx := context.Background()
+
+	repo.RememberImpersonatedUUID(ctx, &domain.User{ID: 1}, "first", time.Hour)
+	repo.RememberImpersonatedUUID(ctx, &domain.User{ID: 1}, "second", 2*time.Hour)
+	repo.RememberImpersonatedUUID(ctx, &domain.User{ID: 2}, "someone-else", time.Hour)
+
+	// Expired, but still indexed
+	repo.RememberImpersonatedUUID(ctx, &domain.User{ID: 1}, "expired", time.Hour)
+	server.Del("expired")
+
+	revoked, err := repo.DeleteUserSessions(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revoked) != 2 {
+		t.Fatalf("expected the two live sessions to be revoked, got %v", revoked)
+	}
+
+	for uuid, ttl := range map[string]time.Duration{"first": time.Hour, "second": 2 * time.Hour} {
+		if server.Exists(uuid) {
+			t.Errorf("expected session %s to be gone", uuid)
+		}
+		if got := server.TTL(domain.RevokedTokenKey(uuid)); got != ttl {
+			t.Errorf("expected %s to be denylisted for %s, got %s", uuid, ttl, got)
+		}
+	}
+
+	if server.Exists("user-sessions:1") {
+		t.Error("expected the index to be emptied")
+	}
+	if !server.Exists("someone-else") || server.HGet("user-sessions:2", "someone-else") == "" {
+		t.Error("expected the sessions of other users to be left alone")
+	}
+}
+
+func TestDeleteUserSessionsKeepsUnrevokedSessionsIndexed(t *testing.T) {
+	repo, server := newTestUserRepository(t)
+	ctx := context.Background()
+
+	repo.RememberImpersonatedUUID(ctx, &domain.User{ID: 1}, "session", time.Hour)
+	server.Close()
+
+	if _, err := repo.DeleteUserSessions(ctx, 1); err == nil {
+		t.Fatal("expected the failure to be reported")
+	}
+
+	server.Restart()
+
+	if server.HGet("user-sessions:1", "session") == "" {
+		t.Error("expected the session to stay indexed for a retry")
+	}
+
+	if revoked, err := repo.DeleteUserSessions(ctx, 1); err != nil || len(revoked) != 1 {
+		t.Errorf("expected the retry to revoke the session, got %v %v", revoked, err)
+	}
+}
+
+func TestDeleteUserSessionsFindsUnindexedSessions(t *testing.T) {
+	repo, server := newTestUserRepository(t)
+	ctx := context.Background()
+
+	mine := "8d5a2c4e-1f3b-4c6d-9e8f-0a1b2c3d4e5f"
+	theirs := "1a2b3c4d-5e6f-4a8b-9c0d-1e2f3a4b5c6d"
+
+	// Stored before sessions were indexed
+	server.Set(mine, `{"id":1}`)
+	server.SetTTL(mine, time.Hour)
+	server.Set(theirs, `{"id":2}`)
+	server.SetTTL(theirs, time.Hour)
+
+	revoked, err := repo.DeleteUserSessions(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(revoked) != 1 || revoked[0] != mine {
+		t.Fatalf("expected the unindexed session to be revoked, got %v", revoked)
+	}
+	if server.Exists(mine) || server.TTL(domain.RevokedTokenKey(mine)) != time.Hour {
+		t.Error("expected the unindexed session to be deleted and denylisted")
+	}
+	if !server.Exists(theirs) {
+		t.Error("expected a session of another user to be left alone")
+	}
+}
+
+func TestDeleteIndexedSessionsRefusesAChangedIndex(t *testing.T) {
+	repo, server := newTestUserRepository(t)
+	ctx := context.Background()
+
+	repo.RememberImpersonatedUUID(ctx, &domain.User{ID: 1}, "listed", time.Hour)
+	repo.RememberImpersonatedUUID(ctx, &domain.User{ID: 1}, "opened-meanwhile", time.Hour)
+
+	keys := []string{"user-sessions:1", "listed", domain.RevokedTokenKey("listed")}
+	if _, err := deleteIndexedSessions.Run(ctx, repo.Redis, keys).StringSlice(); !errors.Is(err, redis.Nil) {
+		t.Fatalf("expected a changed index to be refused, got %v", err)
+	}
+
+	if !server.Exists("listed") || !server.Exists("opened-meanwhile") {
+		t.Error("expected nothing to be deleted")
+	}
+}
+
+func TestDeleteUserRefreshFamilies(t *testing.T) {
+	repo, server := newTestUserRepository(t)
+	ctx := context.Background()
+
+	repo.RememberRefreshToken(ctx, "first-token", &domain.RefreshFamily{ID: "first", Uuid: "expired", UserID: 1})
+	repo.RememberRefreshToken(ctx, "second-token", &domain.RefreshFamily{ID: "second", Uuid: "live", UserID: 1})
+	repo.RememberRefreshToken(ctx, "other-token", &domain.RefreshFamily{ID: "other", Uuid: "theirs", UserID: 2})
+
+	families, err := repo.DeleteUserRefreshFamilies(ctx, 1)
+	if err != nil || len(families) != 2 {
+		t.Fatalf("expected both families to be revoked, got %v %v", families, err)
+	}
+
+	for _, id := range []string{"first", "second"} {
+		if family, err := repo.GetRefreshFamily(ctx, id); err != nil || !family.Revoked {
+			t.Errorf("expected family %s to be revoked, got %+v %v", id, family, err)
+		}
+	}
+	if family, _ := repo.GetRefreshFamily(ctx, "other"); family.Revoked {
+		t.Error("expected the family of another user to be left alone")
+	}
+	if server.Exists("user-refresh-families:1") || server.Exists("refresh-session:live") {
+		t.Error("expected the family index and session links to be gone")
+	}
+}
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"auth/internal/domain"
+	"context"
+	"database/sql"
+)
+
+// WebauthnRepository represent the passkey credential's repository contract
+type WebauthnRepository interface {
+	GetByUserID(ctx context.Context, userID int) ([]*domain.WebauthnCredential, error)
+	GetOneByCredentialID(ctx context.Context, credentialID []byte) (*domain.WebauthnCredential, error)
+	Insert(ctx context.Context, input *domain.WebauthnCredential) (*domain.WebauthnCredential, error)
+	UpdateSignCount(ctx context.Context, id int, signCount uint32) error
+}
+
+type WebauthnRepositoryImpl struct {
+	DB *sql.DB
+}
+
+func NewWebauthnRepository(db *sql.DB) WebauthnRepository {
+	return &WebauthnRepositoryImpl{
+		DB: db,
+	}
+}
+
+func (m *WebauthnRepositoryImpl) GetByUserID(ctx context.Context, userID int) (res []*domain.WebauthnCredential, err error) {
+	rows, err := m.DB.QueryContext(ctx, `SELECT id, user_id, name, credential_id, public_key, aaguid, sign_count, created_at, last_used_at
+		FROM webauthn_credentials WHERE user_id=$1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var credentials []*domain.WebauthnCredential
+
+	for rows.Next() {
+		credential := &domain.WebauthnCredential{}
+		var name sql.NullString
+		if err := rows.Scan(&credential.ID, &credential.UserID, &name, &credential.CredentialID, &credential.PublicKey,
+			&credential.AAGUID, &credential.SignCount, &credential.CreatedAt, &credential.LastUsedAt); err != nil {
+			return credentials, err
+		}
+		credential.Name = name.String
+		credentials = append(credentials, credential)
+	}
+	if err = rows.Err(); err != nil {
+		return credentials, err
+	}
+	return credentials, nil
+}
+
+func (m *WebauthnRepositoryImpl) GetOneByCredentialID(ctx context.Context, credentialID []byte) (res *domain.WebauthnCredential, err error) {
+	stmt, err := m.DB.PrepareContext(ctx, `SELECT id, user_id, name, credential_id, public_key, aaguid, sign_count, created_at, last_used_at
+		FROM webauthn_credentials WHERE credential_id=$1`)
+	if err != nil {
+		return nil, err
+	}
+
+	row := stmt.QueryRowContext(ctx, credentialID)
+	var credential domain.WebauthnCredential
+	var name sql.NullString
+
+	err = row.Scan(
+		&credential.ID,
+		&credential.UserID,
+		&name,
+		&credential.CredentialID,
+		&credential.PublicKey,
+		&credential.AAGUID,
+		&credential.SignCount,
+		&credential.CreatedAt,
+		&credential.LastUsedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	credential.Name = name.String
+
+	return &credential, nil
+}
+
+func (m *WebauthnRepositoryImpl) Insert(ctx context.Context, input *domain.WebauthnCredential) (credential *domain.WebauthnCredential, err error) {
+	stmt := `insert into webauthn_credentials (user_id, name, credential_id, public_key, aaguid, sign_count)
+		values ($1, $2, $3, $4, $5, $6) returning id`
+
+	var newID int
+
+	err = m.DB.QueryRowContext(ctx, stmt,
+		input.UserID,
+		input.Name,
+		input.CredentialID,
+		input.PublicKey,
+		input.AAGUID,
+		input.SignCount,
+	).Scan(&newID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return m.GetOneByCredentialID(ctx, input.CredentialID)
+}
+
+func (m *WebauthnRepositoryImpl) UpdateSignCount(ctx context.Context, id int, signCount uint32) (err error) {
+	stmt := `update webauthn_credentials set sign_count = $1, last_used_at = now() where id = $2`
+
+	_, err = m.DB.ExecContext(ctx, stmt, signCount, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
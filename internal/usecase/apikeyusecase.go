@@ -0,0 +1,133 @@
+package usecase
+
+import (
+	"auth/internal/domain"
+	"auth/internal/helper"
+	"auth/internal/repository"
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// apiKeyPrefixLength is how much of a key is kept in plain text so users can
+// tell their keys apart
+const apiKeyPrefixLength = len(domain.ApiKeyPrefix) + 6
+
+// ApiKeyUseCase represent the API key's usecase contract
+type ApiKeyUseCase interface {
+	Create(ctx context.Context, user *domain.User, input *domain.CreateApiKeyValidation) (key string, apiKey *domain.ApiKey, err error)
+	List(ctx context.Context, user *domain.User) ([]*domain.ApiKey, error)
+	Revoke(ctx context.Context, user *domain.User, id int) error
+	Authenticate(ctx context.Context, key string, ip string) (*domain.User, *domain.ApiKey, error)
+}
+
+type ApiKeyUseCaseImpl struct {
+	ApiKeyRepo repository.ApiKeyRepository
+	UserRepo   repository.UserRepository
+}
+
+func NewApiKeyUseCase(ApiKeyRepo repository.ApiKeyRepository, UserRepo repository.UserRepository) ApiKeyUseCase {
+	return &ApiKeyUseCaseImpl{
+		ApiKeyRepo: ApiKeyRepo,
+		UserRepo:   UserRepo,
+	}
+}
+
+// Create issues a new key. The key itself is only returned here, afterwards
+// just its hash is known.
+func (uc *ApiKeyUseCaseImpl) Create(ctx context.Context, user *domain.User, input *domain.CreateApiKeyValidation) (key string, apiKey *domain.ApiKey, err error) {
+	scopes := []string{}
+	for _, s := range input.Scopes {
+		for _, scope := range strings.Fields(s) {
+			if !slices.Contains(domain.ApiKeyScopes, scope) {
+				return "", nil, errors.New("scope " + scope + " tidak dikenal")
+			}
+
+			if !slices.Contains(scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+
+	if len(scopes) == 0 {
+		scopes = append(scopes, domain.ApiKeyScopes...)
+	}
+
+	random, err := helper.RandomToken(helper.DefaultTokenLength)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	key = domain.ApiKeyPrefix + random
+
+	apiKey = &domain.ApiKey{
+		UserID:  user.ID,
+		Name:    input.Name,
+		Prefix:  key[:apiKeyPrefixLength],
+		KeyHash: helper.HashToken(key),
+		Scopes:  scopes,
+	}
+
+	if input.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, input.ExpiresInDays)
+		apiKey.ExpiresAt = &expiresAt
+	}
+
+	apiKey, err = uc.ApiKeyRepo.Insert(ctx, apiKey)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	return key, apiKey, nil
+}
+
+func (uc *ApiKeyUseCaseImpl) List(ctx context.Context, user *domain.User) ([]*domain.ApiKey, error) {
+	return uc.ApiKeyRepo.GetByUserID(ctx, user.ID)
+}
+
+func (uc *ApiKeyUseCaseImpl) Revoke(ctx context.Context, user *domain.User, id int) error {
+	deleted, err := uc.ApiKeyRepo.Delete(ctx, id, user.ID)
+
+	if err != nil {
+		return err
+	}
+
+	if !deleted {
+		return errors.New("api key tidak ditemukan")
+	}
+
+	return nil
+}
+
+// Authenticate resolves a key to its owner and records the use
+func (uc *ApiKeyUseCaseImpl) Authenticate(ctx context.Context, key string, ip string) (*domain.User, *domain.ApiKey, error) {
+	if !strings.HasPrefix(key, domain.ApiKeyPrefix) {
+		return nil, nil, errors.New("api key tidak valid")
+	}
+
+	apiKey, err := uc.ApiKeyRepo.GetOneByHash(ctx, helper.HashToken(key))
+
+	if err != nil {
+		return nil, nil, errors.New("api key tidak valid")
+	}
+
+	if apiKey.ExpiresAt != nil && apiKey.ExpiresAt.Before(time.Now()) {
+		return nil, nil, errors.New("api key sudah kadaluarsa")
+	}
+
+	user, err := uc.UserRepo.GetOneByID(ctx, apiKey.UserID)
+
+	if err != nil {
+		return nil, nil, errors.New("api key tidak valid")
+	}
+
+	// Losing a timestamp is not worth failing the request
+	uc.ApiKeyRepo.MarkUsed(ctx, apiKey.ID, ip)
+
+	return user, apiKey, nil
+}
@@ -0,0 +1,71 @@
+package usecase
+
+import (
+	"auth/internal/domain"
+	"auth/internal/repository"
+	"context"
+	"strings"
+	"testing"
+)
+
+// insertOnlyApiKeyRepository keeps what Create inserts
+type insertOnlyApiKeyRepository struct {
+	repository.ApiKeyRepository
+	inserted *domain.ApiKey
+}
+
+func (r *insertOnlyApiKeyRepository) Insert(ctx context.Context, input *domain.ApiKey) (*domain.ApiKey, error) {
+	r.inserted = input
+	return input, nil
+}
+
+func TestApiKeyCreateRefusesUnknownScopes(t *testing.T) {
+	repo := &insertOnlyApiKeyRepository{}
+	uc := NewApiKeyUseCase(repo, nil)
+
+	_, _, err := uc.Create(context.Background(), &domain.User{ID: 1}, &domain.CreateApiKeyValidation{
+		Name:   "deploy",
+		Scopes: []string{"profile admin"},
+	})
+
+	if err == nil || repo.inserted != nil {
+		t.Fatal("expected an unknown scope to be refused")
+	}
+}
+
+func TestApiKeyCreateKeepsRequestedScopes(t *testing.T) {
+	repo := &insertOnlyApiKeyRepository{}
+	uc := NewApiKeyUseCase(repo, nil)
+
+	key, apiKey, err := uc.Create(context.Background(), &domain.User{ID: 1}, &domain.CreateApiKeyValidation{
+		Name:   "read only",
+		Scopes: []string{"openid profile", "openid"},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(key, domain.ApiKeyPrefix) || apiKey.KeyHash == key {
+		t.Errorf("unexpected key %s", key)
+	}
+
+	if strings.Join(apiKey.Scopes, " ") != "openid profile" {
+		t.Errorf("expected scopes openid profile, got %v", apiKey.Scopes)
+	}
+}
+
+func TestApiKeyCreateDefaultsToEveryScope(t *testing.T) {
+	repo := &insertOnlyApiKeyRepository{}
+	uc := NewApiKeyUseCase(repo, nil)
+
+	_, apiKey, err := uc.Create(context.Background(), &domain.User{ID: 1}, &domain.CreateApiKeyValidation{Name: "all"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(apiKey.Scopes, " ") != strings.Join(domain.ApiKeyScopes, " ") {
+		t.Errorf("expected every scope, got %v", apiKey.Scopes)
+	}
+}
@@ -0,0 +1,41 @@
+package usecase
+
+import (
+	"auth/internal/domain"
+	"auth/internal/repository"
+	"auth/internal/utils"
+	"context"
+	"os"
+)
+
+// AttributeClaimsEnricher turns the attributes of a user into claims
+type AttributeClaimsEnricher struct {
+	UserAttributeRepo repository.UserAttributeRepository
+}
+
+func NewAttributeClaimsEnricher(UserAttributeRepo repository.UserAttributeRepository) *AttributeClaimsEnricher {
+	return &AttributeClaimsEnricher{
+		UserAttributeRepo: UserAttributeRepo,
+	}
+}
+
+// Enabled reports whether attributes become claims, set
+// JWT_ATTRIBUTE_CLAIMS=true to use it
+func (e *AttributeClaimsEnricher) Enabled() bool {
+	return os.Getenv("JWT_ATTRIBUTE_CLAIMS") == "true"
+}
+
+func (e *AttributeClaimsEnricher) Enrich(ctx context.Context, user *domain.User, login *utils.LoginContext) (map[string]interface{}, error) {
+	attributes, err := e.UserAttributeRepo.GetByUserID(ctx, user.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	claims := map[string]interface{}{}
+	for _, attribute := range attributes {
+		claims[attribute.Name] = attribute.Value
+	}
+
+	return claims, nil
+}
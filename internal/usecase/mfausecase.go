@@ -0,0 +1,350 @@
+package usecase
+
+import (
+	"auth/internal/domain"
+	"auth/internal/helper"
+	"auth/internal/repository"
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// recoveryCodeCount is how many recovery codes a user gets on
+	// confirmation
+	recoveryCodeCount = 10
+
+	// Used when MFA_MAX_ATTEMPTS / MFA_LOCKOUT_MINUTE are not set
+	defaultMfaMaxAttempts   = 5
+	defaultMfaLockoutMinute = 15
+
+	// Used when MFA_CHALLENGE_EXPIRE_MINUTE is not set
+	defaultMfaChallengeExpireMinute = 5
+)
+
+// MfaUseCase represent the second factor's usecase contract
+type MfaUseCase interface {
+	EnrollTOTP(ctx context.Context, user *domain.User) (secret string, uri string, err error)
+	ConfirmTOTP(ctx context.Context, user *domain.User, code string) (recoveryCodes []string, err error)
+	DisableTOTP(ctx context.Context, user *domain.User, disable *domain.DisableTOTPValidation) error
+	LoginMfa(ctx context.Context, login *domain.LoginMfaValidation) (user *domain.User, uuid string, err error)
+}
+
+type MfaUseCaseImpl struct {
+	MfaRepo   repository.MfaRepository
+	UserRepo  repository.UserRepository
+	Directory domain.Directory
+}
+
+// Directory may be nil when only local passwords are used.
+func NewMfaUseCase(MfaRepo repository.MfaRepository, UserRepo repository.UserRepository, Directory domain.Directory) MfaUseCase {
+	return &MfaUseCaseImpl{
+		MfaRepo:   MfaRepo,
+		UserRepo:  UserRepo,
+		Directory: Directory,
+	}
+}
+
+func (uc *MfaUseCaseImpl) EnrollTOTP(ctx context.Context, user *domain.User) (secret string, uri string, err error) {
+	secret, err = helper.GenerateTOTPSecret()
+
+	if err != nil {
+		return "", "", err
+	}
+
+	sealed, err := sealTOTPSecret(user.ID, secret)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	err = uc.MfaRepo.SaveTOTP(ctx, user.ID, sealed)
+
+	if err != nil {
+		return "", "", errors.New("MFA sudah aktif")
+	}
+
+	issuer := os.Getenv("MFA_ISSUER")
+	if issuer == "" {
+		issuer = os.Getenv("APPLICATION_NAME")
+	}
+
+	return secret, helper.TOTPURI(issuer, user.Username, secret), nil
+}
+
+func (uc *MfaUseCaseImpl) ConfirmTOTP(ctx context.Context, user *domain.User, code string) (recoveryCodes []string, err error) {
+	totp, err := uc.MfaRepo.GetTOTP(ctx, user.ID)
+
+	if err != nil {
+		return nil, errors.New("MFA belum didaftarkan")
+	}
+
+	if totp.ConfirmedAt != nil {
+		return nil, errors.New("MFA sudah aktif")
+	}
+
+	err = uc.verifyCode(ctx, totp, code, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Recovery codes are only ever shown here, we keep their hashes
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		recoveryCode, err := helper.RecoveryCode()
+
+		if err != nil {
+			return nil, err
+		}
+
+		recoveryCodes = append(recoveryCodes, recoveryCode)
+		hashes = append(hashes, helper.HashToken(recoveryCode))
+	}
+
+	err = uc.MfaRepo.ReplaceRecoveryCodes(ctx, user.ID, hashes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = uc.MfaRepo.ConfirmTOTP(ctx, user.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return recoveryCodes, nil
+}
+
+func (uc *MfaUseCaseImpl) DisableTOTP(ctx context.Context, user *domain.User, disable *domain.DisableTOTPValidation) error {
+	current, err := uc.UserRepo.GetOneByID(ctx, user.ID)
+
+	if err != nil {
+		return err
+	}
+
+	err = uc.checkPassword(ctx, current, disable.Password)
+
+	if err != nil {
+		return err
+	}
+
+	totp, err := uc.MfaRepo.GetTOTP(ctx, user.ID)
+
+	if err != nil || totp.ConfirmedAt == nil {
+		return errors.New("MFA belum aktif")
+	}
+
+	err = uc.verifyCode(ctx, totp, disable.Code, true)
+
+	if err != nil {
+		return err
+	}
+
+	return uc.MfaRepo.DeleteTOTP(ctx, user.ID)
+}
+
+func (uc *MfaUseCaseImpl) LoginMfa(ctx context.Context, login *domain.LoginMfaValidation) (user *domain.User, uuidGen string, err error) {
+	userID, err := uc.MfaRepo.GetChallenge(ctx, login.MfaToken)
+
+	if err != nil {
+		return nil, "", errors.New("sesi MFA tidak valid, silakan login ulang")
+	}
+
+	totp, err := uc.MfaRepo.GetTOTP(ctx, userID)
+
+	if err != nil || totp.ConfirmedAt == nil {
+		return nil, "", errors.New("sesi MFA tidak valid, silakan login ulang")
+	}
+
+	err = uc.verifyCode(ctx, totp, login.Code, true)
+
+	if err != nil {
+		// Too many failures burn the challenge, the password has to be
+		// entered again
+		if errors.Is(err, errTooManyAttempts) {
+			uc.MfaRepo.DeleteChallenge(ctx, login.MfaToken)
+		}
+		return nil, "", err
+	}
+
+	uc.MfaRepo.DeleteChallenge(ctx, login.MfaToken)
+
+	user, err = uc.UserRepo.GetOneByID(ctx, userID)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	uuidGenerate := uuid.NewString()
+
+	rememberSession := uc.UserRepo.RememberUUID(ctx, user, uuidGenerate)
+
+	if rememberSession != nil {
+		return nil, "", rememberSession
+	}
+
+	return user, uuidGenerate, nil
+}
+
+// checkPassword confirms the password of a user where it is kept. Users who
+// only sign in through an upstream provider have none, the second factor
+// they are asked for as well is what proves it is them.
+func (uc *MfaUseCaseImpl) checkPassword(ctx context.Context, user *domain.User, password string) error {
+	if user.AuthSource == domain.AuthSourceLDAP {
+		if uc.Directory == nil {
+			return errors.New("direktori tidak tersedia")
+		}
+
+		_, err := uc.Directory.Authenticate(ctx, user.Username, password)
+
+		if errors.Is(err, domain.ErrDirectoryUserNotFound) || errors.Is(err, domain.ErrDirectoryInvalidCredentials) {
+			return errors.New("password salah")
+		}
+
+		return err
+	}
+
+	if !hasUsablePassword(user) {
+		return nil
+	}
+
+	passwordCheck, _ := helper.ComparePasswordAndHash(password, user.Password)
+
+	if !passwordCheck {
+		return errors.New("password salah")
+	}
+
+	return nil
+}
+
+var errTooManyAttempts = errors.New("terlalu banyak percobaan kode MFA, coba lagi nanti")
+
+// verifyCode checks a TOTP code, or a recovery code when allowed, while
+// counting failures per user so codes cannot be brute-forced.
+func (uc *MfaUseCaseImpl) verifyCode(ctx context.Context, totp *domain.TOTP, code string, allowRecovery bool) error {
+	maxAttempts, _ := strconv.ParseInt(os.Getenv("MFA_MAX_ATTEMPTS"), 10, 64)
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMfaMaxAttempts
+	}
+	lockoutMinute, _ := strconv.Atoi(os.Getenv("MFA_LOCKOUT_MINUTE"))
+	if lockoutMinute <= 0 {
+		lockoutMinute = defaultMfaLockoutMinute
+	}
+	attemptKey := "user:" + strconv.Itoa(totp.UserID)
+
+	// Every attempt is counted before the code is looked at, so guesses sent
+	// in parallel cannot all slip in under the limit
+	attempts, err := uc.MfaRepo.CountAttempt(ctx, attemptKey, time.Minute*time.Duration(lockoutMinute))
+
+	if err != nil {
+		return err
+	}
+
+	if attempts > maxAttempts {
+		return errTooManyAttempts
+	}
+
+	secret, err := openTOTPSecret(totp.UserID, totp.Secret)
+
+	if err != nil {
+		return err
+	}
+
+	code = strings.ToLower(strings.TrimSpace(code))
+	valid := false
+
+	if len(code) == helper.TOTPDigits {
+		match, step, err := helper.ValidateTOTP(secret, code, time.Now(), 1)
+
+		if err != nil {
+			return err
+		}
+
+		if match {
+			valid, err = uc.MfaRepo.MarkTOTPUsed(ctx, totp.UserID, step)
+
+			if err != nil {
+				return err
+			}
+		}
+	} else if allowRecovery {
+		valid, err = uc.MfaRepo.UseRecoveryCode(ctx, totp.UserID, helper.HashToken(code))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if !valid {
+		if attempts >= maxAttempts {
+			return errTooManyAttempts
+		}
+
+		return errors.New("kode MFA salah")
+	}
+
+	uc.MfaRepo.ClearAttempts(ctx, attemptKey)
+
+	return nil
+}
+
+// sealTOTPSecret encrypts a TOTP secret with MFA_ENCRYPTION_KEY, bound to
+// its user so it cannot be copied to another account
+func sealTOTPSecret(userID int, secret string) (string, error) {
+	key, err := mfaEncryptionKey()
+
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := helper.Encrypt(key, []byte(secret), []byte("totp:"+strconv.Itoa(userID)))
+
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openTOTPSecret decrypts what sealTOTPSecret stored
+func openTOTPSecret(userID int, sealed string) (string, error) {
+	key, err := mfaEncryptionKey()
+
+	if err != nil {
+		return "", err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(sealed)
+
+	if err != nil {
+		return "", errors.New("TOTP secret cannot be decrypted")
+	}
+
+	secret, err := helper.Decrypt(key, data, []byte("totp:"+strconv.Itoa(userID)))
+
+	if err != nil {
+		return "", errors.New("TOTP secret cannot be decrypted")
+	}
+
+	return string(secret), nil
+}
+
+// mfaEncryptionKey is the base64 encoded AES-256 key TOTP secrets are
+// encrypted with
+func mfaEncryptionKey() ([]byte, error) {
+	key, err := helper.ParseEncryptionKey(os.Getenv("MFA_ENCRYPTION_KEY"))
+
+	if err != nil {
+		return nil, errors.New("MFA_ENCRYPTION_KEY must be 32 base64 encoded bytes")
+	}
+
+	return key, nil
+}
@@ -0,0 +1,101 @@
+package usecase
+
+import (
+	"auth/internal/domain"
+	"auth/internal/helper"
+	"auth/internal/repository"
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+// totpMfaRepository holds one confirmed TOTP secret
+type totpMfaRepository struct {
+	repository.MfaRepository
+	totp    *domain.TOTP
+	deleted bool
+}
+
+func (r *totpMfaRepository) GetTOTP(ctx context.Context, userID int) (*domain.TOTP, error) {
+	return r.totp, nil
+}
+
+func (r *totpMfaRepository) CountAttempt(ctx context.Context, key string, expire time.Duration) (int64, error) {
+	return 1, nil
+}
+
+func (r *totpMfaRepository) MarkTOTPUsed(ctx context.Context, userID int, step int64) (bool, error) {
+	return true, nil
+}
+
+func (r *totpMfaRepository) ClearAttempts(ctx context.Context, key string) {}
+
+func (r *totpMfaRepository) DeleteTOTP(ctx context.Context, userID int) error {
+	r.deleted = true
+	return nil
+}
+
+// oneUserRepository finds a single user by id
+type oneUserRepository struct {
+	repository.UserRepository
+	user *domain.User
+}
+
+func (r *oneUserRepository) GetOneByID(ctx context.Context, id int) (*domain.User, error) {
+	return r.user, nil
+}
+
+// passwordDirectory knows one password for every username
+type passwordDirectory struct {
+	password string
+}
+
+func (d passwordDirectory) Authenticate(ctx context.Context, username string, password string) (*domain.DirectoryEntry, error) {
+	if password != d.password {
+		return nil, domain.ErrDirectoryInvalidCredentials
+	}
+	return &domain.DirectoryEntry{Username: username}, nil
+}
+
+func TestDisableTOTPChecksThePasswordWhereItIsKept(t *testing.T) {
+	t.Setenv("MFA_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString(make([]byte, helper.EncryptionKeyLength)))
+
+	localHash, _ := helper.CreateHash("l0cal-password", helper.DefaultParams)
+	unusableHash, _ := unusablePasswordHash()
+
+	tests := []struct {
+		name     string
+		user     *domain.User
+		password string
+		ok       bool
+	}{
+		{"local", &domain.User{ID: 1, Password: localHash}, "l0cal-password", true},
+		{"local wrong password", &domain.User{ID: 1, Password: localHash}, "wrong", false},
+		{"local without password", &domain.User{ID: 1, Password: localHash}, "", false},
+		{"directory", &domain.User{ID: 1, Username: "jane", AuthSource: domain.AuthSourceLDAP, Password: unusableHash}, "d1rectory", true},
+		{"directory wrong password", &domain.User{ID: 1, Username: "jane", AuthSource: domain.AuthSourceLDAP, Password: unusableHash}, "wrong", false},
+		{"upstream", &domain.User{ID: 1, Password: unusableHash}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret, _ := helper.GenerateTOTPSecret()
+			sealed, err := sealTOTPSecret(1, secret)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			confirmed := time.Now()
+			mfaRepo := &totpMfaRepository{totp: &domain.TOTP{UserID: 1, Secret: sealed, ConfirmedAt: &confirmed}}
+			uc := NewMfaUseCase(mfaRepo, &oneUserRepository{user: tt.user}, passwordDirectory{password: "d1rectory"})
+
+			code, _ := helper.TOTPCode(secret, time.Now())
+			err = uc.DisableTOTP(context.Background(), &domain.User{ID: 1}, &domain.DisableTOTPValidation{Password: tt.password, Code: code})
+
+			if tt.ok != (err == nil) || tt.ok != mfaRepo.deleted {
+				t.Errorf("expected ok %t, got %v", tt.ok, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,565 @@
+package usecase
+
+import (
+	"auth/internal/domain"
+	"auth/internal/helper"
+	"auth/internal/repository"
+	"auth/internal/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/exp/slices"
+)
+
+// authorizationCodeExpire is kept short, codes are exchanged right away
+const authorizationCodeExpire = time.Minute
+
+const (
+	// Used when DEVICE_CODE_EXPIRE_MINUTE / DEVICE_CODE_INTERVAL_SECOND are
+	// not set
+	defaultDeviceCodeExpireMinute   = 10
+	defaultDeviceCodeIntervalSecond = 5
+)
+
+// deviceSlowDownSecond is added to the interval of a device each time it
+// polls too fast, RFC 8628 section 3.5
+const deviceSlowDownSecond = 5
+
+// OAuthUseCase represent the OAuth authorization server's usecase contract
+type OAuthUseCase interface {
+	GetClientForRedirect(ctx context.Context, clientID string, redirectURI string) (*domain.OAuthClient, error)
+	ValidateAuthorize(client *domain.OAuthClient, authorize *domain.AuthorizeValidation) (scope string, err error)
+	Authorize(ctx context.Context, client *domain.OAuthClient, authorize *domain.AuthorizeValidation, scope string, user *domain.User, authTime time.Time) (code string, err error)
+	HasConsent(ctx context.Context, client *domain.OAuthClient, user *domain.User, scope string) (bool, error)
+	GrantConsent(ctx context.Context, client *domain.OAuthClient, user *domain.User, scope string) error
+	AuthenticateClient(ctx context.Context, clientID string, clientSecret string) (*domain.OAuthClient, error)
+	ExchangeCode(ctx context.Context, client *domain.OAuthClient, token *domain.TokenValidation) (user *domain.User, uuid string, authorization *domain.AuthorizationCode, err error)
+	UserInfo(user *domain.User, scope string) *domain.UserInfo
+	OpenIDConfiguration() *domain.OpenIDConfiguration
+	Introspect(ctx context.Context, token string) *domain.Introspection
+	StartDeviceAuthorization(ctx context.Context, client *domain.OAuthClient, scope string) (*domain.DeviceCodeResponse, error)
+	GetDeviceAuthorization(ctx context.Context, userCode string) (*domain.DeviceAuthorization, *domain.OAuthClient, error)
+	VerifyDevice(ctx context.Context, userCode string, user *domain.User, approve bool) error
+	ExchangeDeviceCode(ctx context.Context, client *domain.OAuthClient, deviceCode string) (user *domain.User, uuid string, device *domain.DeviceAuthorization, err error)
+	CreateClient(ctx context.Context, input *domain.CreateOAuthClientValidation) (secret string, client *domain.OAuthClient, err error)
+}
+
+type OAuthUseCaseImpl struct {
+	OAuthRepo repository.OAuthRepository
+	UserRepo  repository.UserRepository
+}
+
+func NewOAuthUseCase(OAuthRepo repository.OAuthRepository, UserRepo repository.UserRepository) OAuthUseCase {
+	return &OAuthUseCaseImpl{
+		OAuthRepo: OAuthRepo,
+		UserRepo:  UserRepo,
+	}
+}
+
+// GetClientForRedirect looks up a client and checks the redirect URI is one it
+// registered. Until both are known good, errors must not be redirected.
+func (uc *OAuthUseCaseImpl) GetClientForRedirect(ctx context.Context, clientID string, redirectURI string) (*domain.OAuthClient, error) {
+	client, err := uc.OAuthRepo.GetClient(ctx, clientID)
+
+	if err != nil {
+		return nil, &domain.OAuthError{Code: "invalid_request", Description: "client_id tidak dikenal"}
+	}
+
+	// Exact match only, no prefix or wildcard matching
+	if !slices.Contains(client.RedirectURIs, redirectURI) {
+		return nil, &domain.OAuthError{Code: "invalid_request", Description: "redirect_uri tidak terdaftar"}
+	}
+
+	return client, nil
+}
+
+func (uc *OAuthUseCaseImpl) ValidateAuthorize(client *domain.OAuthClient, authorize *domain.AuthorizeValidation) (scope string, err error) {
+	if authorize.ResponseType != "code" {
+		return "", &domain.OAuthError{Code: "unsupported_response_type", Description: "hanya response_type=code yang didukung"}
+	}
+
+	if !slices.Contains(client.GrantTypes, "authorization_code") {
+		return "", &domain.OAuthError{Code: "unauthorized_client", Description: "client tidak boleh memakai authorization_code"}
+	}
+
+	// PKCE is mandatory for every client, confidential ones included
+	if authorize.CodeChallenge == "" || authorize.CodeChallengeMethod != "S256" {
+		return "", &domain.OAuthError{Code: "invalid_request", Description: "code_challenge dengan code_challenge_method=S256 wajib diisi"}
+	}
+
+	return checkScope(client.AllowedScopes, authorize.Scope)
+}
+
+func (uc *OAuthUseCaseImpl) Authorize(ctx context.Context, client *domain.OAuthClient, authorize *domain.AuthorizeValidation, scope string, user *domain.User, authTime time.Time) (code string, err error) {
+	code, err = helper.RandomToken(helper.DefaultTokenLength)
+
+	if err != nil {
+		return "", err
+	}
+
+	authorization := &domain.AuthorizationCode{
+		ClientID:      client.ClientID,
+		RedirectURI:   authorize.RedirectURI,
+		Scope:         scope,
+		UserID:        user.ID,
+		CodeChallenge: authorize.CodeChallenge,
+		Nonce:         authorize.Nonce,
+	}
+
+	if !authTime.IsZero() {
+		authorization.AuthTime = authTime.Unix()
+	}
+
+	err = uc.OAuthRepo.RememberAuthorizationCode(ctx, code, authorization, authorizationCodeExpire)
+
+	if err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// HasConsent reports whether the user already granted the client every scope
+// it asks for
+func (uc *OAuthUseCaseImpl) HasConsent(ctx context.Context, client *domain.OAuthClient, user *domain.User, scope string) (bool, error) {
+	granted, err := uc.OAuthRepo.GetConsent(ctx, user.ID, client.ClientID)
+
+	if err != nil {
+		return false, err
+	}
+
+	for _, requested := range strings.Fields(scope) {
+		if !slices.Contains(granted, requested) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// GrantConsent remembers that the user allowed the client the scopes, so
+// they are not asked again
+func (uc *OAuthUseCaseImpl) GrantConsent(ctx context.Context, client *domain.OAuthClient, user *domain.User, scope string) error {
+	return uc.OAuthRepo.SaveConsent(ctx, user.ID, client.ClientID, strings.Fields(scope))
+}
+
+func (uc *OAuthUseCaseImpl) AuthenticateClient(ctx context.Context, clientID string, clientSecret string) (*domain.OAuthClient, error) {
+	client, err := uc.OAuthRepo.GetClient(ctx, clientID)
+
+	if err != nil {
+		return nil, &domain.OAuthError{Code: "invalid_client", Description: "autentikasi client gagal"}
+	}
+
+	// Public clients have no secret, PKCE protects their codes instead
+	if client.SecretHash != "" {
+		secretCheck, _ := helper.ComparePasswordAndHash(clientSecret, client.SecretHash)
+
+		if !secretCheck {
+			return nil, &domain.OAuthError{Code: "invalid_client", Description: "autentikasi client gagal"}
+		}
+	}
+
+	return client, nil
+}
+
+func (uc *OAuthUseCaseImpl) ExchangeCode(ctx context.Context, client *domain.OAuthClient, token *domain.TokenValidation) (user *domain.User, uuidGen string, authorization *domain.AuthorizationCode, err error) {
+	invalidGrant := &domain.OAuthError{Code: "invalid_grant", Description: "authorization code tidak valid"}
+
+	authorization, err = uc.OAuthRepo.ConsumeAuthorizationCode(ctx, token.Code)
+
+	if err != nil {
+		return nil, "", nil, invalidGrant
+	}
+
+	if authorization.ClientID != client.ClientID || authorization.RedirectURI != token.RedirectURI {
+		return nil, "", nil, invalidGrant
+	}
+
+	if !helper.VerifyPKCE(token.CodeVerifier, authorization.CodeChallenge) {
+		return nil, "", nil, invalidGrant
+	}
+
+	user, err = uc.UserRepo.GetOneByID(ctx, authorization.UserID)
+
+	if err != nil {
+		return nil, "", nil, invalidGrant
+	}
+
+	uuidGenerate := uuid.NewString()
+
+	rememberSession := uc.UserRepo.RememberUUID(ctx, user, uuidGenerate)
+
+	if rememberSession != nil {
+		return nil, "", nil, rememberSession
+	}
+
+	return user, uuidGenerate, authorization, nil
+}
+
+// UserInfo returns the claims of a user the scope gives access to. The
+// subject is always included.
+func (uc *OAuthUseCaseImpl) UserInfo(user *domain.User, scope string) *domain.UserInfo {
+	scopes := strings.Fields(scope)
+	info := &domain.UserInfo{
+		Subject: strconv.Itoa(user.ID),
+	}
+
+	if slices.Contains(scopes, "profile") {
+		info.Name = user.Name
+		info.PreferredUsername = user.Username
+	}
+
+	if slices.Contains(scopes, "email") {
+		emailVerified := user.EmailVerifiedAt != nil
+		info.Email = user.Email
+		info.EmailVerified = &emailVerified
+	}
+
+	return info
+}
+
+func (uc *OAuthUseCaseImpl) OpenIDConfiguration() *domain.OpenIDConfiguration {
+	issuer := strings.TrimRight(os.Getenv("APPLICATION_URL"), "/")
+
+	return &domain.OpenIDConfiguration{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/authorize",
+		TokenEndpoint:                     issuer + "/token",
+		UserinfoEndpoint:                  issuer + "/userinfo",
+		IntrospectionEndpoint:             issuer + "/introspect",
+		RevocationEndpoint:                issuer + "/revoke",
+		DeviceAuthorizationEndpoint:       issuer + "/device/code",
+		JwksURI:                           issuer + "/.well-known/jwks.json",
+		ResponseTypesSupported:            []string{"code"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{utils.SigningAlgorithm()},
+		ScopesSupported:                   []string{"openid", "profile", "email"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token", "client_credentials", domain.GrantTypeDeviceCode},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported:                   []string{"sub", "name", "preferred_username", "email", "email_verified", "nonce", "auth_time"},
+	}
+}
+
+// checkScope validates a space delimited scope request against what is
+// allowed. An empty request is granted everything allowed.
+func checkScope(allowed []string, requested string) (scope string, err error) {
+	if strings.TrimSpace(requested) == "" {
+		return strings.Join(allowed, " "), nil
+	}
+
+	scopes := strings.Fields(requested)
+	for _, s := range scopes {
+		if !slices.Contains(allowed, s) {
+			return "", &domain.OAuthError{Code: "invalid_scope", Description: "scope " + s + " tidak diizinkan"}
+		}
+	}
+
+	return strings.Join(scopes, " "), nil
+}
+
+// Introspect reports whether a token is still alive. User tokens are only
+// active while their Redis session exists, service tokens until they expire.
+func (uc *OAuthUseCaseImpl) Introspect(ctx context.Context, token string) *domain.Introspection {
+	inactive := &domain.Introspection{Active: false}
+
+	if claims, err := utils.ParseToken(token); err == nil && claims.Uuid != "" {
+		res, err := uc.UserRepo.GetUUID(ctx, claims.Uuid)
+
+		if err != nil {
+			return inactive
+		}
+
+		user := &domain.User{}
+		json.Unmarshal([]byte(res), user)
+
+		introspection := &domain.Introspection{
+			Active:    true,
+			Scope:     claims.Scope,
+			ClientID:  claims.ClientID,
+			Username:  user.Username,
+			TokenType: "Bearer",
+			Sub:       strconv.Itoa(user.ID),
+			Aud:       claims.Audience,
+			Iss:       claims.Issuer,
+			User:      user,
+		}
+
+		if claims.ExpiresAt != nil {
+			introspection.Exp = claims.ExpiresAt.Unix()
+		}
+		if claims.IssuedAt != nil {
+			introspection.Iat = claims.IssuedAt.Unix()
+		}
+
+		return introspection
+	}
+
+	if claims, err := utils.ParseServiceToken(token); err == nil {
+		introspection := &domain.Introspection{
+			Active:    true,
+			Scope:     claims.Scope,
+			ClientID:  claims.ClientID,
+			TokenType: "Bearer",
+			Sub:       claims.Subject,
+			Aud:       claims.Audience,
+			Iss:       claims.Issuer,
+			Jti:       claims.ID,
+		}
+
+		if claims.ExpiresAt != nil {
+			introspection.Exp = claims.ExpiresAt.Unix()
+		}
+		if claims.IssuedAt != nil {
+			introspection.Iat = claims.IssuedAt.Unix()
+		}
+
+		return introspection
+	}
+
+	return inactive
+}
+
+// StartDeviceAuthorization begins a device flow of RFC 8628. The device polls
+// with the device code while the user approves the user code elsewhere.
+func (uc *OAuthUseCaseImpl) StartDeviceAuthorization(ctx context.Context, client *domain.OAuthClient, scope string) (*domain.DeviceCodeResponse, error) {
+	if !slices.Contains(client.GrantTypes, domain.GrantTypeDeviceCode) {
+		return nil, &domain.OAuthError{Code: "unauthorized_client", Description: "client tidak boleh memakai device code"}
+	}
+
+	scope, err := checkScope(client.AllowedScopes, scope)
+
+	if err != nil {
+		return nil, err
+	}
+
+	deviceCode, err := helper.RandomToken(helper.DefaultTokenLength)
+
+	if err != nil {
+		return nil, err
+	}
+
+	userCode, err := helper.UserCode()
+
+	if err != nil {
+		return nil, err
+	}
+
+	expireMinute, _ := strconv.Atoi(os.Getenv("DEVICE_CODE_EXPIRE_MINUTE"))
+	if expireMinute <= 0 {
+		expireMinute = defaultDeviceCodeExpireMinute
+	}
+	interval, _ := strconv.Atoi(os.Getenv("DEVICE_CODE_INTERVAL_SECOND"))
+	if interval <= 0 {
+		interval = defaultDeviceCodeIntervalSecond
+	}
+	expire := time.Minute * time.Duration(expireMinute)
+
+	err = uc.OAuthRepo.RememberDeviceAuthorization(ctx, &domain.DeviceAuthorization{
+		ID:       helper.HashToken(deviceCode),
+		ClientID: client.ClientID,
+		Scope:    scope,
+		UserCode: userCode,
+		Status:   domain.DeviceStatusPending,
+		Interval: interval,
+	}, expire)
+
+	if err != nil {
+		return nil, err
+	}
+
+	verificationURI := strings.TrimRight(os.Getenv("APPLICATION_URL"), "/") + "/device"
+
+	return &domain.DeviceCodeResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?user_code=" + userCode,
+		ExpiresIn:               int64(expire.Seconds()),
+		Interval:                interval,
+	}, nil
+}
+
+// GetDeviceAuthorization looks up a pending device flow by its user code, so
+// the user can be shown which client is asking before approving
+func (uc *OAuthUseCaseImpl) GetDeviceAuthorization(ctx context.Context, userCode string) (*domain.DeviceAuthorization, *domain.OAuthClient, error) {
+	device, err := uc.OAuthRepo.GetDeviceAuthorizationByUserCode(ctx, helper.NormalizeUserCode(userCode))
+
+	if err != nil || device.Status != domain.DeviceStatusPending {
+		return nil, nil, errors.New("kode tidak valid atau sudah kadaluarsa")
+	}
+
+	client, err := uc.OAuthRepo.GetClient(ctx, device.ClientID)
+
+	if err != nil {
+		return nil, nil, errors.New("kode tidak valid atau sudah kadaluarsa")
+	}
+
+	return device, client, nil
+}
+
+func (uc *OAuthUseCaseImpl) VerifyDevice(ctx context.Context, userCode string, user *domain.User, approve bool) error {
+	device, _, err := uc.GetDeviceAuthorization(ctx, userCode)
+
+	if err != nil {
+		return err
+	}
+
+	device.Status = domain.DeviceStatusDenied
+	if approve {
+		device.Status = domain.DeviceStatusApproved
+		device.UserID = user.ID
+	}
+
+	return uc.OAuthRepo.UpdateDeviceAuthorization(ctx, device)
+}
+
+// ExchangeDeviceCode answers a device polling the token endpoint. Until the
+// user decided it gets authorization_pending, and slow_down when it polls
+// faster than the interval it was given. Every slow_down raises the interval
+// for the polls that follow.
+func (uc *OAuthUseCaseImpl) ExchangeDeviceCode(ctx context.Context, client *domain.OAuthClient, deviceCode string) (user *domain.User, uuidGen string, device *domain.DeviceAuthorization, err error) {
+	// Expired and unknown device codes cannot be told apart
+	expired := &domain.OAuthError{Code: "expired_token", Description: "device code tidak valid atau sudah kadaluarsa"}
+
+	device, err = uc.OAuthRepo.GetDeviceAuthorization(ctx, deviceCode)
+
+	if err != nil || device.ClientID != client.ClientID {
+		return nil, "", nil, expired
+	}
+
+	allowed, err := uc.OAuthRepo.ThrottleDevicePoll(ctx, deviceCode, time.Second*time.Duration(device.Interval))
+
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	if !allowed {
+		_, err = uc.OAuthRepo.SlowDownDevicePoll(ctx, deviceCode, deviceSlowDownSecond)
+
+		// The flow may have expired since it was read
+		if err != nil {
+			return nil, "", nil, expired
+		}
+
+		return nil, "", nil, &domain.OAuthError{Code: "slow_down"}
+	}
+
+	switch device.Status {
+	case domain.DeviceStatusPending:
+		return nil, "", nil, &domain.OAuthError{Code: "authorization_pending"}
+	case domain.DeviceStatusDenied:
+		uc.OAuthRepo.ConsumeDeviceAuthorization(ctx, deviceCode)
+		return nil, "", nil, &domain.OAuthError{Code: "access_denied", Description: "permintaan ditolak oleh pengguna"}
+	}
+
+	// Only one poll may collect the tokens
+	device, err = uc.OAuthRepo.ConsumeDeviceAuthorization(ctx, deviceCode)
+
+	if err != nil || device.Status != domain.DeviceStatusApproved {
+		return nil, "", nil, expired
+	}
+
+	user, err = uc.UserRepo.GetOneByID(ctx, device.UserID)
+
+	if err != nil {
+		return nil, "", nil, expired
+	}
+
+	uuidGenerate := uuid.NewString()
+
+	rememberSession := uc.UserRepo.RememberUUID(ctx, user, uuidGenerate)
+
+	if rememberSession != nil {
+		return nil, "", nil, rememberSession
+	}
+
+	return user, uuidGenerate, device, nil
+}
+
+// CreateClient registers an OAuth client. Only the hash of its secret is
+// kept, the secret is returned once. Public clients get none.
+func (uc *OAuthUseCaseImpl) CreateClient(ctx context.Context, input *domain.CreateOAuthClientValidation) (secret string, client *domain.OAuthClient, err error) {
+	existing, _ := uc.OAuthRepo.GetClient(ctx, input.ClientID)
+
+	if existing != nil {
+		return "", nil, errors.New("client_id sudah terdaftar")
+	}
+
+	grantTypes := input.GrantTypes
+	if len(grantTypes) == 0 {
+		grantTypes = []string{"authorization_code", "refresh_token"}
+	}
+
+	for _, grantType := range grantTypes {
+		if !slices.Contains(domain.OAuthClientGrantTypes, grantType) {
+			return "", nil, errors.New("grant_type " + grantType + " tidak didukung")
+		}
+	}
+
+	// Codes are sent to the redirect URIs, they must be absolute and exact
+	redirectURIs := []string{}
+	for _, redirectURI := range input.RedirectURIs {
+		parsed, err := url.Parse(redirectURI)
+
+		if err != nil || !parsed.IsAbs() || parsed.Host == "" || parsed.Fragment != "" {
+			return "", nil, errors.New("redirect_uri " + redirectURI + " tidak valid")
+		}
+
+		redirectURIs = append(redirectURIs, redirectURI)
+	}
+
+	if slices.Contains(grantTypes, "authorization_code") && len(redirectURIs) == 0 {
+		return "", nil, errors.New("redirect_uris wajib diisi untuk authorization_code")
+	}
+
+	scopes := []string{}
+	for _, scope := range input.Scopes {
+		if !slices.Contains(domain.OAuthScopes, scope) {
+			return "", nil, errors.New("scope " + scope + " tidak dikenal")
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		scopes = domain.OAuthScopes
+	}
+
+	secretHash := ""
+	if !input.Public {
+		secret, err = helper.RandomToken(helper.DefaultTokenLength)
+
+		if err != nil {
+			return "", nil, err
+		}
+
+		secretHash, err = helper.CreateHash(secret, helper.DefaultParams)
+
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	client, err = uc.OAuthRepo.InsertClient(ctx, &domain.OAuthClient{
+		ClientID:      input.ClientID,
+		Name:          input.Name,
+		SecretHash:    secretHash,
+		RedirectURIs:  redirectURIs,
+		AllowedScopes: scopes,
+		GrantTypes:    grantTypes,
+	})
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	return secret, client, nil
+}
@@ -0,0 +1,395 @@
+package usecase
+
+import (
+	"auth/internal/domain"
+	"auth/internal/helper"
+	"auth/internal/repository"
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// redisOAuthRepository keeps codes and device flows in miniredis, clients and
+// consents in maps
+type redisOAuthRepository struct {
+	*repository.OAuthRepositoryImpl
+	clients  map[string]*domain.OAuthClient
+	consents map[string][]string
+}
+
+func newRedisOAuthRepository(t *testing.T, server *miniredis.Miniredis, clients ...*domain.OAuthClient) *redisOAuthRepository {
+	t.Helper()
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	repo := &redisOAuthRepository{
+		OAuthRepositoryImpl: &repository.OAuthRepositoryImpl{Redis: client},
+		clients:             map[string]*domain.OAuthClient{},
+		consents:            map[string][]string{},
+	}
+	for _, oauthClient := range clients {
+		repo.clients[oauthClient.ClientID] = oauthClient
+	}
+
+	return repo
+}
+
+func (r *redisOAuthRepository) GetClient(ctx context.Context, clientID string) (*domain.OAuthClient, error) {
+	if client, ok := r.clients[clientID]; ok {
+		return client, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (r *redisOAuthRepository) InsertClient(ctx context.Context, input *domain.OAuthClient) (*domain.OAuthClient, error) {
+	input.ID = len(r.clients) + 1
+	r.clients[input.ClientID] = input
+	return input, nil
+}
+
+func (r *redisOAuthRepository) GetConsent(ctx context.Context, userID int, clientID string) ([]string, error) {
+	return r.consents[strconv.Itoa(userID)+":"+clientID], nil
+}
+
+func (r *redisOAuthRepository) SaveConsent(ctx context.Context, userID int, clientID string, scopes []string) error {
+	key := strconv.Itoa(userID) + ":" + clientID
+	r.consents[key] = append(r.consents[key], scopes...)
+	return nil
+}
+
+var testOAuthClient = &domain.OAuthClient{
+	ClientID:      "app",
+	RedirectURIs:  []string{"https://app.example.com/callback"},
+	AllowedScopes: []string{"openid", "profile", "email"},
+	GrantTypes:    []string{"authorization_code", "refresh_token"},
+}
+
+// authorizeCode runs the authorization request of the user and returns the
+// code and the verifier that goes with it
+func authorizeCode(t *testing.T, uc OAuthUseCase, user *domain.User) (code string, verifier string) {
+	t.Helper()
+
+	verifier, _ = helper.RandomToken(helper.DefaultTokenLength)
+	authorize := &domain.AuthorizeValidation{
+		ResponseType:        "code",
+		ClientID:            testOAuthClient.ClientID,
+		RedirectURI:         testOAuthClient.RedirectURIs[0],
+		Scope:               "openid email",
+		CodeChallenge:       helper.PKCEChallenge(verifier),
+		CodeChallengeMethod: "S256",
+	}
+
+	ctx := context.Background()
+	client, err := uc.GetClientForRedirect(ctx, authorize.ClientID, authorize.RedirectURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scope, err := uc.ValidateAuthorize(client, authorize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err = uc.Authorize(ctx, client, authorize, scope, user, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return code, verifier
+}
+
+func newTestOAuthUseCase(t *testing.T) (OAuthUseCase, *redisOAuthRepository, *redisUserRepository) {
+	t.Helper()
+
+	userRepo, server := newRedisUserRepository(t, &domain.User{ID: 1, Username: "jane"})
+	oauthRepo := newRedisOAuthRepository(t, server, testOAuthClient)
+
+	return NewOAuthUseCase(oauthRepo, userRepo), oauthRepo, userRepo
+}
+
+func TestExchangeCode(t *testing.T) {
+	uc, _, userRepo := newTestOAuthUseCase(t)
+	ctx := context.Background()
+
+	code, verifier := authorizeCode(t, uc, &domain.User{ID: 1})
+	token := &domain.TokenValidation{GrantType: "authorization_code", Code: code, RedirectURI: testOAuthClient.RedirectURIs[0], CodeVerifier: verifier}
+
+	user, uuid, authorization, err := uc.ExchangeCode(ctx, testOAuthClient, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != 1 || authorization.Scope != "openid email" {
+		t.Errorf("expected user 1 with scope openid email, got %d with %q", user.ID, authorization.Scope)
+	}
+	if exists, _ := userRepo.Redis.Exists(ctx, uuid).Result(); exists != 1 {
+		t.Error("expected a session to be started")
+	}
+
+	// A code can only be exchanged once
+	_, _, _, err = uc.ExchangeCode(ctx, testOAuthClient, token)
+	if !isOAuthError(err, "invalid_grant") {
+		t.Errorf("expected a reused code to be invalid_grant, got %v", err)
+	}
+}
+
+func TestExchangeCodeRefusesMismatches(t *testing.T) {
+	otherVerifier, _ := helper.RandomToken(helper.DefaultTokenLength)
+
+	tests := []struct {
+		name   string
+		client *domain.OAuthClient
+		mutate func(token *domain.TokenValidation)
+	}{
+		{"redirect_uri", testOAuthClient, func(token *domain.TokenValidation) { token.RedirectURI = "https://app.example.com/other" }},
+		{"verifier", testOAuthClient, func(token *domain.TokenValidation) { token.CodeVerifier = otherVerifier }},
+		{"missing verifier", testOAuthClient, func(token *domain.TokenValidation) { token.CodeVerifier = "" }},
+		{"client", &domain.OAuthClient{ClientID: "other"}, func(token *domain.TokenValidation) {}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uc, _, _ := newTestOAuthUseCase(t)
+			ctx := context.Background()
+
+			code, verifier := authorizeCode(t, uc, &domain.User{ID: 1})
+			token := &domain.TokenValidation{GrantType: "authorization_code", Code: code, RedirectURI: testOAuthClient.RedirectURIs[0], CodeVerifier: verifier}
+			tt.mutate(token)
+
+			_, _, _, err := uc.ExchangeCode(ctx, tt.client, token)
+			if !isOAuthError(err, "invalid_grant") {
+				t.Errorf("expected invalid_grant, got %v", err)
+			}
+
+			// A failed attempt spends the code all the same
+			token.RedirectURI, token.CodeVerifier = testOAuthClient.RedirectURIs[0], verifier
+			if _, _, _, err := uc.ExchangeCode(ctx, testOAuthClient, token); err == nil {
+				t.Error("expected the code to be spent")
+			}
+		})
+	}
+}
+
+func TestValidateAuthorizeRequiresPKCE(t *testing.T) {
+	uc, _, _ := newTestOAuthUseCase(t)
+
+	_, err := uc.ValidateAuthorize(testOAuthClient, &domain.AuthorizeValidation{ResponseType: "code", Scope: "openid"})
+	if !isOAuthError(err, "invalid_request") {
+		t.Errorf("expected invalid_request without a code_challenge, got %v", err)
+	}
+
+	_, err = uc.ValidateAuthorize(testOAuthClient, &domain.AuthorizeValidation{ResponseType: "code", Scope: "openid", CodeChallenge: "challenge", CodeChallengeMethod: "plain"})
+	if !isOAuthError(err, "invalid_request") {
+		t.Errorf("expected invalid_request for a plain code_challenge, got %v", err)
+	}
+}
+
+func TestConsentIsRequiredForEveryScope(t *testing.T) {
+	uc, _, _ := newTestOAuthUseCase(t)
+	ctx := context.Background()
+	user := &domain.User{ID: 1}
+
+	if granted, _ := uc.HasConsent(ctx, testOAuthClient, user, "openid"); granted {
+		t.Error("expected consent to be required before it was granted")
+	}
+
+	if err := uc.GrantConsent(ctx, testOAuthClient, user, "openid"); err != nil {
+		t.Fatal(err)
+	}
+
+	if granted, _ := uc.HasConsent(ctx, testOAuthClient, user, "openid"); !granted {
+		t.Error("expected the granted scope not to ask again")
+	}
+	if granted, _ := uc.HasConsent(ctx, testOAuthClient, user, "openid email"); granted {
+		t.Error("expected a scope that was not granted to ask again")
+	}
+	if granted, _ := uc.HasConsent(ctx, &domain.OAuthClient{ClientID: "other"}, user, "openid"); granted {
+		t.Error("expected consent to be granted per client")
+	}
+}
+
+func TestCreateClient(t *testing.T) {
+	uc, oauthRepo, _ := newTestOAuthUseCase(t)
+	ctx := context.Background()
+
+	secret, client, err := uc.CreateClient(ctx, &domain.CreateOAuthClientValidation{ClientID: "new", RedirectURIs: []string{"https://new.example.com/callback"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(client.GrantTypes) != 2 || len(client.AllowedScopes) != len(domain.OAuthScopes) {
+		t.Errorf("expected the default grant types and scopes, got %v and %v", client.GrantTypes, client.AllowedScopes)
+	}
+	if _, err := uc.AuthenticateClient(ctx, "new", secret); err != nil {
+		t.Errorf("expected the returned secret to authenticate the client, got %v", err)
+	}
+	if oauthRepo.clients["new"].SecretHash == secret {
+		t.Error("expected only the hash of the secret to be kept")
+	}
+
+	secret, client, err = uc.CreateClient(ctx, &domain.CreateOAuthClientValidation{ClientID: "public", GrantTypes: []string{domain.GrantTypeDeviceCode}, Public: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret != "" || client.SecretHash != "" {
+		t.Error("expected a public client to get no secret")
+	}
+}
+
+func TestCreateClientErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input *domain.CreateOAuthClientValidation
+	}{
+		{"taken client_id", &domain.CreateOAuthClientValidation{ClientID: testOAuthClient.ClientID, RedirectURIs: []string{"https://app.example.com/callback"}}},
+		{"no redirect_uri", &domain.CreateOAuthClientValidation{ClientID: "new"}},
+		{"relative redirect_uri", &domain.CreateOAuthClientValidation{ClientID: "new", RedirectURIs: []string{"/callback"}}},
+		{"redirect_uri with fragment", &domain.CreateOAuthClientValidation{ClientID: "new", RedirectURIs: []string{"https://new.example.com/callback#x"}}},
+		{"unknown scope", &domain.CreateOAuthClientValidation{ClientID: "new", RedirectURIs: []string{"https://new.example.com/callback"}, Scopes: []string{"admin"}}},
+		{"unknown grant type", &domain.CreateOAuthClientValidation{ClientID: "new", GrantTypes: []string{"password"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uc, _, _ := newTestOAuthUseCase(t)
+
+			if _, _, err := uc.CreateClient(context.Background(), tt.input); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func isOAuthError(err error, code string) bool {
+	var oauthErr *domain.OAuthError
+	return errors.As(err, &oauthErr) && oauthErr.Code == code
+}
+
+var testDeviceClient = &domain.OAuthClient{
+	ClientID:      "tv",
+	AllowedScopes: []string{"openid"},
+	GrantTypes:    []string{domain.GrantTypeDeviceCode},
+}
+
+// pollDevice polls for the tokens of a device flow and returns the OAuth error
+// code, or an empty one when the tokens were handed out
+func pollDevice(t *testing.T, uc OAuthUseCase, deviceCode string) string {
+	t.Helper()
+
+	_, _, _, err := uc.ExchangeDeviceCode(context.Background(), testDeviceClient, deviceCode)
+	if err == nil {
+		return ""
+	}
+
+	var oauthErr *domain.OAuthError
+	if !errors.As(err, &oauthErr) {
+		t.Fatal(err)
+	}
+	return oauthErr.Code
+}
+
+func TestDeviceFlow(t *testing.T) {
+	tests := []struct {
+		name     string
+		approve  bool
+		expected string
+	}{
+		{"approved", true, ""},
+		{"denied", false, "access_denied"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userRepo, server := newRedisUserRepository(t, &domain.User{ID: 1})
+			uc := NewOAuthUseCase(newRedisOAuthRepository(t, server, testDeviceClient), userRepo)
+			ctx := context.Background()
+
+			device, err := uc.StartDeviceAuthorization(ctx, testDeviceClient, "openid")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if code := pollDevice(t, uc, device.DeviceCode); code != "authorization_pending" {
+				t.Errorf("expected authorization_pending, got %q", code)
+			}
+
+			if err := uc.VerifyDevice(ctx, device.UserCode, &domain.User{ID: 1}, tt.approve); err != nil {
+				t.Fatal(err)
+			}
+			server.FastForward(time.Duration(device.Interval) * time.Second)
+
+			if code := pollDevice(t, uc, device.DeviceCode); code != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, code)
+			}
+
+			// The outcome is handed out once
+			server.FastForward(time.Duration(device.Interval) * time.Second)
+			if code := pollDevice(t, uc, device.DeviceCode); code != "expired_token" {
+				t.Errorf("expected expired_token once collected, got %q", code)
+			}
+		})
+	}
+}
+
+func TestDeviceFlowSlowDown(t *testing.T) {
+	userRepo, server := newRedisUserRepository(t, &domain.User{ID: 1})
+	oauthRepo := newRedisOAuthRepository(t, server, testDeviceClient)
+	uc := NewOAuthUseCase(oauthRepo, userRepo)
+	ctx := context.Background()
+
+	device, err := uc.StartDeviceAuthorization(ctx, testDeviceClient, "openid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	interval := time.Duration(device.Interval) * time.Second
+
+	if code := pollDevice(t, uc, device.DeviceCode); code != "authorization_pending" {
+		t.Errorf("expected authorization_pending, got %q", code)
+	}
+	if code := pollDevice(t, uc, device.DeviceCode); code != "slow_down" {
+		t.Errorf("expected slow_down polling right away, got %q", code)
+	}
+
+	stored, err := oauthRepo.GetDeviceAuthorization(ctx, device.DeviceCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Interval != device.Interval+deviceSlowDownSecond {
+		t.Errorf("expected the interval raised to %d, got %d", device.Interval+deviceSlowDownSecond, stored.Interval)
+	}
+
+	// The old interval is no longer enough
+	server.FastForward(interval + time.Second)
+	if code := pollDevice(t, uc, device.DeviceCode); code != "slow_down" {
+		t.Errorf("expected slow_down within the raised interval, got %q", code)
+	}
+
+	stored, _ = oauthRepo.GetDeviceAuthorization(ctx, device.DeviceCode)
+	server.FastForward(time.Duration(stored.Interval) * time.Second)
+	if code := pollDevice(t, uc, device.DeviceCode); code != "authorization_pending" {
+		t.Errorf("expected authorization_pending after the raised interval, got %q", code)
+	}
+}
+
+func TestDeviceFlowExpires(t *testing.T) {
+	userRepo, server := newRedisUserRepository(t, &domain.User{ID: 1})
+	uc := NewOAuthUseCase(newRedisOAuthRepository(t, server, testDeviceClient), userRepo)
+	ctx := context.Background()
+
+	device, err := uc.StartDeviceAuthorization(ctx, testDeviceClient, "openid")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.FastForward(time.Duration(device.ExpiresIn) * time.Second)
+
+	if code := pollDevice(t, uc, device.DeviceCode); code != "expired_token" {
+		t.Errorf("expected expired_token, got %q", code)
+	}
+	if err := uc.VerifyDevice(ctx, device.UserCode, &domain.User{ID: 1}, true); err == nil {
+		t.Error("expected an expired user code to be refused")
+	}
+}
@@ -0,0 +1,157 @@
+package usecase
+
+import (
+	"auth/internal/domain"
+	"auth/internal/helper"
+	"auth/internal/repository"
+	"auth/internal/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// Used when SERVICE_TOKEN_EXPIRE_MINUTE is not set
+const defaultServiceTokenExpireMinute = 15
+
+// ServiceAccountUseCase represent the service account's usecase contract
+type ServiceAccountUseCase interface {
+	Authenticate(ctx context.Context, clientID string, clientSecret string) (*domain.ServiceAccount, error)
+	IssueToken(ctx context.Context, clientID string, clientSecret string, scope string, audience string) (token string, grantedScope string, expire time.Duration, err error)
+	Create(ctx context.Context, input *domain.CreateServiceAccountValidation) (secret string, account *domain.ServiceAccount, err error)
+}
+
+type ServiceAccountUseCaseImpl struct {
+	ServiceAccountRepo repository.ServiceAccountRepository
+	UserRepo           repository.UserRepository
+}
+
+func NewServiceAccountUseCase(ServiceAccountRepo repository.ServiceAccountRepository, UserRepo repository.UserRepository) ServiceAccountUseCase {
+	return &ServiceAccountUseCaseImpl{
+		ServiceAccountRepo: ServiceAccountRepo,
+		UserRepo:           UserRepo,
+	}
+}
+
+func (uc *ServiceAccountUseCaseImpl) Authenticate(ctx context.Context, clientID string, clientSecret string) (*domain.ServiceAccount, error) {
+	account, err := uc.ServiceAccountRepo.GetByClientID(ctx, clientID)
+
+	if err != nil {
+		return nil, &domain.OAuthError{Code: "invalid_client", Description: "autentikasi client gagal"}
+	}
+
+	secretCheck, _ := helper.ComparePasswordAndHash(clientSecret, account.SecretHash)
+
+	if !secretCheck {
+		return nil, &domain.OAuthError{Code: "invalid_client", Description: "autentikasi client gagal"}
+	}
+
+	return account, nil
+}
+
+// Create registers a service account with a generated secret. Only the hash
+// of the secret is stored, the caller sees it this once.
+func (uc *ServiceAccountUseCaseImpl) Create(ctx context.Context, input *domain.CreateServiceAccountValidation) (secret string, account *domain.ServiceAccount, err error) {
+	existing, _ := uc.ServiceAccountRepo.GetByClientID(ctx, input.ClientID)
+
+	if existing != nil {
+		return "", nil, errors.New("client_id sudah terdaftar")
+	}
+
+	secret, err = helper.RandomToken(helper.DefaultTokenLength)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	secretHash, err := helper.CreateHash(secret, helper.DefaultParams)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	scopes := input.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+
+	audiences := input.Audiences
+	if audiences == nil {
+		audiences = []string{}
+	}
+
+	account, err = uc.ServiceAccountRepo.Insert(ctx, &domain.ServiceAccount{
+		ClientID:      input.ClientID,
+		Name:          input.Name,
+		SecretHash:    secretHash,
+		AllowedScopes: scopes,
+		Audiences:     audiences,
+	})
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	return secret, account, nil
+}
+
+// IssueToken authenticates a service account and mints a token for the
+// requested scope and audience. Both default to everything the account is
+// allowed when not requested.
+func (uc *ServiceAccountUseCaseImpl) IssueToken(ctx context.Context, clientID string, clientSecret string, scope string, audience string) (token string, grantedScope string, expire time.Duration, err error) {
+	account, err := uc.Authenticate(ctx, clientID, clientSecret)
+
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	grantedScope, err = checkScope(account.AllowedScopes, scope)
+
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	audiences := account.Audiences
+	if strings.TrimSpace(audience) != "" {
+		audiences = strings.Fields(audience)
+		for _, a := range audiences {
+			if !slices.Contains(account.Audiences, a) {
+				return "", "", 0, &domain.OAuthError{Code: "invalid_target", Description: "audience " + a + " tidak diizinkan"}
+			}
+		}
+	}
+
+	expireMinute, _ := strconv.Atoi(os.Getenv("SERVICE_TOKEN_EXPIRE_MINUTE"))
+	if expireMinute <= 0 {
+		expireMinute = defaultServiceTokenExpireMinute
+	}
+	expire = time.Minute * time.Duration(expireMinute)
+
+	token, err = utils.GenerateServiceToken(account.ClientID, grantedScope, audiences, expire)
+
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	// Every issuance is audited, machine access has no session to look at
+	publishToken := &domain.PublishServiceToken{
+		Action: "client-credentials",
+		Data: domain.ServiceTokenAction{
+			ClientID: account.ClientID,
+			Scope:    grantedScope,
+			Audience: audiences,
+			Exp:      expire,
+		},
+	}
+
+	b, _ := json.Marshal(publishToken)
+
+	uc.UserRepo.Publish(ctx, string(b), "auth-client-credentials")
+
+	return token, grantedScope, expire, nil
+}
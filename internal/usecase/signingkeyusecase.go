@@ -0,0 +1,312 @@
+package usecase
+
+import (
+	"auth/internal/domain"
+	"auth/internal/helper"
+	"auth/internal/repository"
+	"auth/internal/utils"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Used when JWT_SIGNING_ALG is not set, the key store only holds asymmetric
+// keys
+const defaultStoreSigningAlg = utils.AlgES256
+
+// Used when SIGNING_KEY_ROTATE_HOUR is not set
+const defaultSigningKeyRotateHour = 720
+
+// Used when SIGNING_KEY_RETIRE_HOUR is not set. A replaced key must keep
+// verifying for longer than the longest lived token it signed.
+const defaultSigningKeyRetireHour = 48
+
+// signingKeyReloadInterval is how soon an instance picks up keys another
+// instance rotated. It must be much shorter than a key is next for.
+const signingKeyReloadInterval = time.Minute
+
+// SigningKeyUseCase represent the usecase contract of the signing key store
+type SigningKeyUseCase interface {
+	Enabled() bool
+	Load(ctx context.Context) error
+	Rotate(ctx context.Context) ([]*domain.SigningKey, error)
+	Run(ctx context.Context)
+}
+
+type SigningKeyUseCaseImpl struct {
+	SigningKeyRepo repository.SigningKeyRepository
+}
+
+func NewSigningKeyUseCase(SigningKeyRepo repository.SigningKeyRepository) SigningKeyUseCase {
+	return &SigningKeyUseCaseImpl{
+		SigningKeyRepo: SigningKeyRepo,
+	}
+}
+
+// Enabled reports whether keys come from the store, set
+// JWT_SIGNING_KEY_STORE=postgres to use it
+func (uc *SigningKeyUseCaseImpl) Enabled() bool {
+	return os.Getenv("JWT_SIGNING_KEY_STORE") == "postgres"
+}
+
+// Load signs and verifies tokens with the keys in the store from now on. An
+// empty store is given its first active and next keys.
+func (uc *SigningKeyUseCaseImpl) Load(ctx context.Context) error {
+	if err := checkSigningKeyRetire(); err != nil {
+		return err
+	}
+
+	keys, err := uc.SigningKeyRepo.GetUsable(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	// The first rotation of an empty store only adds a next key
+	for i := 0; i < 2 && activeSigningKey(keys) == nil; i++ {
+		if _, err := uc.rotate(ctx, time.Time{}); err != nil {
+			return err
+		}
+
+		keys, err = uc.SigningKeyRepo.GetUsable(ctx)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return uc.use(keys)
+}
+
+// Rotate replaces the active key right away. Tokens it signed stay valid,
+// it keeps verifying until it is retired.
+func (uc *SigningKeyUseCaseImpl) Rotate(ctx context.Context) ([]*domain.SigningKey, error) {
+	if !uc.Enabled() {
+		return nil, errors.New("key store tidak digunakan")
+	}
+
+	if _, err := uc.rotate(ctx, time.Now()); err != nil {
+		return nil, err
+	}
+
+	keys, err := uc.SigningKeyRepo.GetUsable(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, uc.use(keys)
+}
+
+// Run keeps the keys of this instance current until ctx is done. It rotates
+// every SIGNING_KEY_ROTATE_HOUR and retires keys SIGNING_KEY_RETIRE_HOUR after
+// they were replaced, whichever instance gets there first does it.
+func (uc *SigningKeyUseCaseImpl) Run(ctx context.Context) {
+	ticker := time.NewTicker(signingKeyReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		uc.tick(ctx, time.Now())
+	}
+}
+
+// tick is one round of Run: retire, rotate when due, reload
+func (uc *SigningKeyUseCaseImpl) tick(ctx context.Context, now time.Time) {
+	if err := uc.SigningKeyRepo.Retire(ctx, now.Add(-signingKeyHourEnv("SIGNING_KEY_RETIRE_HOUR", defaultSigningKeyRetireHour))); err != nil {
+		log.Printf("Retiring signing keys failed: %s", err)
+	}
+
+	// Generating a key is not free, only try when rotation is due
+	rotateBefore := now.Add(-signingKeyHourEnv("SIGNING_KEY_ROTATE_HOUR", defaultSigningKeyRotateHour))
+	keys, err := uc.SigningKeyRepo.GetUsable(ctx)
+
+	if active := activeSigningKey(keys); err == nil && active != nil && active.StateChangedAt.Before(rotateBefore) {
+		if _, err := uc.rotate(ctx, rotateBefore); err != nil {
+			log.Printf("Rotating signing keys failed: %s", err)
+		}
+	}
+
+	if err := uc.Load(ctx); err != nil {
+		log.Printf("Reloading signing keys failed: %s", err)
+	}
+}
+
+// rotate generates the new next key and rotates when the active key became
+// active before activeBefore
+func (uc *SigningKeyUseCaseImpl) rotate(ctx context.Context, activeBefore time.Time) (bool, error) {
+	algorithm := os.Getenv("JWT_SIGNING_ALG")
+	if algorithm == "" || algorithm == utils.AlgHS256 {
+		algorithm = defaultStoreSigningAlg
+	}
+
+	privateKey, err := utils.GeneratePrivateKey(algorithm)
+
+	if err != nil {
+		return false, err
+	}
+
+	key, err := utils.NewSigningKey("", algorithm, privateKey)
+
+	if err != nil {
+		return false, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+
+	if err != nil {
+		return false, err
+	}
+
+	encryptionKey, err := signingKeyEncryptionKey()
+
+	if err != nil {
+		return false, err
+	}
+
+	sealed, err := helper.Encrypt(encryptionKey, der, []byte(key.ID))
+
+	if err != nil {
+		return false, err
+	}
+
+	return uc.SigningKeyRepo.Rotate(ctx, &domain.SigningKey{
+		ID:         key.ID,
+		Algorithm:  algorithm,
+		PrivateKey: sealed,
+	}, activeBefore)
+}
+
+// use decrypts the keys and hands them to token signing, the active key
+// signs and every key verifies
+func (uc *SigningKeyUseCaseImpl) use(keys []*domain.SigningKey) error {
+	active := activeSigningKey(keys)
+
+	if active == nil {
+		return errors.New("no active signing key")
+	}
+
+	encryptionKey, err := signingKeyEncryptionKey()
+
+	if err != nil {
+		return err
+	}
+
+	ordered := []*domain.SigningKey{active}
+	for _, key := range keys {
+		if key != active {
+			ordered = append(ordered, key)
+		}
+	}
+
+	signingKeys := []*utils.SigningKey{}
+
+	for _, key := range ordered {
+		der, err := helper.Decrypt(encryptionKey, key.PrivateKey, []byte(key.ID))
+
+		if err != nil {
+			return errors.New("signing key " + key.ID + " cannot be decrypted")
+		}
+
+		privateKey, err := x509.ParsePKCS8PrivateKey(der)
+
+		if err != nil {
+			return err
+		}
+
+		signer, ok := privateKey.(crypto.Signer)
+
+		if !ok {
+			return errors.New("signing key " + key.ID + " is not a private key")
+		}
+
+		signingKey, err := utils.NewSigningKey(key.ID, key.Algorithm, signer)
+
+		if err != nil {
+			return err
+		}
+
+		signingKeys = append(signingKeys, signingKey)
+	}
+
+	utils.UseKeySet(utils.NewStaticKeySet(signingKeys...))
+
+	return nil
+}
+
+func activeSigningKey(keys []*domain.SigningKey) *domain.SigningKey {
+	for _, key := range keys {
+		if key.State == domain.SigningKeyActive {
+			return key
+		}
+	}
+
+	return nil
+}
+
+// signingKeyEncryptionKey is the base64 encoded AES-256 key the store is
+// encrypted with
+func signingKeyEncryptionKey() ([]byte, error) {
+	key, err := helper.ParseEncryptionKey(os.Getenv("SIGNING_KEY_ENCRYPTION_KEY"))
+
+	if err != nil {
+		return nil, errors.New("SIGNING_KEY_ENCRYPTION_KEY must be 32 base64 encoded bytes")
+	}
+
+	return key, nil
+}
+
+// checkSigningKeyRetire refuses a retire window shorter than the lifetime of
+// a token the keys sign, such a token would stop verifying before it expires.
+// Refresh tokens are opaque and not signed, the access tokens they buy are.
+func checkSigningKeyRetire() error {
+	retire := signingKeyHourEnv("SIGNING_KEY_RETIRE_HOUR", defaultSigningKeyRetireHour)
+
+	lifetimes := []struct {
+		name   string
+		expire time.Duration
+	}{
+		{"JWT_EXPIRE_HOUR", utils.TokenExpire()},
+		{"EMAIL_VERIFICATION_EXPIRE_HOUR", envDuration("EMAIL_VERIFICATION_EXPIRE_HOUR", time.Hour, defaultEmailVerificationExpireHour)},
+		{"PASSWORD_RESET_EXPIRE_MINUTE", envDuration("PASSWORD_RESET_EXPIRE_MINUTE", time.Minute, defaultPasswordResetExpireMinute)},
+		{"IMPERSONATION_EXPIRE_MINUTE", envDuration("IMPERSONATION_EXPIRE_MINUTE", time.Minute, defaultImpersonationExpireMinute)},
+		{"SERVICE_TOKEN_EXPIRE_MINUTE", envDuration("SERVICE_TOKEN_EXPIRE_MINUTE", time.Minute, defaultServiceTokenExpireMinute)},
+	}
+
+	for _, lifetime := range lifetimes {
+		if lifetime.expire >= retire {
+			return errors.New("SIGNING_KEY_RETIRE_HOUR must be longer than " + lifetime.name)
+		}
+	}
+
+	return nil
+}
+
+// envDuration reads a whole number of units from the environment
+func envDuration(name string, unit time.Duration, fallback int) time.Duration {
+	value, _ := strconv.Atoi(os.Getenv(name))
+	if value <= 0 {
+		value = fallback
+	}
+
+	return unit * time.Duration(value)
+}
+
+func signingKeyHourEnv(name string, fallback int) time.Duration {
+	hour, _ := strconv.Atoi(os.Getenv(name))
+	if hour <= 0 {
+		hour = fallback
+	}
+
+	return time.Hour * time.Duration(hour)
+}
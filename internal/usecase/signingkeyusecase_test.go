@@ -0,0 +1,212 @@
+package usecase
+
+import (
+	"auth/internal/domain"
+	"auth/internal/utils"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+// memorySigningKeyRepository moves keys through their states like the
+// Postgres store does
+type memorySigningKeyRepository struct {
+	keys []*domain.SigningKey
+}
+
+func (r *memorySigningKeyRepository) GetUsable(ctx context.Context) ([]*domain.SigningKey, error) {
+	usable := []*domain.SigningKey{}
+	for _, key := range r.keys {
+		if key.State != domain.SigningKeyRetired {
+			copied := *key
+			usable = append(usable, &copied)
+		}
+	}
+	return usable, nil
+}
+
+func (r *memorySigningKeyRepository) Rotate(ctx context.Context, next *domain.SigningKey, activeBefore time.Time) (bool, error) {
+	if active := r.inState(domain.SigningKeyActive); active != nil && !active.StateChangedAt.Before(activeBefore) {
+		return false, nil
+	}
+
+	now := time.Now()
+	if active := r.inState(domain.SigningKeyActive); active != nil {
+		active.State, active.StateChangedAt = domain.SigningKeyRetiring, now
+	}
+	if upcoming := r.inState(domain.SigningKeyNext); upcoming != nil {
+		upcoming.State, upcoming.StateChangedAt = domain.SigningKeyActive, now
+	}
+
+	added := *next
+	added.State, added.CreatedAt, added.StateChangedAt = domain.SigningKeyNext, now, now
+	r.keys = append(r.keys, &added)
+
+	return true, nil
+}
+
+func (r *memorySigningKeyRepository) Retire(ctx context.Context, retiringBefore time.Time) error {
+	for _, key := range r.keys {
+		if key.State == domain.SigningKeyRetiring && key.StateChangedAt.Before(retiringBefore) {
+			key.State, key.StateChangedAt, key.PrivateKey = domain.SigningKeyRetired, time.Now(), nil
+		}
+	}
+	return nil
+}
+
+func (r *memorySigningKeyRepository) inState(state string) *domain.SigningKey {
+	for _, key := range r.keys {
+		if key.State == state {
+			return key
+		}
+	}
+	return nil
+}
+
+func (r *memorySigningKeyRepository) count(state string) int {
+	count := 0
+	for _, key := range r.keys {
+		if key.State == state {
+			count++
+		}
+	}
+	return count
+}
+
+func newTestSigningKeyUseCase(t *testing.T) (*SigningKeyUseCaseImpl, *memorySigningKeyRepository) {
+	encryptionKey := make([]byte, 32)
+	rand.Read(encryptionKey)
+
+	t.Setenv("JWT_SIGNING_KEY_STORE", "postgres")
+	t.Setenv("JWT_SIGNING_ALG", utils.AlgES256)
+	t.Setenv("SIGNING_KEY_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString(encryptionKey))
+	t.Setenv("JWT_EXPIRE_HOUR", "1")
+	t.Setenv("SIGNING_KEY_RETIRE_HOUR", "")
+	t.Setenv("SIGNING_KEY_ROTATE_HOUR", "")
+	t.Cleanup(func() { utils.UseKeySet(nil) })
+
+	repo := &memorySigningKeyRepository{}
+	return &SigningKeyUseCaseImpl{SigningKeyRepo: repo}, repo
+}
+
+func signedToken(t *testing.T) string {
+	token, err := utils.GenerateToken(context.Background(), &domain.User{ID: 1}, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token.(string)
+}
+
+func TestSigningKeyLoadFillsAnEmptyStore(t *testing.T) {
+	uc, repo := newTestSigningKeyUseCase(t)
+
+	if err := uc.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if repo.count(domain.SigningKeyActive) != 1 || repo.count(domain.SigningKeyNext) != 1 || len(repo.keys) != 2 {
+		t.Fatalf("expected one active and one next key, got %d keys", len(repo.keys))
+	}
+
+	if _, err := utils.ParseToken(signedToken(t)); err != nil {
+		t.Errorf("expected the active key to sign, got %s", err)
+	}
+
+	// Loading again leaves a filled store alone
+	if err := uc.Load(context.Background()); err != nil || len(repo.keys) != 2 {
+		t.Errorf("expected no rotation, got %d keys %v", len(repo.keys), err)
+	}
+}
+
+func TestSigningKeyRotateKeepsOldTokensValid(t *testing.T) {
+	uc, repo := newTestSigningKeyUseCase(t)
+	ctx := context.Background()
+
+	if err := uc.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	before := signedToken(t)
+	upcoming := repo.inState(domain.SigningKeyNext).ID
+
+	if _, err := uc.Rotate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if active := repo.inState(domain.SigningKeyActive); active == nil || active.ID != upcoming {
+		t.Fatal("expected the next key to become active")
+	}
+	if repo.count(domain.SigningKeyRetiring) != 1 || repo.count(domain.SigningKeyNext) != 1 {
+		t.Fatal("expected the old key to retire and a new next key")
+	}
+
+	if _, err := utils.ParseToken(before); err != nil {
+		t.Errorf("expected a token of the retiring key to verify, got %s", err)
+	}
+	if _, err := utils.ParseToken(signedToken(t)); err != nil {
+		t.Errorf("expected the new active key to sign, got %s", err)
+	}
+}
+
+func TestSigningKeyTickRetiresAndRotates(t *testing.T) {
+	uc, repo := newTestSigningKeyUseCase(t)
+	ctx := context.Background()
+
+	if err := uc.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uc.Rotate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	retiring := signedToken(t)
+	uc.Rotate(ctx)
+
+	// Nothing is due yet
+	uc.tick(ctx, time.Now())
+
+	if repo.count(domain.SigningKeyRetired) != 0 || len(repo.keys) != 4 {
+		t.Fatalf("expected nothing to change, got %d keys", len(repo.keys))
+	}
+
+	// Past the retire window and the rotation period
+	uc.tick(ctx, time.Now().Add(defaultSigningKeyRotateHour*time.Hour+time.Minute))
+
+	if repo.count(domain.SigningKeyRetired) != 2 {
+		t.Errorf("expected both retiring keys to retire, got %d", repo.count(domain.SigningKeyRetired))
+	}
+	if len(repo.keys) != 5 || repo.count(domain.SigningKeyActive) != 1 || repo.count(domain.SigningKeyNext) != 1 {
+		t.Errorf("expected a rotation, got %d keys", len(repo.keys))
+	}
+
+	if _, err := utils.ParseToken(retiring); err == nil {
+		t.Error("expected a token of a retired key to be refused")
+	}
+}
+
+func TestSigningKeyRetireMustOutliveTokens(t *testing.T) {
+	uc, _ := newTestSigningKeyUseCase(t)
+	t.Setenv("SIGNING_KEY_RETIRE_HOUR", "2")
+	t.Setenv("JWT_EXPIRE_HOUR", "2")
+
+	if err := uc.Load(context.Background()); err == nil {
+		t.Fatal("expected a retire window as long as the token lifetime to be refused")
+	}
+
+	t.Setenv("JWT_EXPIRE_HOUR", "1")
+	t.Setenv("EMAIL_VERIFICATION_EXPIRE_HOUR", "24")
+
+	if err := uc.Load(context.Background()); err == nil {
+		t.Fatal("expected a retire window shorter than email verification to be refused")
+	}
+
+	// Unset lifetimes count with their defaults
+	t.Setenv("EMAIL_VERIFICATION_EXPIRE_HOUR", "")
+	t.Setenv("SIGNING_KEY_RETIRE_HOUR", "12")
+
+	if err := uc.Load(context.Background()); err == nil {
+		t.Fatal("expected a retire window shorter than the default email verification to be refused")
+	}
+}
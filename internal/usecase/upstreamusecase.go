@@ -0,0 +1,275 @@
+package usecase
+
+import (
+	"auth/internal/domain"
+	"auth/internal/helper"
+	"auth/internal/repository"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// upstreamStateExpire bounds how long a user may take at the provider
+const upstreamStateExpire = 10 * time.Minute
+
+var usernameUnsafe = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// UpstreamUseCase represent the usecase contract of signing in through
+// upstream identity providers
+type UpstreamUseCase interface {
+	Begin(ctx context.Context, provider string) (redirectURL string, state string, err error)
+	Callback(ctx context.Context, provider string, callback *domain.UpstreamCallbackValidation, browserState string) (user *domain.User, uuid string, mfaToken string, err error)
+}
+
+type UpstreamUseCaseImpl struct {
+	UpstreamRepo repository.UpstreamRepository
+	UserRepo     repository.UserRepository
+	MfaRepo      repository.MfaRepository
+	Providers    map[string]domain.UpstreamProvider
+}
+
+func NewUpstreamUseCase(UpstreamRepo repository.UpstreamRepository, UserRepo repository.UserRepository, MfaRepo repository.MfaRepository, Providers map[string]domain.UpstreamProvider) UpstreamUseCase {
+	return &UpstreamUseCaseImpl{
+		UpstreamRepo: UpstreamRepo,
+		UserRepo:     UserRepo,
+		MfaRepo:      MfaRepo,
+		Providers:    Providers,
+	}
+}
+
+// Begin returns where to send the user to sign in at a provider. The state
+// must also be handed to the browser, Callback only accepts it back from
+// the same browser.
+func (uc *UpstreamUseCaseImpl) Begin(ctx context.Context, provider string) (redirectURL string, state string, err error) {
+	upstream, ok := uc.Providers[provider]
+
+	if !ok {
+		return "", "", errors.New("provider tidak dikenal")
+	}
+
+	state, err = helper.RandomToken(helper.DefaultTokenLength)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	nonce, err := helper.RandomToken(helper.DefaultTokenLength)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	codeVerifier, err := helper.RandomToken(helper.DefaultTokenLength)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	upstreamState, _ := json.Marshal(&domain.UpstreamState{
+		Provider:     provider,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+	})
+
+	err = uc.UserRepo.RememberToken(ctx, "upstream-state:"+helper.HashToken(state), string(upstreamState), upstreamStateExpire)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	redirectURL, err = upstream.AuthCodeURL(state, nonce, codeVerifier)
+
+	if err != nil {
+		return "", "", errors.New("provider tidak dapat dihubungi")
+	}
+
+	return redirectURL, state, nil
+}
+
+func (uc *UpstreamUseCaseImpl) Callback(ctx context.Context, provider string, callback *domain.UpstreamCallbackValidation, browserState string) (user *domain.User, uuidGen string, mfaToken string, err error) {
+	upstream, ok := uc.Providers[provider]
+
+	if !ok {
+		return nil, "", "", errors.New("provider tidak dikenal")
+	}
+
+	if callback.State == "" || callback.State != browserState {
+		return nil, "", "", errors.New("state tidak valid")
+	}
+
+	res, err := uc.UserRepo.ConsumeToken(ctx, "upstream-state:"+helper.HashToken(callback.State))
+
+	if err != nil {
+		return nil, "", "", errors.New("state tidak valid atau sudah kadaluarsa")
+	}
+
+	upstreamState := &domain.UpstreamState{}
+	json.Unmarshal([]byte(res), upstreamState)
+
+	if upstreamState.Provider != provider {
+		return nil, "", "", errors.New("state tidak valid")
+	}
+
+	if callback.Error != "" {
+		return nil, "", "", errors.New("login di provider gagal: " + callback.Error)
+	}
+
+	identity, err := upstream.Exchange(ctx, callback.Code, upstreamState.CodeVerifier, upstreamState.Nonce)
+
+	if err != nil {
+		return nil, "", "", errors.New("login di provider gagal")
+	}
+
+	user, err = uc.resolveUser(ctx, identity)
+
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	// Same rule as a password login
+	if os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true" && user.EmailVerifiedAt == nil {
+		return nil, "", "", errors.New("email belum diverifikasi")
+	}
+
+	// A local second factor still applies to federated logins
+	uuidGen, mfaToken, err = startSession(ctx, uc.UserRepo, uc.MfaRepo, user)
+
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	if mfaToken != "" {
+		return nil, "", mfaToken, nil
+	}
+
+	return user, uuidGen, "", nil
+}
+
+// resolveUser finds the local user of an upstream identity. Unknown
+// identities are linked to the local user with the same email when both sides
+// verified it, otherwise a new user is provisioned.
+func (uc *UpstreamUseCaseImpl) resolveUser(ctx context.Context, identity *domain.UpstreamIdentity) (*domain.User, error) {
+	userID, err := uc.UpstreamRepo.GetUserID(ctx, identity.Provider, identity.Subject)
+
+	if err == nil {
+		return uc.UserRepo.GetOneByID(ctx, userID)
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	if identity.Email == "" {
+		return nil, errors.New("provider tidak memberikan email")
+	}
+
+	user, err := uc.UserRepo.GetOneByEmail(ctx, identity.Email)
+
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	if user != nil {
+		// Linking on an unverified address would let whoever registered it
+		// first take over the account
+		if !identity.EmailVerified || user.EmailVerifiedAt == nil {
+			return nil, errors.New("email telah terdaftar, login dengan password lalu verifikasi email terlebih dahulu")
+		}
+	} else {
+		user, err = uc.provisionUser(ctx, identity)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = uc.UpstreamRepo.LinkIdentity(ctx, user.ID, identity.Provider, identity.Subject)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (uc *UpstreamUseCaseImpl) provisionUser(ctx context.Context, identity *domain.UpstreamIdentity) (*domain.User, error) {
+	username, err := uc.uniqueUsername(ctx, identity)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The user signs in through the provider until they reset it
+	hashpassword, err := unusablePasswordHash()
+
+	if err != nil {
+		return nil, err
+	}
+
+	name := identity.Name
+	if name == "" {
+		name = username
+	}
+
+	user, err := uc.UserRepo.Insert(ctx, &domain.User{
+		Email:    identity.Email,
+		Name:     name,
+		Username: username,
+		Password: hashpassword,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if identity.EmailVerified {
+		err = uc.UserRepo.MarkEmailVerified(ctx, user.ID)
+
+		if err != nil {
+			return nil, err
+		}
+
+		verifiedAt := time.Now()
+		user.EmailVerifiedAt = &verifiedAt
+	}
+
+	return user, nil
+}
+
+// uniqueUsername derives a free username from the provider's preferred
+// username or the email's local part
+func (uc *UpstreamUseCaseImpl) uniqueUsername(ctx context.Context, identity *domain.UpstreamIdentity) (string, error) {
+	base := identity.PreferredUsername
+	if at := strings.Index(base, "@"); at > 0 {
+		base = base[:at]
+	}
+	if base == "" {
+		base = strings.SplitN(identity.Email, "@", 2)[0]
+	}
+	base = usernameUnsafe.ReplaceAllString(base, "")
+	if base == "" {
+		base = identity.Provider
+	}
+
+	username := base
+	for i := 0; i < 5; i++ {
+		if _, err := uc.UserRepo.GetOneByUsername(ctx, username); errors.Is(err, sql.ErrNoRows) {
+			return username, nil
+		}
+
+		suffix, err := helper.RandomToken(3)
+
+		if err != nil {
+			return "", err
+		}
+
+		username = base + "-" + strings.ToLower(usernameUnsafe.ReplaceAllString(suffix, ""))
+	}
+
+	return "", errors.New("username tidak tersedia")
+}
@@ -0,0 +1,84 @@
+package usecase
+
+import (
+	"auth/internal/domain"
+	"auth/internal/repository"
+	"auth/internal/utils"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"regexp"
+)
+
+// attributeName is what an attribute may be called, it becomes the name of
+// a claim
+var attributeName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.:-]{0,63}$`)
+
+type UserAttributeUseCase interface {
+	List(ctx context.Context, userID int) ([]*domain.UserAttribute, error)
+	Set(ctx context.Context, userID int, name string, value json.RawMessage) (*domain.UserAttribute, error)
+	Delete(ctx context.Context, userID int, name string) error
+}
+
+type UserAttributeUseCaseImpl struct {
+	UserAttributeRepo repository.UserAttributeRepository
+	UserRepo          repository.UserRepository
+}
+
+func NewUserAttributeUseCase(UserAttributeRepo repository.UserAttributeRepository, UserRepo repository.UserRepository) UserAttributeUseCase {
+	return &UserAttributeUseCaseImpl{
+		UserAttributeRepo: UserAttributeRepo,
+		UserRepo:          UserRepo,
+	}
+}
+
+func (uc *UserAttributeUseCaseImpl) List(ctx context.Context, userID int) ([]*domain.UserAttribute, error) {
+	if _, err := uc.UserRepo.GetOneByID(ctx, userID); err != nil {
+		return nil, domain.ErrUserNotFound
+	}
+
+	return uc.UserAttributeRepo.GetByUserID(ctx, userID)
+}
+
+// Set gives a user an attribute. Attributes become claims, so a name the
+// tokens already use is refused.
+func (uc *UserAttributeUseCaseImpl) Set(ctx context.Context, userID int, name string, value json.RawMessage) (*domain.UserAttribute, error) {
+	if !attributeName.MatchString(name) {
+		return nil, errors.New("nama atribut tidak valid")
+	}
+
+	if utils.ReservedClaim(name) {
+		return nil, errors.New("nama atribut " + name + " sudah digunakan oleh token")
+	}
+
+	if !json.Valid(value) {
+		return nil, errors.New("nilai atribut harus berupa JSON")
+	}
+
+	if _, err := uc.UserRepo.GetOneByID(ctx, userID); err != nil {
+		return nil, domain.ErrUserNotFound
+	}
+
+	attribute := &domain.UserAttribute{
+		UserID: userID,
+		Name:   name,
+		Value:  value,
+	}
+
+	if err := uc.UserAttributeRepo.Set(ctx, attribute); err != nil {
+		return nil, err
+	}
+
+	return attribute, nil
+}
+
+func (uc *UserAttributeUseCaseImpl) Delete(ctx context.Context, userID int, name string) error {
+	err := uc.UserAttributeRepo.Delete(ctx, userID, name)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.ErrUserAttributeNotFound
+	}
+
+	return err
+}
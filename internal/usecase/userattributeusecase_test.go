@@ -0,0 +1,125 @@
+package usecase
+
+import (
+	"auth/internal/domain"
+	"auth/internal/repository"
+	"auth/internal/utils"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// memoryUserAttributeRepository keeps the attributes of users in a map
+type memoryUserAttributeRepository struct {
+	attributes map[int][]*domain.UserAttribute
+	err        error
+}
+
+func (r *memoryUserAttributeRepository) GetByUserID(ctx context.Context, userID int) ([]*domain.UserAttribute, error) {
+	return r.attributes[userID], r.err
+}
+
+func (r *memoryUserAttributeRepository) Set(ctx context.Context, attribute *domain.UserAttribute) error {
+	if r.attributes == nil {
+		r.attributes = map[int][]*domain.UserAttribute{}
+	}
+	r.attributes[attribute.UserID] = append(r.attributes[attribute.UserID], attribute)
+	return nil
+}
+
+func (r *memoryUserAttributeRepository) Delete(ctx context.Context, userID int, name string) error {
+	return sql.ErrNoRows
+}
+
+// knownUserRepository finds the users with the ids it was given
+type knownUserRepository struct {
+	repository.UserRepository
+	ids []int
+}
+
+func (r *knownUserRepository) GetOneByID(ctx context.Context, id int) (*domain.User, error) {
+	for _, known := range r.ids {
+		if known == id {
+			return &domain.User{ID: id}, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func TestAttributeClaimsEnricherTurnsAttributesIntoClaims(t *testing.T) {
+	repo := &memoryUserAttributeRepository{attributes: map[int][]*domain.UserAttribute{
+		1: {
+			{UserID: 1, Name: "tenant_id", Value: json.RawMessage(`"acme"`)},
+			{UserID: 1, Name: "roles", Value: json.RawMessage(`["editor"]`)},
+		},
+	}}
+
+	claims, err := NewAttributeClaimsEnricher(repo).Enrich(context.Background(), &domain.User{ID: 1}, &utils.LoginContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, _ := json.Marshal(claims)
+	if string(out) != `{"roles":["editor"],"tenant_id":"acme"}` {
+		t.Errorf("unexpected claims %s", out)
+	}
+
+	claims, err = NewAttributeClaimsEnricher(repo).Enrich(context.Background(), &domain.User{ID: 2}, &utils.LoginContext{})
+	if err != nil || len(claims) != 0 {
+		t.Errorf("expected no claims for a user without attributes, got %v %v", claims, err)
+	}
+}
+
+func TestAttributeClaimsEnricherFails(t *testing.T) {
+	repo := &memoryUserAttributeRepository{err: errors.New("connection refused")}
+
+	if _, err := NewAttributeClaimsEnricher(repo).Enrich(context.Background(), &domain.User{ID: 1}, &utils.LoginContext{}); err == nil {
+		t.Error("expected a failed lookup to fail the enricher")
+	}
+}
+
+func TestUserAttributeSet(t *testing.T) {
+	tests := []struct {
+		name   string
+		userID int
+		attr   string
+		value  string
+		ok     bool
+	}{
+		{"claim", 1, "tenant_id", `"acme"`, true},
+		{"reserved", 1, "is_admin", `true`, false},
+		{"oidc", 1, "email_verified", `true`, false},
+		{"bad name", 1, "tenant id", `"acme"`, false},
+		{"not json", 1, "tenant_id", `acme`, false},
+		{"unknown user", 2, "tenant_id", `"acme"`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &memoryUserAttributeRepository{}
+			uc := NewUserAttributeUseCase(repo, &knownUserRepository{ids: []int{1}})
+
+			_, err := uc.Set(context.Background(), tt.userID, tt.attr, json.RawMessage(tt.value))
+
+			if tt.ok != (err == nil) {
+				t.Fatalf("expected ok %t, got %v", tt.ok, err)
+			}
+			if !tt.ok && len(repo.attributes) != 0 {
+				t.Error("expected nothing to be stored")
+			}
+		})
+	}
+}
+
+func TestUserAttributeNotFound(t *testing.T) {
+	uc := NewUserAttributeUseCase(&memoryUserAttributeRepository{}, &knownUserRepository{ids: []int{1}})
+
+	if _, err := uc.List(context.Background(), 2); !errors.Is(err, domain.ErrUserNotFound) {
+		t.Errorf("expected user not found, got %v", err)
+	}
+	if err := uc.Delete(context.Background(), 1, "tenant_id"); !errors.Is(err, domain.ErrUserAttributeNotFound) {
+		t.Errorf("expected attribute not found, got %v", err)
+	}
+}
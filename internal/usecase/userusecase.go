@@ -4,58 +4,261 @@ import (
 	"auth/internal/domain"
 	"auth/internal/helper"
 	"auth/internal/repository"
+	"auth/internal/utils"
 	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+// Used when IMPERSONATION_EXPIRE_MINUTE is not set
+const defaultImpersonationExpireMinute = 15
+
+// impersonationAuditInterval is how often impersonations that ran out are
+// looked for, their stop is reported at most this late
+const impersonationAuditInterval = time.Minute
+
+// Used when MAGIC_LINK_EXPIRE_MINUTE is not set
+const defaultMagicLinkExpireMinute = 15
+
+// Used when EMAIL_VERIFICATION_EXPIRE_HOUR is not set
+const defaultEmailVerificationExpireHour = 24
+
+// Used when PASSWORD_RESET_EXPIRE_MINUTE is not set
+const defaultPasswordResetExpireMinute = 60
+
 // UserUseCase represent the user's usecase contract
 type UserUseCase interface {
-	Login(ctx context.Context, login *domain.LoginValidation) (user *domain.User, uuid string, err error)
+	Login(ctx context.Context, login *domain.LoginValidation) (user *domain.User, uuid string, mfaToken string, err error)
 	Register(ctx context.Context, register *domain.RegisterValidation) (user *domain.User, uuid string, err error)
 	Profile(ctx context.Context, uuid string) (user *domain.User, err error)
 	CheckUsername(ctx context.Context, username string) (user *domain.User, err error)
 	Logout(ctx context.Context, uuid string)
+	Revoke(ctx context.Context, token string, tokenTypeHint string, clientID string)
+	IssueRefreshToken(ctx context.Context, user *domain.User, uuid string) (refreshToken string, err error)
+	IssueClientRefreshToken(ctx context.Context, user *domain.User, uuid string, clientID string, scope string) (refreshToken string, err error)
+	Refresh(ctx context.Context, refreshToken string, clientID string) (user *domain.User, uuid string, newRefreshToken string, scope string, err error)
+	VerifyEmail(ctx context.Context, token string) error
+	ResendVerification(ctx context.Context, email string) error
+	ForgotPassword(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, reset *domain.ResetPasswordValidation) error
+	ChangePassword(ctx context.Context, userID int, uuid string, change *domain.ChangePasswordValidation) error
+	RequestMagicLink(ctx context.Context, email string) (nonce string, err error)
+	ConsumeMagicLink(ctx context.Context, consume *domain.ConsumeMagicLinkValidation) (user *domain.User, uuid string, mfaToken string, err error)
+	RequireAdmin(ctx context.Context, user *domain.User) error
+	Impersonate(ctx context.Context, admin *domain.User, userID int) (user *domain.User, uuid string, expire time.Duration, err error)
+	StopImpersonation(ctx context.Context, uuid string, adminID int)
+	RunImpersonationAudit(ctx context.Context)
+	ListSessions(ctx context.Context, userID int, current string) ([]*domain.Session, error)
+	RevokeSession(ctx context.Context, userID int, uuid string) error
+	LogoutAll(ctx context.Context, userID int) (revoked []string, err error)
+	AdminLogoutAll(ctx context.Context, admin *domain.User, userID int) (revoked []string, err error)
+	TouchSession(ctx context.Context, userID int, uuid string, ip string)
 }
 
 type UserUseCaseImpl struct {
-	UserRepo repository.UserRepository
+	UserRepo  repository.UserRepository
+	MfaRepo   repository.MfaRepository
+	Directory domain.Directory
 }
 
-// NewMysqlAuthorRepository will create an implementation of author.Repository
-func NewUserUseCase(UserRepo repository.UserRepository) UserUseCase {
+// NewMysqlAuthorRepository will create an implementation of author.Repository.
+// Directory may be nil when only local passwords are used.
+func NewUserUseCase(UserRepo repository.UserRepository, MfaRepo repository.MfaRepository, Directory domain.Directory) UserUseCase {
 	return &UserUseCaseImpl{
-		UserRepo: UserRepo,
+		UserRepo:  UserRepo,
+		MfaRepo:   MfaRepo,
+		Directory: Directory,
 	}
 }
 
-func (uc *UserUseCaseImpl) Login(ctx context.Context, login *domain.LoginValidation) (user *domain.User, uuidGen string, err error) {
+func (uc *UserUseCaseImpl) Login(ctx context.Context, login *domain.LoginValidation) (user *domain.User, uuidGen string, mfaToken string, err error) {
 	usernameCheck, _ := uc.UserRepo.GetOneByUsername(ctx, login.Username)
 
-	if usernameCheck == nil {
-		return nil, "", errors.New("username / password salah")
+	// Local users keep their password here, everyone else is looked up in
+	// the directory
+	if usernameCheck != nil && usernameCheck.AuthSource != domain.AuthSourceLDAP {
+		passwordCheck, _ := helper.ComparePasswordAndHash(login.Password, usernameCheck.Password)
+
+		if !passwordCheck {
+			return nil, "", "", errors.New("username / password salah")
+		}
+	} else if uc.Directory != nil {
+		usernameCheck, err = uc.directoryLogin(ctx, login)
+
+		if err != nil {
+			return nil, "", "", err
+		}
+	} else {
+		return nil, "", "", errors.New("username / password salah")
 	}
 
-	passwordCheck, _ := helper.ComparePasswordAndHash(login.Password, usernameCheck.Password)
+	if os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true" && usernameCheck.EmailVerifiedAt == nil {
+		return nil, "", "", errors.New("email belum diverifikasi")
+	}
 
-	if !passwordCheck {
-		return nil, "", errors.New("username / password salah")
+	uuidGenerate, mfaToken, err := startSession(ctx, uc.UserRepo, uc.MfaRepo, usernameCheck)
+
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	if mfaToken != "" {
+		return nil, "", mfaToken, nil
+	}
+
+	// uc.UserRepo.Publish(ctx, "test")
+
+	return usernameCheck, uuidGenerate, "", nil
+}
+
+// directoryLogin checks a password against the directory, then creates or
+// updates the local row of the directory user
+func (uc *UserUseCaseImpl) directoryLogin(ctx context.Context, login *domain.LoginValidation) (*domain.User, error) {
+	entry, err := uc.Directory.Authenticate(ctx, login.Username, login.Password)
+
+	if errors.Is(err, domain.ErrDirectoryUserNotFound) || errors.Is(err, domain.ErrDirectoryInvalidCredentials) {
+		return nil, errors.New("username / password salah")
+	}
+
+	if err != nil {
+		log.Printf("Directory login failed: %s", err)
+		return nil, errors.New("direktori tidak dapat dihubungi")
+	}
+
+	if entry.Username == "" {
+		entry.Username = login.Username
+	}
+
+	directoryUser := &domain.User{
+		Email:      entry.Email,
+		Name:       entry.Name,
+		Username:   entry.Username,
+		AuthSource: domain.AuthSourceLDAP,
+		Groups:     entry.Groups,
+	}
+
+	user, _ := uc.UserRepo.GetOneByUsername(ctx, entry.Username)
+
+	if user != nil && user.AuthSource != domain.AuthSourceLDAP {
+		return nil, errors.New("username telah terdaftar sebagai pengguna lokal")
+	}
+
+	// The address may already belong to somebody else, a local user or
+	// another directory entry
+	if entry.Email != "" {
+		owner, err := uc.UserRepo.GetOneByEmail(ctx, entry.Email)
+
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+
+		if owner != nil && (user == nil || owner.ID != user.ID) {
+			return nil, errors.New("email telah terdaftar oleh pengguna lain")
+		}
+	}
+
+	if user != nil {
+		return uc.UserRepo.SyncDirectoryUser(ctx, user.ID, directoryUser)
+	}
+
+	directoryUser.Password, err = unusablePasswordHash()
+
+	if err != nil {
+		return nil, err
+	}
+
+	user, err = uc.UserRepo.Insert(ctx, directoryUser)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The directory owns the address, there is nothing for us to verify
+	if user.Email != "" {
+		err = uc.UserRepo.MarkEmailVerified(ctx, user.ID)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return uc.UserRepo.GetOneByID(ctx, user.ID)
+}
+
+// unusablePasswordPrefix marks the hash of a password nobody knows
+const unusablePasswordPrefix = "!"
+
+// unusablePasswordHash hashes a random password nobody knows, for users who
+// sign in somewhere else. It is marked so it can be told from a real one.
+func unusablePasswordHash() (string, error) {
+	password, err := helper.RandomToken(helper.DefaultTokenLength)
+
+	if err != nil {
+		return "", err
+	}
+
+	hash, err := helper.CreateHash(password, helper.DefaultParams)
+
+	if err != nil {
+		return "", err
+	}
+
+	return unusablePasswordPrefix + hash, nil
+}
+
+// hasUsablePassword reports whether a user ever chose a password of ours
+func hasUsablePassword(user *domain.User) bool {
+	return !strings.HasPrefix(user.Password, unusablePasswordPrefix)
+}
+
+// startSession remembers a new session for a user who passed the first
+// factor. With a second factor enrolled the user only earns a short-lived
+// MFA challenge instead, the session is then created by LoginMfa.
+func startSession(ctx context.Context, userRepo repository.UserRepository, mfaRepo repository.MfaRepository, user *domain.User) (uuidGen string, mfaToken string, err error) {
+	totp, _ := mfaRepo.GetTOTP(ctx, user.ID)
+
+	if totp != nil && totp.ConfirmedAt != nil {
+		mfaToken, err = helper.RandomToken(helper.DefaultTokenLength)
+
+		if err != nil {
+			return "", "", err
+		}
+
+		challengeMinuteExpire := os.Getenv("MFA_CHALLENGE_EXPIRE_MINUTE")
+		convChallengeMinute, _ := strconv.Atoi(challengeMinuteExpire)
+		if convChallengeMinute <= 0 {
+			convChallengeMinute = defaultMfaChallengeExpireMinute
+		}
+
+		err = mfaRepo.RememberChallenge(ctx, mfaToken, user.ID, time.Minute*time.Duration(convChallengeMinute))
+
+		if err != nil {
+			return "", "", err
+		}
+
+		return "", mfaToken, nil
 	}
 
 	uuidGenerate := uuid.NewString()
 
-	rememberSession := uc.UserRepo.RememberUUID(ctx, usernameCheck, uuidGenerate)
+	rememberSession := userRepo.RememberUUID(ctx, user, uuidGenerate)
 
 	if rememberSession != nil {
-		return nil, "", rememberSession
+		return "", "", rememberSession
 	}
 
-	// uc.UserRepo.Publish(ctx, "test")
-
-	return usernameCheck, uuidGenerate, nil
+	return uuidGenerate, "", nil
 }
 
 func (uc *UserUseCaseImpl) Register(context context.Context, register *domain.RegisterValidation) (user *domain.User, uuidGen string, err error) {
@@ -86,11 +289,18 @@ func (uc *UserUseCaseImpl) Register(context context.Context, register *domain.Re
 		return nil, "", err
 	}
 
+	verification, err := uc.verificationData(context, user)
+
+	if err != nil {
+		return nil, "", err
+	}
+
 	mail := &domain.Message{
 		To:      user.Email,
 		From:    "admin@email.com",
 		Subject: user.Username + ", Your account is registered",
-		Data:    "Hi, " + userInput.Name + ". Your account is registered. Please Login",
+		Data:    "Hi, " + userInput.Name + ". Your account is registered. Please verify your email and Login",
+		DataMap: verification,
 		Uuid:    uuidGenerate,
 	}
 
@@ -98,6 +308,11 @@ func (uc *UserUseCaseImpl) Register(context context.Context, register *domain.Re
 
 	uc.UserRepo.Publish(context, string(b), "mail")
 
+	// No session until the address is verified, login would refuse one too
+	if os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true" {
+		return user, "", nil
+	}
+
 	rememberSession := uc.UserRepo.RememberUUID(context, user, uuidGenerate)
 
 	if rememberSession != nil {
@@ -137,6 +352,62 @@ func (uc *UserUseCaseImpl) CheckUsername(ctx context.Context, username string) (
 func (uc *UserUseCaseImpl) Logout(ctx context.Context, uuid string) {
 	uc.UserRepo.DeleteUUID(ctx, uuid)
 
+	uc.endSession(ctx, uuid, 0)
+}
+
+// LogoutAll ends every session of a user at once, wherever it was opened.
+// Refresh tokens are revoked too, also those whose session already expired.
+func (uc *UserUseCaseImpl) LogoutAll(ctx context.Context, userID int) (revoked []string, err error) {
+	revoked, err = uc.UserRepo.DeleteUserSessions(ctx, userID)
+
+	// Sessions deleted before a failure are gone all the same
+	for _, uuid := range revoked {
+		uc.endSession(ctx, uuid, 0)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = uc.UserRepo.DeleteUserRefreshFamilies(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	if revoked == nil {
+		revoked = []string{}
+	}
+
+	return revoked, nil
+}
+
+// AdminLogoutAll is LogoutAll for an admin signing out someone else
+func (uc *UserUseCaseImpl) AdminLogoutAll(ctx context.Context, admin *domain.User, userID int) (revoked []string, err error) {
+	if err = uc.RequireAdmin(ctx, admin); err != nil {
+		return nil, err
+	}
+
+	if _, err = uc.UserRepo.GetOneByID(ctx, userID); errors.Is(err, sql.ErrNoRows) {
+		return nil, domain.ErrUserNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return uc.LogoutAll(ctx, userID)
+}
+
+// endSession cleans up after a deleted session and announces the logout. An
+// impersonated session is reported as stopped, adminID names its admin when
+// the caller knows it.
+func (uc *UserUseCaseImpl) endSession(ctx context.Context, uuid string, adminID int) {
+	uc.endImpersonation(ctx, uuid, adminID)
+
+	// Sign out the refresh token family too, otherwise the session could
+	// simply be refreshed back to life
+	family, _ := uc.UserRepo.GetRefreshFamilyBySession(ctx, uuid)
+	if family != "" {
+		uc.UserRepo.DeleteRefreshFamily(ctx, family)
+	}
+
 	authAction := domain.LogoutAction{
 		Uuid: uuid,
 	}
@@ -150,3 +421,747 @@ func (uc *UserUseCaseImpl) Logout(ctx context.Context, uuid string) {
 
 	uc.UserRepo.Publish(ctx, string(b), "auth-logout")
 }
+
+// RequireAdmin checks admin rights against the database, a session keeps the
+// user as they were when they logged in
+func (uc *UserUseCaseImpl) RequireAdmin(ctx context.Context, user *domain.User) error {
+	user, err := uc.UserRepo.GetOneByID(ctx, user.ID)
+
+	if err != nil || !user.IsAdmin {
+		return domain.ErrNotAdmin
+	}
+
+	return nil
+}
+
+// Impersonate opens a short session as another user for an admin. Other
+// admins cannot be impersonated.
+func (uc *UserUseCaseImpl) Impersonate(ctx context.Context, admin *domain.User, userID int) (user *domain.User, uuidGen string, expire time.Duration, err error) {
+	if err = uc.RequireAdmin(ctx, admin); err != nil {
+		return nil, "", 0, err
+	}
+
+	if admin.ID == userID {
+		return nil, "", 0, errors.New("tidak dapat melakukan impersonasi terhadap diri sendiri")
+	}
+
+	user, err = uc.UserRepo.GetOneByID(ctx, userID)
+
+	if err != nil {
+		return nil, "", 0, errors.New("user tidak ditemukan")
+	}
+
+	if user.IsAdmin {
+		return nil, "", 0, errors.New("tidak dapat melakukan impersonasi terhadap admin")
+	}
+
+	expireMinute, _ := strconv.Atoi(os.Getenv("IMPERSONATION_EXPIRE_MINUTE"))
+	if expireMinute <= 0 {
+		expireMinute = defaultImpersonationExpireMinute
+	}
+	expire = time.Minute * time.Duration(expireMinute)
+
+	uuidGen = uuid.NewString()
+
+	action := &domain.ImpersonationAction{
+		Uuid:    uuidGen,
+		AdminID: admin.ID,
+		UserID:  user.ID,
+		Exp:     time.Now().Add(expire).Unix(),
+	}
+
+	// Tracked first, an impersonation nobody would see end must not start
+	err = uc.UserRepo.TrackImpersonation(ctx, action)
+
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	err = uc.UserRepo.RememberImpersonatedUUID(ctx, user, uuidGen, expire)
+
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	uc.publishImpersonation(ctx, "impersonation-start", action)
+
+	return user, uuidGen, expire, nil
+}
+
+// StopImpersonation ends an impersonated session, it is what logging out does
+// for them
+func (uc *UserUseCaseImpl) StopImpersonation(ctx context.Context, uuid string, adminID int) {
+	uc.UserRepo.DeleteUUID(ctx, uuid)
+
+	uc.endSession(ctx, uuid, adminID)
+}
+
+// endImpersonation reports the stop of session uuid if it is a running
+// impersonation. When the running list cannot be read, the stop is still
+// reported if adminID names the admin.
+func (uc *UserUseCaseImpl) endImpersonation(ctx context.Context, uuid string, adminID int) {
+	action, err := uc.UserRepo.EndImpersonation(ctx, uuid)
+
+	// Not an impersonation, or already reported as run out
+	if err == nil && action == nil {
+		return
+	}
+
+	if action == nil {
+		if adminID == 0 {
+			log.Printf("Ending impersonation %s failed: %s", uuid, err)
+			return
+		}
+
+		action = &domain.ImpersonationAction{
+			Uuid:    uuid,
+			AdminID: adminID,
+		}
+	}
+
+	action.Exp = time.Now().Unix()
+
+	uc.publishImpersonation(ctx, "impersonation-stop", action)
+}
+
+// RunImpersonationAudit reports the stop of impersonations that simply ran
+// out until ctx is done, whichever instance gets there first does it
+func (uc *UserUseCaseImpl) RunImpersonationAudit(ctx context.Context) {
+	ticker := time.NewTicker(impersonationAuditInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		actions, err := uc.UserRepo.ClaimExpiredImpersonations(ctx, time.Now())
+
+		if err != nil {
+			log.Printf("Looking for expired impersonations failed: %s", err)
+			continue
+		}
+
+		for _, action := range actions {
+			uc.publishImpersonation(ctx, "impersonation-stop", action)
+		}
+	}
+}
+
+func (uc *UserUseCaseImpl) publishImpersonation(ctx context.Context, action string, impersonation *domain.ImpersonationAction) {
+	publishImpersonation := &domain.PublishImpersonation{
+		Action: action,
+		Data:   *impersonation,
+	}
+
+	b, _ := json.Marshal(publishImpersonation)
+
+	uc.UserRepo.Publish(ctx, string(b), "auth-impersonation")
+}
+
+// Revoke signs out the session behind an access or refresh token, see RFC
+// 7009. Tokens issued to another client than clientID are left alone, first
+// party tokens have an empty clientID. Unknown tokens are silently ignored.
+func (uc *UserUseCaseImpl) Revoke(ctx context.Context, token string, tokenTypeHint string, clientID string) {
+	// The hint only decides which lookup goes first
+	if tokenTypeHint == "refresh_token" {
+		if !uc.revokeRefreshToken(ctx, token, clientID) {
+			uc.revokeAccessToken(ctx, token, clientID)
+		}
+		return
+	}
+
+	if !uc.revokeAccessToken(ctx, token, clientID) {
+		uc.revokeRefreshToken(ctx, token, clientID)
+	}
+}
+
+func (uc *UserUseCaseImpl) revokeAccessToken(ctx context.Context, token string, clientID string) bool {
+	claims, err := utils.ParseToken(token)
+
+	if err != nil || claims.Uuid == "" {
+		return false
+	}
+
+	if claims.ClientID == clientID && claims.Act != nil {
+		adminID, _ := strconv.Atoi(claims.Act.Subject)
+		uc.StopImpersonation(ctx, claims.Uuid, adminID)
+	} else if claims.ClientID == clientID {
+		uc.Logout(ctx, claims.Uuid)
+	}
+
+	return true
+}
+
+func (uc *UserUseCaseImpl) revokeRefreshToken(ctx context.Context, token string, clientID string) bool {
+	family, tokenClientID, err := uc.UserRepo.GetRefreshToken(ctx, token)
+
+	if err != nil {
+		return false
+	}
+
+	if tokenClientID != clientID {
+		return true
+	}
+
+	// Logging out the session deletes its refresh token family as well
+	refreshFamily, err := uc.UserRepo.GetRefreshFamily(ctx, family)
+
+	if err == nil && !refreshFamily.Revoked {
+		uc.Logout(ctx, refreshFamily.Uuid)
+	}
+
+	return true
+}
+
+func (uc *UserUseCaseImpl) IssueRefreshToken(ctx context.Context, user *domain.User, uuidGen string) (refreshToken string, err error) {
+	return uc.IssueClientRefreshToken(ctx, user, uuidGen, "", "")
+}
+
+// IssueClientRefreshToken starts a refresh token family for a session. Tokens
+// of a family can only be refreshed by the client they were issued to, first
+// party logins use an empty clientID.
+func (uc *UserUseCaseImpl) IssueClientRefreshToken(ctx context.Context, user *domain.User, uuidGen string, clientID string, scope string) (refreshToken string, err error) {
+	refreshToken, err = helper.RandomToken(helper.DefaultTokenLength)
+
+	if err != nil {
+		return "", err
+	}
+
+	family := &domain.RefreshFamily{
+		ID:       uuid.NewString(),
+		Uuid:     uuidGen,
+		UserID:   user.ID,
+		ClientID: clientID,
+		Scope:    scope,
+	}
+
+	err = uc.UserRepo.RememberRefreshToken(ctx, refreshToken, family)
+
+	if err != nil {
+		return "", err
+	}
+
+	return refreshToken, nil
+}
+
+func (uc *UserUseCaseImpl) Refresh(ctx context.Context, refreshToken string, clientID string) (user *domain.User, uuidGen string, newRefreshToken string, scope string, err error) {
+	familyID, reused, err := uc.UserRepo.UseRefreshToken(ctx, refreshToken, clientID)
+
+	if err != nil {
+		return nil, "", "", "", errors.New("refresh token tidak valid")
+	}
+
+	// A revoked or expired family invalidates every token it ever issued
+	family, err := uc.UserRepo.GetRefreshFamily(ctx, familyID)
+
+	if err != nil {
+		return nil, "", "", "", errors.New("refresh token tidak valid")
+	}
+
+	// A token of a family that was logged out or caught being replayed is
+	// just as suspicious as a reused one
+	if family.Revoked {
+		uc.publishRefreshReuse(ctx, family)
+
+		return nil, "", "", "", errors.New("refresh token telah dicabut")
+	}
+
+	// The token was rotated already, so somebody is replaying it. We cannot
+	// tell the thief from the owner, so revoke the whole family
+	if reused {
+		// Logging out the session deletes the family as well
+		uc.Logout(ctx, family.Uuid)
+
+		uc.publishRefreshReuse(ctx, family)
+
+		return nil, "", "", "", errors.New("refresh token telah digunakan")
+	}
+
+	user, err = uc.UserRepo.GetOneByID(ctx, family.UserID)
+
+	if err != nil {
+		return nil, "", "", "", errors.New("refresh token tidak valid")
+	}
+
+	newRefreshToken, err = helper.RandomToken(helper.DefaultTokenLength)
+
+	if err != nil {
+		return nil, "", "", "", err
+	}
+
+	uuidGenerate := uuid.NewString()
+	previousUuid := family.Uuid
+	family.Uuid = uuidGenerate
+
+	// Rotate: the family now backs the new session and keeps its original
+	// expiry. It may have been revoked since it was read.
+	err = uc.UserRepo.RotateRefreshToken(ctx, newRefreshToken, family)
+
+	if err != nil {
+		return nil, "", "", "", errors.New("refresh token tidak valid")
+	}
+
+	rememberSession := uc.UserRepo.RememberUUID(ctx, user, uuidGenerate)
+
+	if rememberSession != nil {
+		return nil, "", "", "", rememberSession
+	}
+
+	// The previous session goes away
+	uc.UserRepo.DeleteUUID(ctx, previousUuid)
+
+	return user, uuidGenerate, newRefreshToken, family.Scope, nil
+}
+
+func (uc *UserUseCaseImpl) publishRefreshReuse(ctx context.Context, family *domain.RefreshFamily) {
+	publishReuse := &domain.PublishAuthRefreshReuse{
+		Action: "refresh-reuse",
+		Data: domain.RefreshReuseAction{
+			Family: family.ID,
+			Uuid:   family.Uuid,
+			UserID: family.UserID,
+		},
+	}
+
+	b, _ := json.Marshal(publishReuse)
+
+	uc.UserRepo.Publish(ctx, string(b), "auth-refresh-reuse")
+}
+
+// verificationData mints a single-use email verification token for the user
+// and returns the mail payload that carries it.
+func (uc *UserUseCaseImpl) verificationData(ctx context.Context, user *domain.User) (data map[string]any, err error) {
+	verifyHourExpire := os.Getenv("EMAIL_VERIFICATION_EXPIRE_HOUR")
+	convVerifyHour, _ := strconv.Atoi(verifyHourExpire)
+	if convVerifyHour <= 0 {
+		convVerifyHour = defaultEmailVerificationExpireHour
+	}
+	expire := time.Hour * time.Duration(convVerifyHour)
+
+	token, jti, err := utils.GenerateActionToken("email-verification", user.ID, expire)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = uc.UserRepo.RememberToken(ctx, "email-verification:"+jti, strconv.Itoa(user.ID), expire)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"verification_token": token,
+		"verification_url":   frontendURL("/verify-email", token),
+	}, nil
+}
+
+// frontendURL is the page a mailed link opens. The page reads the token from
+// the query and posts it to the API, the API only accepts it in a POST.
+func frontendURL(path string, token string) string {
+	base := os.Getenv("FRONTEND_URL")
+	if base == "" {
+		base = os.Getenv("APPLICATION_URL")
+	}
+	return strings.TrimRight(base, "/") + path + "?token=" + url.QueryEscape(token)
+}
+
+func (uc *UserUseCaseImpl) VerifyEmail(ctx context.Context, token string) error {
+	claims, err := utils.ParseActionToken(token, "email-verification")
+
+	if err != nil {
+		return errors.New("token verifikasi tidak valid")
+	}
+
+	// Single use, the key is gone as soon as it is read
+	userID, err := uc.UserRepo.ConsumeToken(ctx, "email-verification:"+claims.ID)
+
+	if err != nil || userID != claims.Subject {
+		return errors.New("token verifikasi tidak valid")
+	}
+
+	id, _ := strconv.Atoi(userID)
+
+	return uc.UserRepo.MarkEmailVerified(ctx, id)
+}
+
+func (uc *UserUseCaseImpl) ResendVerification(ctx context.Context, email string) error {
+	user, _ := uc.UserRepo.GetOneByEmail(ctx, email)
+
+	// Stay silent about unknown or already verified addresses, so the
+	// endpoint cannot be used to probe for accounts
+	if user == nil || user.EmailVerifiedAt != nil {
+		return nil
+	}
+
+	verification, err := uc.verificationData(ctx, user)
+
+	if err != nil {
+		return err
+	}
+
+	mail := &domain.Message{
+		To:      user.Email,
+		From:    "admin@email.com",
+		Subject: user.Username + ", Verify your email",
+		Data:    "Hi, " + user.Name + ". Please verify your email",
+		DataMap: verification,
+	}
+
+	b, _ := json.Marshal(mail)
+
+	uc.UserRepo.Publish(ctx, string(b), "mail")
+
+	return nil
+}
+
+func (uc *UserUseCaseImpl) ForgotPassword(ctx context.Context, email string) error {
+	user, _ := uc.UserRepo.GetOneByEmail(ctx, email)
+
+	// Unknown addresses get the very same answer as known ones, and so do
+	// directory users, whose password is not ours to reset
+	if user == nil || user.AuthSource == domain.AuthSourceLDAP {
+		return nil
+	}
+
+	resetMinuteExpire := os.Getenv("PASSWORD_RESET_EXPIRE_MINUTE")
+	convResetMinute, _ := strconv.Atoi(resetMinuteExpire)
+	if convResetMinute <= 0 {
+		convResetMinute = defaultPasswordResetExpireMinute
+	}
+	expire := time.Minute * time.Duration(convResetMinute)
+
+	token, jti, err := utils.GenerateActionToken("password-reset", user.ID, expire)
+
+	if err != nil {
+		return err
+	}
+
+	err = uc.UserRepo.RememberToken(ctx, "password-reset:"+jti, strconv.Itoa(user.ID), expire)
+
+	if err != nil {
+		return err
+	}
+
+	mail := &domain.Message{
+		To:      user.Email,
+		From:    "admin@email.com",
+		Subject: user.Username + ", Reset your password",
+		Data:    "Hi, " + user.Name + ". Use the link below to reset your password. Ignore this email if you did not ask for it",
+		DataMap: map[string]any{
+			"reset_token": token,
+			"reset_url":   frontendURL("/password/reset", token),
+		},
+	}
+
+	b, _ := json.Marshal(mail)
+
+	uc.UserRepo.Publish(ctx, string(b), "mail")
+
+	return nil
+}
+
+func (uc *UserUseCaseImpl) ResetPassword(ctx context.Context, reset *domain.ResetPasswordValidation) error {
+	claims, err := utils.ParseActionToken(reset.Token, "password-reset")
+
+	if err != nil {
+		return errors.New("token reset password tidak valid")
+	}
+
+	// Check the policy before the token is spent, so a rejected password
+	// can simply be retried
+	err = utils.CheckPasswordPolicy(reset.Password)
+
+	if err != nil {
+		return err
+	}
+
+	// Single use, the key is gone as soon as it is read
+	userID, err := uc.UserRepo.ConsumeToken(ctx, "password-reset:"+claims.ID)
+
+	if err != nil || userID != claims.Subject {
+		return errors.New("token reset password tidak valid")
+	}
+
+	id, _ := strconv.Atoi(userID)
+
+	user, err := uc.UserRepo.GetOneByID(ctx, id)
+
+	if err != nil {
+		return errors.New("token reset password tidak valid")
+	}
+
+	user.Password, err = helper.CreateHash(reset.Password, helper.DefaultParams)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = uc.UserRepo.Update(ctx, id, user)
+
+	if err != nil {
+		return err
+	}
+
+	// Whoever knew the old password must not stay signed in, nor keep a
+	// refresh token to sign in again with
+	sessions, err := uc.LogoutAll(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	publishReset := &domain.PublishAuthPasswordReset{
+		Action: "password-reset",
+		Data: domain.PasswordResetAction{
+			UserID:   id,
+			Sessions: sessions,
+		},
+	}
+
+	b, _ := json.Marshal(publishReset)
+
+	uc.UserRepo.Publish(ctx, string(b), "auth-password-reset")
+
+	return nil
+}
+
+func (uc *UserUseCaseImpl) ChangePassword(ctx context.Context, userID int, uuid string, change *domain.ChangePasswordValidation) error {
+	user, err := uc.UserRepo.GetOneByID(ctx, userID)
+
+	if err != nil {
+		return err
+	}
+
+	if user.AuthSource == domain.AuthSourceLDAP {
+		return errors.New("password dikelola oleh direktori")
+	}
+
+	passwordCheck, _ := helper.ComparePasswordAndHash(change.CurrentPassword, user.Password)
+
+	if !passwordCheck {
+		return errors.New("password lama salah")
+	}
+
+	if change.CurrentPassword == change.NewPassword {
+		return errors.New("password baru tidak boleh sama dengan password lama")
+	}
+
+	err = utils.CheckPasswordPolicy(change.NewPassword)
+
+	if err != nil {
+		return err
+	}
+
+	user.Password, err = helper.CreateHash(change.NewPassword, helper.DefaultParams)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = uc.UserRepo.Update(ctx, userID, user)
+
+	if err != nil {
+		return err
+	}
+
+	if change.RevokeOtherSessions {
+		_, err = uc.revokeSessions(ctx, userID, uuid)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	mail := &domain.Message{
+		To:      user.Email,
+		From:    "admin@email.com",
+		Subject: user.Username + ", Your password was changed",
+		Data:    "Hi, " + user.Name + ". Your password was changed. If this was not you, reset your password immediately",
+	}
+
+	b, _ := json.Marshal(mail)
+
+	uc.UserRepo.Publish(ctx, string(b), "mail")
+
+	return nil
+}
+
+func (uc *UserUseCaseImpl) RequestMagicLink(ctx context.Context, email string) (nonce string, err error) {
+	// Every caller gets a nonce, known address or not
+	nonce, err = helper.RandomToken(helper.DefaultTokenLength)
+
+	if err != nil {
+		return "", err
+	}
+
+	user, _ := uc.UserRepo.GetOneByEmail(ctx, email)
+
+	if user == nil {
+		return nonce, nil
+	}
+
+	token, err := helper.RandomToken(helper.DefaultTokenLength)
+
+	if err != nil {
+		return "", err
+	}
+
+	magicMinuteExpire := os.Getenv("MAGIC_LINK_EXPIRE_MINUTE")
+	convMagicMinute, _ := strconv.Atoi(magicMinuteExpire)
+	if convMagicMinute <= 0 {
+		convMagicMinute = defaultMagicLinkExpireMinute
+	}
+
+	magicLink, _ := json.Marshal(&domain.MagicLink{
+		UserID: user.ID,
+		Nonce:  helper.HashToken(nonce),
+	})
+
+	err = uc.UserRepo.RememberToken(ctx, "magic-link:"+helper.HashToken(token), string(magicLink), time.Minute*time.Duration(convMagicMinute))
+
+	if err != nil {
+		return "", err
+	}
+
+	mail := &domain.Message{
+		To:      user.Email,
+		From:    "admin@email.com",
+		Subject: user.Username + ", Your sign-in link",
+		Data:    "Hi, " + user.Name + ". Use the link below to sign in. It works once, in the browser you asked for it from",
+		DataMap: map[string]any{
+			"magic_link_token": token,
+			"magic_link_url":   frontendURL("/login/magic-link/consume", token),
+		},
+	}
+
+	b, _ := json.Marshal(mail)
+
+	uc.UserRepo.Publish(ctx, string(b), "mail")
+
+	return nonce, nil
+}
+
+func (uc *UserUseCaseImpl) ConsumeMagicLink(ctx context.Context, consume *domain.ConsumeMagicLinkValidation) (user *domain.User, uuidGen string, mfaToken string, err error) {
+	// Single use, the link is gone as soon as it is read, even when the
+	// nonce below turns out wrong
+	res, err := uc.UserRepo.ConsumeToken(ctx, "magic-link:"+helper.HashToken(consume.Token))
+
+	if err != nil {
+		return nil, "", "", errors.New("link login tidak valid atau kedaluwarsa")
+	}
+
+	magicLink := &domain.MagicLink{}
+	json.Unmarshal([]byte(res), magicLink)
+
+	if consume.Nonce == "" || helper.HashToken(consume.Nonce) != magicLink.Nonce {
+		return nil, "", "", errors.New("link login harus dibuka di browser yang memintanya")
+	}
+
+	user, err = uc.UserRepo.GetOneByID(ctx, magicLink.UserID)
+
+	if err != nil {
+		return nil, "", "", errors.New("link login tidak valid atau kedaluwarsa")
+	}
+
+	// Following the link proves the address is theirs
+	if user.EmailVerifiedAt == nil {
+		err = uc.UserRepo.MarkEmailVerified(ctx, user.ID)
+
+		if err != nil {
+			return nil, "", "", err
+		}
+
+		user, err = uc.UserRepo.GetOneByID(ctx, magicLink.UserID)
+
+		if err != nil {
+			return nil, "", "", err
+		}
+	}
+
+	uuidGenerate, mfaToken, err := startSession(ctx, uc.UserRepo, uc.MfaRepo, user)
+
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	if mfaToken != "" {
+		return nil, "", mfaToken, nil
+	}
+
+	return user, uuidGenerate, "", nil
+}
+
+// ListSessions lists the active sessions of a user, most recently seen first.
+// current is the session asking.
+func (uc *UserUseCaseImpl) ListSessions(ctx context.Context, userID int, current string) ([]*domain.Session, error) {
+	sessions, err := uc.UserRepo.GetUserSessions(ctx, userID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if sessions == nil {
+		sessions = []*domain.Session{}
+	}
+
+	for _, session := range sessions {
+		session.Current = session.Uuid == current
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+
+	return sessions, nil
+}
+
+// RevokeSession logs out one session of a user, sessions of others are not
+// found
+func (uc *UserUseCaseImpl) RevokeSession(ctx context.Context, userID int, uuid string) error {
+	sessions, err := uc.UserRepo.GetUserSessions(ctx, userID)
+
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		if session.Uuid == uuid {
+			uc.Logout(ctx, uuid)
+			return nil
+		}
+	}
+
+	return domain.ErrSessionNotFound
+}
+
+// TouchSession keeps the last seen time of a session current. Failing to is
+// not worth failing the request over.
+func (uc *UserUseCaseImpl) TouchSession(ctx context.Context, userID int, uuid string, ip string) {
+	uc.UserRepo.TouchSession(ctx, userID, uuid, ip)
+}
+
+// revokeSessions logs out every indexed session of a user except the one
+// given, and returns the uuids it revoked.
+func (uc *UserUseCaseImpl) revokeSessions(ctx context.Context, userID int, except string) (revoked []string, err error) {
+	sessions, err := uc.UserRepo.GetUserSessions(ctx, userID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	revoked = []string{}
+	for _, session := range sessions {
+		if session.Uuid == except {
+			continue
+		}
+
+		uc.Logout(ctx, session.Uuid)
+		revoked = append(revoked, session.Uuid)
+	}
+
+	return revoked, nil
+}
@@ -0,0 +1,274 @@
+package usecase
+
+import (
+	"auth/internal/domain"
+	"auth/internal/repository"
+	"auth/internal/utils"
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// redisUserRepository keeps sessions and tokens in miniredis and users in a
+// map, and records what is published instead of sending it to Kafka
+type redisUserRepository struct {
+	*repository.UserRepositoryImpl
+	users     map[int]*domain.User
+	published map[string][]string
+}
+
+func newRedisUserRepository(t *testing.T, users ...*domain.User) (*redisUserRepository, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	repo := &redisUserRepository{
+		UserRepositoryImpl: &repository.UserRepositoryImpl{Redis: client},
+		users:              map[int]*domain.User{},
+		published:          map[string][]string{},
+	}
+	for _, user := range users {
+		repo.users[user.ID] = user
+	}
+
+	return repo, server
+}
+
+func (r *redisUserRepository) GetOneByID(ctx context.Context, id int) (*domain.User, error) {
+	if user, ok := r.users[id]; ok {
+		copied := *user
+		return &copied, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (r *redisUserRepository) Update(ctx context.Context, id int, update *domain.User) (*domain.User, error) {
+	r.users[id] = update
+	return update, nil
+}
+
+// RememberUUID keeps the session without announcing the login, which would
+// need Kafka
+func (r *redisUserRepository) RememberUUID(ctx context.Context, user *domain.User, uuid string) error {
+	return r.RememberImpersonatedUUID(ctx, user, uuid, time.Hour)
+}
+
+func (r *redisUserRepository) Publish(ctx context.Context, data string, topic string) error {
+	r.published[topic] = append(r.published[topic], data)
+	return nil
+}
+
+// sessionUserRepository knows a few users and fails to revoke sessions with
+// sessionErr
+type sessionUserRepository struct {
+	repository.UserRepository
+	users      map[int]*domain.User
+	sessionErr error
+}
+
+func (r *sessionUserRepository) GetOneByID(ctx context.Context, id int) (*domain.User, error) {
+	if user, ok := r.users[id]; ok {
+		return user, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (r *sessionUserRepository) DeleteUserSessions(ctx context.Context, userID int) ([]string, error) {
+	return nil, r.sessionErr
+}
+
+func (r *sessionUserRepository) DeleteUserRefreshFamilies(ctx context.Context, userID int) ([]string, error) {
+	return nil, nil
+}
+
+func TestAdminLogoutAllErrors(t *testing.T) {
+	storeDown := errors.New("connection refused")
+
+	tests := []struct {
+		name  string
+		admin int
+		user  int
+		repo  *sessionUserRepository
+		want  error
+	}{
+		{"not an admin", 2, 1, &sessionUserRepository{}, domain.ErrNotAdmin},
+		{"unknown user", 1, 3, &sessionUserRepository{}, domain.ErrUserNotFound},
+		{"store down", 1, 2, &sessionUserRepository{sessionErr: storeDown}, storeDown},
+		{"revoked", 1, 2, &sessionUserRepository{}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.repo.users = map[int]*domain.User{1: {ID: 1, IsAdmin: true}, 2: {ID: 2}}
+			uc := NewUserUseCase(tt.repo, nil, nil)
+
+			revoked, err := uc.AdminLogoutAll(context.Background(), &domain.User{ID: tt.admin}, tt.user)
+
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, err)
+			}
+			if err == nil && revoked == nil {
+				t.Error("expected an empty list of revoked sessions")
+			}
+		})
+	}
+}
+
+func TestResetPasswordRevokesRefreshTokens(t *testing.T) {
+	t.Setenv("JWT_KEY", "secret")
+
+	repo, server := newRedisUserRepository(t, &domain.User{ID: 1})
+	uc := NewUserUseCase(repo, nil, nil)
+	ctx := context.Background()
+
+	if err := repo.RememberImpersonatedUUID(ctx, &domain.User{ID: 1}, "live", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	refreshToken, err := uc.IssueRefreshToken(ctx, &domain.User{ID: 1}, "expired")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, jti, _ := utils.GenerateActionToken("password-reset", 1, time.Hour)
+	repo.RememberToken(ctx, "password-reset:"+jti, "1", time.Hour)
+
+	if err := uc.ResetPassword(ctx, &domain.ResetPasswordValidation{Token: token, Password: "n3w-passw0rd"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if server.Exists("live") {
+		t.Error("expected the session to be revoked")
+	}
+	if _, _, _, _, err := uc.Refresh(ctx, refreshToken, ""); err == nil {
+		t.Error("expected a refresh token of an expired session to be revoked too")
+	}
+	if len(repo.published["auth-password-reset"]) != 1 {
+		t.Error("expected the reset to be published")
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	t.Setenv("JWT_KEY", "secret")
+	t.Setenv("JWT_EXPIRE_HOUR", "1")
+
+	tests := []struct {
+		name     string
+		token    string
+		hint     string
+		clientID string
+		revoked  bool
+	}{
+		{"access token", "access", "", "app", true},
+		{"access token hinted as refresh token", "access", "refresh_token", "app", true},
+		{"access token of another client", "access", "", "other", false},
+		{"refresh token", "refresh", "refresh_token", "app", true},
+		{"refresh token hinted as access token", "refresh", "access_token", "app", true},
+		{"refresh token of another client", "refresh", "refresh_token", "other", false},
+		{"unknown token", "unknown", "", "app", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &domain.User{ID: 1}
+			repo, server := newRedisUserRepository(t, user)
+			uc := NewUserUseCase(repo, nil, nil)
+			ctx := context.Background()
+
+			if err := repo.RememberUUID(ctx, user, "session"); err != nil {
+				t.Fatal(err)
+			}
+			accessToken, err := utils.GenerateScopedToken(ctx, user, "session", "app", "openid")
+			if err != nil {
+				t.Fatal(err)
+			}
+			refreshToken, err := uc.IssueClientRefreshToken(ctx, user, "session", "app", "openid")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tokens := map[string]string{"access": accessToken.(string), "refresh": refreshToken, "unknown": "unknown"}
+			uc.Revoke(ctx, tokens[tt.token], tt.hint, tt.clientID)
+
+			if tt.revoked == server.Exists("session") {
+				t.Errorf("expected the session revoked %t", tt.revoked)
+			}
+			if _, _, _, _, err := uc.Refresh(ctx, refreshToken, "app"); tt.revoked != (err != nil) {
+				t.Errorf("expected the refresh token revoked %t, got %v", tt.revoked, err)
+			}
+			if tt.revoked != (len(repo.published["auth-logout"]) == 1) {
+				t.Errorf("expected the logout published %t", tt.revoked)
+			}
+		})
+	}
+}
+
+func TestRevokingAnImpersonationReportsItsStop(t *testing.T) {
+	tests := []struct {
+		name   string
+		revoke func(uc UserUseCase, uuid string) error
+	}{
+		{"revoke session", func(uc UserUseCase, uuid string) error {
+			return uc.RevokeSession(context.Background(), 2, uuid)
+		}},
+		{"logout all", func(uc UserUseCase, uuid string) error {
+			_, err := uc.LogoutAll(context.Background(), 2)
+			return err
+		}},
+		{"stop impersonation", func(uc UserUseCase, uuid string) error {
+			uc.StopImpersonation(context.Background(), uuid, 1)
+			return nil
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			admin := &domain.User{ID: 1, IsAdmin: true}
+			repo, server := newRedisUserRepository(t, admin, &domain.User{ID: 2})
+			uc := NewUserUseCase(repo, nil, nil)
+			ctx := context.Background()
+
+			_, uuid, _, err := uc.Impersonate(ctx, admin, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := tt.revoke(uc, uuid); err != nil {
+				t.Fatal(err)
+			}
+
+			if server.Exists(uuid) {
+				t.Error("expected the session to be revoked")
+			}
+			if published := repo.published["auth-impersonation"]; len(published) != 2 || !strings.Contains(published[1], `"impersonation-stop"`) {
+				t.Errorf("expected the start and the stop to be published once, got %v", published)
+			}
+			if actions, _ := repo.ClaimExpiredImpersonations(ctx, time.Now().Add(24*time.Hour)); len(actions) != 0 {
+				t.Error("expected the impersonation to be off the running list")
+			}
+		})
+	}
+}
+
+func TestLogoutOfAnOrdinarySessionReportsNoImpersonation(t *testing.T) {
+	repo, _ := newRedisUserRepository(t, &domain.User{ID: 1})
+	uc := NewUserUseCase(repo, nil, nil)
+	ctx := context.Background()
+
+	if err := repo.RememberUUID(ctx, &domain.User{ID: 1}, "session"); err != nil {
+		t.Fatal(err)
+	}
+
+	uc.Logout(ctx, "session")
+
+	if len(repo.published["auth-impersonation"]) != 0 {
+		t.Error("expected no impersonation to be reported")
+	}
+}
@@ -0,0 +1,397 @@
+package usecase
+
+import (
+	"auth/internal/domain"
+	"auth/internal/helper"
+	"auth/internal/repository"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/exp/slices"
+)
+
+// WebauthnUseCase represent the passkey's usecase contract
+type WebauthnUseCase interface {
+	BeginRegistration(ctx context.Context, user *domain.User) (*domain.WebauthnCreationOptions, error)
+	FinishRegistration(ctx context.Context, user *domain.User, registration *domain.WebauthnRegistrationValidation) (*domain.WebauthnCredential, error)
+	BeginLogin(ctx context.Context, username string) (*domain.WebauthnRequestOptions, error)
+	FinishLogin(ctx context.Context, login *domain.WebauthnLoginValidation) (user *domain.User, uuid string, err error)
+}
+
+type WebauthnUseCaseImpl struct {
+	WebauthnRepo repository.WebauthnRepository
+	UserRepo     repository.UserRepository
+}
+
+func NewWebauthnUseCase(WebauthnRepo repository.WebauthnRepository, UserRepo repository.UserRepository) WebauthnUseCase {
+	return &WebauthnUseCaseImpl{
+		WebauthnRepo: WebauthnRepo,
+		UserRepo:     UserRepo,
+	}
+}
+
+var errInvalidPasskey = errors.New("passkey tidak valid")
+
+// Used when WEBAUTHN_CHALLENGE_EXPIRE_MINUTE is not set
+const defaultWebauthnChallengeExpireMinute = 5
+
+func webauthnChallengeExpire() time.Duration {
+	challengeMinuteExpire := os.Getenv("WEBAUTHN_CHALLENGE_EXPIRE_MINUTE")
+	convChallengeMinute, _ := strconv.Atoi(challengeMinuteExpire)
+	if convChallengeMinute <= 0 {
+		convChallengeMinute = defaultWebauthnChallengeExpireMinute
+	}
+	return time.Minute * time.Duration(convChallengeMinute)
+}
+
+// webauthnDummyCredential stands in for the passkeys of a username that has
+// none. It is derived from the username with a server secret, so asking
+// twice gives the same answer and it cannot be told apart from a real one.
+func webauthnDummyCredential(username string) domain.WebauthnCredentialDescriptor {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("JWT_KEY")))
+	mac.Write([]byte("webauthn-credential:" + username))
+
+	return domain.WebauthnCredentialDescriptor{
+		Type: "public-key",
+		ID:   base64.RawURLEncoding.EncodeToString(mac.Sum(nil)),
+	}
+}
+
+func webauthnUserVerification() string {
+	if os.Getenv("WEBAUTHN_USER_VERIFICATION") == "required" {
+		return "required"
+	}
+	return "preferred"
+}
+
+// webauthnUserHandle is the opaque user handle we give authenticators
+func webauthnUserHandle(userID int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(userID)))
+}
+
+func (uc *WebauthnUseCaseImpl) BeginRegistration(ctx context.Context, user *domain.User) (*domain.WebauthnCreationOptions, error) {
+	challenge, err := helper.RandomToken(helper.DefaultTokenLength)
+
+	if err != nil {
+		return nil, err
+	}
+
+	expire := webauthnChallengeExpire()
+
+	err = uc.UserRepo.RememberToken(ctx, "webauthn-register:"+strconv.Itoa(user.ID), challenge, expire)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep authenticators from registering a second credential for the
+	// same account
+	credentials, err := uc.WebauthnRepo.GetByUserID(ctx, user.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	exclude := []domain.WebauthnCredentialDescriptor{}
+	for _, credential := range credentials {
+		exclude = append(exclude, domain.WebauthnCredentialDescriptor{
+			Type: "public-key",
+			ID:   base64.RawURLEncoding.EncodeToString(credential.CredentialID),
+		})
+	}
+
+	return &domain.WebauthnCreationOptions{
+		Challenge: challenge,
+		RP: domain.WebauthnRelyingParty{
+			ID:   os.Getenv("WEBAUTHN_RP_ID"),
+			Name: os.Getenv("WEBAUTHN_RP_NAME"),
+		},
+		User: domain.WebauthnUserEntity{
+			ID:          webauthnUserHandle(user.ID),
+			Name:        user.Username,
+			DisplayName: user.Name,
+		},
+		PubKeyCredParams: []domain.WebauthnCredentialParameter{
+			{Type: "public-key", Alg: helper.COSEAlgES256},
+			{Type: "public-key", Alg: helper.COSEAlgEdDSA},
+			{Type: "public-key", Alg: helper.COSEAlgRS256},
+		},
+		Timeout:            expire.Milliseconds(),
+		Attestation:        "none",
+		ExcludeCredentials: exclude,
+		AuthenticatorSelection: domain.WebauthnAuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: webauthnUserVerification(),
+		},
+	}, nil
+}
+
+func (uc *WebauthnUseCaseImpl) FinishRegistration(ctx context.Context, user *domain.User, registration *domain.WebauthnRegistrationValidation) (*domain.WebauthnCredential, error) {
+	clientDataJSON, err := helper.DecodeBase64URL(registration.Response.ClientDataJSON)
+
+	if err != nil {
+		return nil, errInvalidPasskey
+	}
+
+	clientData, err := helper.ParseClientData(clientDataJSON)
+
+	if err != nil || clientData.Type != "webauthn.create" {
+		return nil, errInvalidPasskey
+	}
+
+	challenge, err := uc.UserRepo.ConsumeToken(ctx, "webauthn-register:"+strconv.Itoa(user.ID))
+
+	if err != nil || challenge != clientData.Challenge {
+		return nil, errors.New("challenge passkey tidak valid atau kedaluwarsa")
+	}
+
+	if !webauthnOriginAllowed(clientData.Origin) {
+		return nil, errInvalidPasskey
+	}
+
+	attestationObject, err := helper.DecodeBase64URL(registration.Response.AttestationObject)
+
+	if err != nil {
+		return nil, errInvalidPasskey
+	}
+
+	authData, err := helper.ParseAttestationObject(attestationObject)
+
+	if err != nil || authData.CredentialID == nil {
+		return nil, errInvalidPasskey
+	}
+
+	err = checkAuthenticatorData(authData)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rawID, err := helper.DecodeBase64URL(registration.RawID)
+
+	if err != nil || !bytes.Equal(rawID, authData.CredentialID) {
+		return nil, errInvalidPasskey
+	}
+
+	// Refuse key types we could not verify assertions for later on
+	_, _, err = helper.ParseCOSEKey(authData.CredentialPublicKey)
+
+	if err != nil {
+		return nil, errors.New("tipe passkey tidak didukung")
+	}
+
+	credential, err := uc.WebauthnRepo.Insert(ctx, &domain.WebauthnCredential{
+		UserID:       user.ID,
+		Name:         registration.Name,
+		CredentialID: authData.CredentialID,
+		PublicKey:    authData.CredentialPublicKey,
+		AAGUID:       authData.AAGUID,
+		SignCount:    authData.SignCount,
+	})
+
+	if err != nil {
+		return nil, errors.New("passkey sudah terdaftar")
+	}
+
+	return credential, nil
+}
+
+func (uc *WebauthnUseCaseImpl) BeginLogin(ctx context.Context, username string) (*domain.WebauthnRequestOptions, error) {
+	challenge, err := helper.RandomToken(helper.DefaultTokenLength)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Without a username the browser offers its discoverable credentials
+	// instead. A username without passkeys, known or not, gets a made up
+	// credential so the answer does not reveal which accounts exist.
+	userID := 0
+	allow := []domain.WebauthnCredentialDescriptor{}
+
+	if username != "" {
+		user, _ := uc.UserRepo.GetOneByUsername(ctx, username)
+
+		if user != nil {
+			credentials, err := uc.WebauthnRepo.GetByUserID(ctx, user.ID)
+
+			if err != nil {
+				return nil, err
+			}
+
+			for _, credential := range credentials {
+				allow = append(allow, domain.WebauthnCredentialDescriptor{
+					Type: "public-key",
+					ID:   base64.RawURLEncoding.EncodeToString(credential.CredentialID),
+				})
+			}
+
+			if len(allow) > 0 {
+				userID = user.ID
+			}
+		}
+
+		if len(allow) == 0 {
+			allow = append(allow, webauthnDummyCredential(username))
+		}
+	}
+
+	expire := webauthnChallengeExpire()
+
+	err = uc.UserRepo.RememberToken(ctx, "webauthn-login:"+challenge, strconv.Itoa(userID), expire)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.WebauthnRequestOptions{
+		Challenge:        challenge,
+		RPID:             os.Getenv("WEBAUTHN_RP_ID"),
+		Timeout:          expire.Milliseconds(),
+		UserVerification: webauthnUserVerification(),
+		AllowCredentials: allow,
+	}, nil
+}
+
+func (uc *WebauthnUseCaseImpl) FinishLogin(ctx context.Context, login *domain.WebauthnLoginValidation) (user *domain.User, uuidGen string, err error) {
+	clientDataJSON, err := helper.DecodeBase64URL(login.Response.ClientDataJSON)
+
+	if err != nil {
+		return nil, "", errInvalidPasskey
+	}
+
+	clientData, err := helper.ParseClientData(clientDataJSON)
+
+	if err != nil || clientData.Type != "webauthn.get" {
+		return nil, "", errInvalidPasskey
+	}
+
+	expectedUserID, err := uc.UserRepo.ConsumeToken(ctx, "webauthn-login:"+clientData.Challenge)
+
+	if err != nil {
+		return nil, "", errors.New("challenge passkey tidak valid atau kedaluwarsa")
+	}
+
+	if !webauthnOriginAllowed(clientData.Origin) {
+		return nil, "", errInvalidPasskey
+	}
+
+	rawID, err := helper.DecodeBase64URL(login.RawID)
+
+	if err != nil {
+		return nil, "", errInvalidPasskey
+	}
+
+	credential, err := uc.WebauthnRepo.GetOneByCredentialID(ctx, rawID)
+
+	if err != nil {
+		return nil, "", errInvalidPasskey
+	}
+
+	// The ceremony was started for a specific user, the credential must
+	// belong to them
+	if expectedUserID != "0" && expectedUserID != strconv.Itoa(credential.UserID) {
+		return nil, "", errInvalidPasskey
+	}
+
+	if login.Response.UserHandle != "" && strings.TrimRight(login.Response.UserHandle, "=") != webauthnUserHandle(credential.UserID) {
+		return nil, "", errInvalidPasskey
+	}
+
+	rawAuthData, err := helper.DecodeBase64URL(login.Response.AuthenticatorData)
+
+	if err != nil {
+		return nil, "", errInvalidPasskey
+	}
+
+	authData, err := helper.ParseAuthenticatorData(rawAuthData)
+
+	if err != nil {
+		return nil, "", errInvalidPasskey
+	}
+
+	err = checkAuthenticatorData(authData)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	signature, err := helper.DecodeBase64URL(login.Response.Signature)
+
+	if err != nil {
+		return nil, "", errInvalidPasskey
+	}
+
+	err = helper.VerifyAssertionSignature(credential.PublicKey, rawAuthData, clientDataJSON, signature)
+
+	if err != nil {
+		return nil, "", errInvalidPasskey
+	}
+
+	// A counter that does not move forward hints at a cloned authenticator.
+	// Authenticators that do not count always report zero.
+	if authData.SignCount != 0 || credential.SignCount != 0 {
+		if authData.SignCount <= credential.SignCount {
+			return nil, "", errors.New("passkey terindikasi digandakan")
+		}
+	}
+
+	err = uc.WebauthnRepo.UpdateSignCount(ctx, credential.ID, authData.SignCount)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	user, err = uc.UserRepo.GetOneByID(ctx, credential.UserID)
+
+	if err != nil {
+		return nil, "", errInvalidPasskey
+	}
+
+	if os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true" && user.EmailVerifiedAt == nil {
+		return nil, "", errors.New("email belum diverifikasi")
+	}
+
+	uuidGenerate := uuid.NewString()
+
+	rememberSession := uc.UserRepo.RememberUUID(ctx, user, uuidGenerate)
+
+	if rememberSession != nil {
+		return nil, "", rememberSession
+	}
+
+	return user, uuidGenerate, nil
+}
+
+func webauthnOriginAllowed(origin string) bool {
+	return slices.Contains(strings.Split(os.Getenv("WEBAUTHN_ORIGINS"), ","), origin)
+}
+
+// checkAuthenticatorData verifies the parts of authenticator data shared by
+// both ceremonies: it is meant for our relying party and the user was there
+func checkAuthenticatorData(authData *helper.AuthenticatorData) error {
+	rpIDHash := sha256.Sum256([]byte(os.Getenv("WEBAUTHN_RP_ID")))
+
+	if !bytes.Equal(authData.RPIDHash, rpIDHash[:]) {
+		return errInvalidPasskey
+	}
+
+	if authData.Flags&helper.AuthenticatorFlagUserPresent == 0 {
+		return errInvalidPasskey
+	}
+
+	if webauthnUserVerification() == "required" && authData.Flags&helper.AuthenticatorFlagUserVerified == 0 {
+		return errors.New("passkey membutuhkan verifikasi pengguna")
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+// ActionClaims are the claims of single purpose tokens we mail to users, e.g.
+// to verify an email address. The ID is remembered server side so that a
+// token can only be used once.
+type ActionClaims struct {
+	Purpose string `json:"purpose"`
+	jwt.RegisteredClaims
+}
+
+func GenerateActionToken(purpose string, userID int, expire time.Duration) (token string, jti string, err error) {
+	jti = uuid.NewString()
+	// Set custom claims
+	claims := &ActionClaims{
+		purpose,
+		jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    Issuer(),
+			Subject:   strconv.Itoa(userID),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expire)),
+		},
+	}
+
+	// Generate encoded token
+	token, err = signToken(claims)
+	if err != nil {
+		return "", "", err
+	}
+
+	return token, jti, nil
+}
+
+func ParseActionToken(token string, purpose string) (claims *ActionClaims, err error) {
+	claims = &ActionClaims{}
+	err = NewVerifier("").Verify(token, claims)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// A token minted for one action must never be accepted for another
+	if claims.Purpose != purpose {
+		return nil, errors.New("token purpose mismatch")
+	}
+
+	return claims, nil
+}
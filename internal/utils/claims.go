@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"auth/internal/domain"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// Used when JWT_EXTRA_CLAIMS_MAX_BYTE is not set
+const defaultExtraClaimsMaxByte = 1024
+
+// enrichTimeout bounds the enrichers of one token, a slow lookup must not
+// hold up a login
+const enrichTimeout = 5 * time.Second
+
+// reservedClaims are claims enrichers may not set. They are registered, ours
+// or mean something to OpenID Connect clients.
+var reservedClaims = []string{
+	"iss", "sub", "aud", "exp", "nbf", "iat", "jti",
+	"uuid", "scope", "client_id", "act", "user",
+	"nonce", "auth_time", "azp", "cnf", "amr", "acr", "typ",
+	"email", "email_verified", "preferred_username", "groups", "is_admin",
+}
+
+// ReservedClaim reports whether name is a claim enrichers may not set
+func ReservedClaim(name string) bool {
+	return slices.Contains(reservedClaims, name)
+}
+
+// LoginContext is what an enricher knows about the session a token is signed
+// for
+type LoginContext struct {
+	Uuid     string
+	ClientID string
+	Scope    string
+	// Actor is the admin impersonating the user, if any
+	Actor string
+}
+
+// ClaimsEnricher adds claims to the session tokens of a user. Enrichers are
+// registered with UseClaimsEnrichers.
+type ClaimsEnricher interface {
+	Enrich(ctx context.Context, user *domain.User, login *LoginContext) (map[string]interface{}, error)
+}
+
+var (
+	claimsEnrichers     []ClaimsEnricher
+	claimsEnrichersLock sync.RWMutex
+)
+
+// UseClaimsEnrichers replaces the enrichers session tokens go through, they
+// run in the order given
+func UseClaimsEnrichers(enrichers ...ClaimsEnricher) {
+	claimsEnrichersLock.Lock()
+	defer claimsEnrichersLock.Unlock()
+	claimsEnrichers = enrichers
+}
+
+func currentClaimsEnrichers() []ClaimsEnricher {
+	claimsEnrichersLock.RLock()
+	defer claimsEnrichersLock.RUnlock()
+	return claimsEnrichers
+}
+
+// enrichClaims collects the claims of every enricher. The claims of an
+// enricher that fails, sets a reserved or already set claim or would take the
+// claims over JWT_EXTRA_CLAIMS_MAX_BYTE are left out, the token is still
+// issued without them. The enrichers give up with ctx, or after enrichTimeout.
+func enrichClaims(ctx context.Context, user *domain.User, login *LoginContext) map[string]interface{} {
+	enrichers := currentClaimsEnrichers()
+	if len(enrichers) == 0 {
+		return nil
+	}
+
+	maxByte, err := strconv.Atoi(os.Getenv("JWT_EXTRA_CLAIMS_MAX_BYTE"))
+	if err != nil || maxByte <= 0 {
+		maxByte = defaultExtraClaimsMaxByte
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, enrichTimeout)
+	defer cancel()
+
+	extra := map[string]interface{}{}
+
+	for _, enricher := range enrichers {
+		claims, err := enricher.Enrich(ctx, user, login)
+
+		if err != nil {
+			log.Printf("Enriching claims failed: %s", err)
+			continue
+		}
+
+		merged, err := mergeClaims(extra, claims)
+
+		if err != nil {
+			log.Printf("Enriching claims failed: %s", err)
+			continue
+		}
+
+		size, err := json.Marshal(merged)
+
+		if err != nil || len(size) > maxByte {
+			log.Printf("Enriching claims failed: claims exceed %d bytes", maxByte)
+			continue
+		}
+
+		extra = merged
+	}
+
+	return extra
+}
+
+// mergeClaims adds claims to a copy of extra
+func mergeClaims(extra map[string]interface{}, claims map[string]interface{}) (map[string]interface{}, error) {
+	merged := make(map[string]interface{}, len(extra)+len(claims))
+	for name, value := range extra {
+		merged[name] = value
+	}
+
+	for name, value := range claims {
+		if ReservedClaim(name) {
+			return nil, errors.New("claim " + name + " is reserved")
+		}
+		if _, ok := merged[name]; ok {
+			return nil, errors.New("claim " + name + " is already set")
+		}
+		merged[name] = value
+	}
+
+	return merged, nil
+}
+
+// StaticClaimsEnricher adds the same claims to every token
+type StaticClaimsEnricher struct {
+	Claims map[string]interface{}
+}
+
+func NewStaticClaimsEnricher(claims map[string]interface{}) *StaticClaimsEnricher {
+	return &StaticClaimsEnricher{Claims: claims}
+}
+
+func (e *StaticClaimsEnricher) Enrich(ctx context.Context, user *domain.User, login *LoginContext) (map[string]interface{}, error) {
+	return e.Claims, nil
+}
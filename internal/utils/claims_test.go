@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"auth/internal/domain"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+type failingEnricher struct{}
+
+func (failingEnricher) Enrich(ctx context.Context, user *domain.User, login *LoginContext) (map[string]interface{}, error) {
+	return nil, errors.New("lookup failed")
+}
+
+func useTestEnrichers(t *testing.T, enrichers ...ClaimsEnricher) {
+	t.Helper()
+
+	UseClaimsEnrichers(enrichers...)
+	t.Cleanup(func() { UseClaimsEnrichers() })
+}
+
+func tokenClaims(t *testing.T) jwt.MapClaims {
+	t.Helper()
+
+	token, err := GenerateToken(context.Background(), &domain.User{ID: 1}, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ParseToken(token.(string)); err != nil {
+		t.Fatal(err)
+	}
+
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(token.(string), claims); err != nil {
+		t.Fatal(err)
+	}
+
+	return claims
+}
+
+func TestEnrichedClaimsAreAdded(t *testing.T) {
+	t.Setenv("JWT_KEY", "secret")
+	t.Setenv("JWT_EXPIRE_HOUR", "1")
+
+	useTestEnrichers(t,
+		NewStaticClaimsEnricher(map[string]interface{}{"tenant_id": "acme"}),
+		NewStaticClaimsEnricher(map[string]interface{}{"roles": []string{"editor"}}),
+	)
+
+	claims := tokenClaims(t)
+
+	if claims["tenant_id"] != "acme" || claims["roles"] == nil {
+		t.Errorf("expected the enriched claims, got %v", claims)
+	}
+	if claims["uuid"] != "session" {
+		t.Errorf("expected the session claims to stay, got %v", claims)
+	}
+}
+
+func TestEnrichedClaimsAreLeftOut(t *testing.T) {
+	t.Setenv("JWT_KEY", "secret")
+	t.Setenv("JWT_EXPIRE_HOUR", "1")
+	t.Setenv("JWT_EXTRA_CLAIMS_MAX_BYTE", "64")
+
+	tests := []struct {
+		name     string
+		enricher ClaimsEnricher
+		// absent is a claim the enricher set that must be left out
+		absent string
+	}{
+		{"reserved", NewStaticClaimsEnricher(map[string]interface{}{"sub": "admin", "cohort": "b"}), "cohort"},
+		{"identity", NewStaticClaimsEnricher(map[string]interface{}{"is_admin": true}), "is_admin"},
+		{"oidc", NewStaticClaimsEnricher(map[string]interface{}{"email_verified": true}), "email_verified"},
+		{"already set", NewStaticClaimsEnricher(map[string]interface{}{"tenant_id": "other"}), ""},
+		{"over budget", NewStaticClaimsEnricher(map[string]interface{}{"bio": strings.Repeat("a", 64)}), "bio"},
+		{"failing", failingEnricher{}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestEnrichers(t,
+				NewStaticClaimsEnricher(map[string]interface{}{"tenant_id": "acme"}),
+				tt.enricher,
+			)
+
+			claims := tokenClaims(t)
+
+			if claims["tenant_id"] != "acme" {
+				t.Errorf("expected the other enrichers' claims to stay, got %v", claims)
+			}
+			if _, ok := claims[tt.absent]; tt.absent != "" && ok {
+				t.Errorf("expected claim %s to be left out, got %v", tt.absent, claims)
+			}
+			if claims["sub"] != nil {
+				t.Errorf("expected a reserved claim to be refused, got %v", claims)
+			}
+		})
+	}
+}
+
+// requestEnricher hands back a value of the context it is called with
+type requestEnricher struct{}
+
+type requestKey struct{}
+
+func (requestEnricher) Enrich(ctx context.Context, user *domain.User, login *LoginContext) (map[string]interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"request_id": ctx.Value(requestKey{})}, nil
+}
+
+func TestEnrichersRunWithTheRequestContext(t *testing.T) {
+	t.Setenv("JWT_KEY", "secret")
+	t.Setenv("JWT_EXPIRE_HOUR", "1")
+
+	useTestEnrichers(t, requestEnricher{})
+
+	ctx := context.WithValue(context.Background(), requestKey{}, "abc")
+	extra := enrichClaims(ctx, &domain.User{ID: 1}, &LoginContext{Uuid: "session"})
+
+	if extra["request_id"] != "abc" {
+		t.Errorf("expected the enricher to see the request context, got %v", extra)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if extra := enrichClaims(cancelled, &domain.User{ID: 1}, &LoginContext{Uuid: "session"}); len(extra) != 0 {
+		t.Errorf("expected a cancelled request to add no claims, got %v", extra)
+	}
+}
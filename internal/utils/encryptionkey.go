@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/go-jose/go-jose/v3"
+)
+
+// Algorithms session tokens can be encrypted with. The content is always
+// encrypted with A256GCM.
+const (
+	EncRSAOAEP = string(jose.RSA_OAEP)
+	EncECDHES  = string(jose.ECDH_ES)
+)
+
+// EncryptionKey is the recipient key session tokens are encrypted to. Every
+// service reading the claims needs the private key, the tokens are opaque to
+// everyone else.
+type EncryptionKey struct {
+	ID         string
+	Algorithm  string
+	PrivateKey crypto.Signer
+}
+
+// NewEncryptionKey checks that the key suits the algorithm. Without an id the
+// kid is the key's RFC 7638 thumbprint.
+func NewEncryptionKey(id string, algorithm string, privateKey crypto.Signer) (*EncryptionKey, error) {
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		if algorithm != EncRSAOAEP {
+			return nil, errors.New("rsa keys can only be used with " + EncRSAOAEP)
+		}
+		if key.N.BitLen() < minRSAKeyBits {
+			return nil, errors.New("rsa keys must be at least 2048 bits")
+		}
+	case *ecdsa.PrivateKey:
+		if algorithm != EncECDHES || key.Curve != elliptic.P256() {
+			return nil, errors.New("ecdsa keys must be P-256 and used with " + EncECDHES)
+		}
+	default:
+		return nil, errors.New("unsupported encryption key")
+	}
+
+	key := &EncryptionKey{
+		ID:         id,
+		Algorithm:  algorithm,
+		PrivateKey: privateKey,
+	}
+
+	if key.ID == "" {
+		jwk := jose.JSONWebKey{Key: privateKey.Public()}
+		thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+		if err != nil {
+			return nil, err
+		}
+		key.ID = base64.RawURLEncoding.EncodeToString(thumbprint)
+	}
+
+	return key, nil
+}
+
+// encryptionKey encrypts the session tokens we issue. Without one they are
+// only signed.
+var (
+	encryptionKey     *EncryptionKey
+	encryptionKeyLock sync.RWMutex
+)
+
+// UseEncryptionKey makes session tokens nested JWTs encrypted to key, nil
+// goes back to signed tokens. Tokens issued before still verify either way.
+func UseEncryptionKey(key *EncryptionKey) {
+	encryptionKeyLock.Lock()
+	defer encryptionKeyLock.Unlock()
+	encryptionKey = key
+}
+
+func currentEncryptionKey() *EncryptionKey {
+	encryptionKeyLock.RLock()
+	defer encryptionKeyLock.RUnlock()
+	return encryptionKey
+}
+
+// encryptToken wraps a signed token in a JWE, see RFC 7519 section 5.2
+func encryptToken(signed string) (string, error) {
+	key := currentEncryptionKey()
+	if key == nil {
+		return signed, nil
+	}
+
+	encrypter, err := jose.NewEncrypter(jose.A256GCM, jose.Recipient{
+		Algorithm: jose.KeyAlgorithm(key.Algorithm),
+		Key:       key.PrivateKey.Public(),
+		KeyID:     key.ID,
+	}, (&jose.EncrypterOptions{}).WithType("JWT").WithContentType("JWT"))
+	if err != nil {
+		return "", err
+	}
+
+	object, err := encrypter.Encrypt([]byte(signed))
+	if err != nil {
+		return "", err
+	}
+
+	return object.CompactSerialize()
+}
+
+// decryptToken unwraps an encrypted token, signed tokens are returned as they
+// are. The token may not choose how it is decrypted.
+func decryptToken(token string) (string, error) {
+	// A compact JWE has five parts, a JWS three
+	if strings.Count(token, ".") != 4 {
+		return token, nil
+	}
+
+	key := currentEncryptionKey()
+	if key == nil {
+		return "", ErrTokenInvalid
+	}
+
+	object, err := jose.ParseEncrypted(token)
+	if err != nil {
+		return "", ErrTokenMalformed
+	}
+
+	if object.Header.Algorithm != key.Algorithm || object.Header.ExtraHeaders["enc"] != string(jose.A256GCM) {
+		return "", ErrTokenInvalid
+	}
+
+	signed, err := object.Decrypt(key.PrivateKey)
+	if err != nil {
+		return "", ErrTokenInvalid
+	}
+
+	return string(signed), nil
+}
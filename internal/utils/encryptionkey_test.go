@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"auth/internal/domain"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func useTestEncryptionKey(t *testing.T, algorithm string, privateKey crypto.Signer) *EncryptionKey {
+	t.Helper()
+
+	key, err := NewEncryptionKey("", algorithm, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	UseEncryptionKey(key)
+	t.Cleanup(func() { UseEncryptionKey(nil) })
+
+	return key
+}
+
+func TestEncryptedTokenRoundTrip(t *testing.T) {
+	t.Setenv("JWT_KEY", "secret")
+	t.Setenv("JWT_EXPIRE_HOUR", "1")
+	t.Setenv("JWT_SESSION_MODE", SessionModeStateless)
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	cases := map[string]crypto.Signer{
+		EncRSAOAEP: rsaKey,
+		EncECDHES:  ecKey,
+	}
+
+	for algorithm, privateKey := range cases {
+		t.Run(algorithm, func(t *testing.T) {
+			useTestEncryptionKey(t, algorithm, privateKey)
+
+			token, err := GenerateToken(context.Background(), &domain.User{ID: 1, Email: "alice@example.com"}, "session")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if strings.Count(token.(string), ".") != 4 {
+				t.Fatalf("expected a compact JWE, got %s", token)
+			}
+
+			claims, err := ParseToken(token.(string))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.User == nil || claims.User.Email != "alice@example.com" {
+				t.Errorf("unexpected claims %+v", claims)
+			}
+		})
+	}
+}
+
+func TestEncryptedTokenNeedsTheKey(t *testing.T) {
+	t.Setenv("JWT_KEY", "secret")
+	t.Setenv("JWT_EXPIRE_HOUR", "1")
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	useTestEncryptionKey(t, EncECDHES, ecKey)
+
+	token, err := GenerateToken(context.Background(), &domain.User{ID: 1}, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	useTestEncryptionKey(t, EncECDHES, otherKey)
+	if _, err := ParseToken(token.(string)); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("expected another key to fail, got %v", err)
+	}
+
+	UseEncryptionKey(nil)
+	if _, err := ParseToken(token.(string)); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("expected no key to fail, got %v", err)
+	}
+}
+
+func TestSignedTokenStillAcceptedWithEncryption(t *testing.T) {
+	t.Setenv("JWT_KEY", "secret")
+	t.Setenv("JWT_EXPIRE_HOUR", "1")
+
+	token, err := GenerateToken(context.Background(), &domain.User{ID: 1}, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	useTestEncryptionKey(t, EncECDHES, ecKey)
+
+	if _, err := ParseToken(token.(string)); err != nil {
+		t.Errorf("expected a token signed before encryption was enabled to verify, got %v", err)
+	}
+}
+
+func TestEncryptionKeyMustSuitAlgorithm(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	if _, err := NewEncryptionKey("", EncECDHES, rsaKey); err == nil {
+		t.Error("expected an rsa key to be refused for ECDH-ES")
+	}
+}
@@ -2,6 +2,8 @@ package utils
 
 import (
 	"auth/internal/domain"
+	"context"
+	"encoding/json"
 	"os"
 	"strconv"
 	"time"
@@ -12,30 +14,194 @@ import (
 // jwtCustomClaims are custom claims extending default ones.
 // See https://github.com/golang-jwt/jwt for more examples
 type JwtCustomClaims struct {
-	Uuid string `json:"uuid"`
-	jwt.StandardClaims
+	Uuid     string `json:"uuid"`
+	Scope    string `json:"scope,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+	Act      *Actor `json:"act,omitempty"`
+	// User is the snapshot stateless sessions are served from
+	User *domain.User `json:"user,omitempty"`
+	// Extra are the claims of the enrichers, they sit next to the others
+	Extra map[string]interface{} `json:"-"`
+	jwt.RegisteredClaims
 }
 
-func GenerateToken(user *domain.User, uuid string) (token interface{}, err error) {
-	jwtHourExpire := os.Getenv("JWT_EXPIRE_HOUR")
-	convJwtHour, _ := strconv.Atoi(jwtHourExpire)
+// MarshalJSON flattens Extra into the claims
+func (c JwtCustomClaims) MarshalJSON() ([]byte, error) {
+	type claims JwtCustomClaims
+	data, err := json.Marshal(claims(c))
+	if err != nil || len(c.Extra) == 0 {
+		return data, err
+	}
+
+	own := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &own); err != nil {
+		return nil, err
+	}
+
+	merged := map[string]interface{}{}
+	for name, value := range c.Extra {
+		merged[name] = value
+	}
+	for name, value := range own {
+		merged[name] = value
+	}
+
+	return json.Marshal(merged)
+}
+
+// How sessions are looked up, set with JWT_SESSION_MODE
+const (
+	// SessionModeRedis reads the user from the Redis session on every
+	// request. It is the default.
+	SessionModeRedis = "redis"
+	// SessionModeStateless trusts the user in the token and only checks the
+	// jti denylist, so tokens can be verified offline
+	SessionModeStateless = "stateless"
+)
+
+// StatelessSessions reports whether session tokens carry the user
+func StatelessSessions() bool {
+	return os.Getenv("JWT_SESSION_MODE") == SessionModeStateless
+}
+
+// Actor is the RFC 8693 act claim, it names who is acting on behalf of the
+// user the token was issued to
+type Actor struct {
+	Subject string `json:"sub"`
+}
+
+func GenerateToken(ctx context.Context, user *domain.User, uuid string) (token interface{}, err error) {
+	return GenerateScopedToken(ctx, user, uuid, "", "")
+}
+
+// GenerateScopedToken is GenerateToken for sessions started through an OAuth
+// client, the token carries the client and the scope it was granted.
+func GenerateScopedToken(ctx context.Context, user *domain.User, uuid string, clientID string, scope string) (token interface{}, err error) {
 	// Set custom claims
 	claims := &JwtCustomClaims{
-		uuid,
-		jwt.StandardClaims{
-			Issuer:    "Auth Service",
-			ExpiresAt: time.Now().Add(time.Hour * time.Duration(convJwtHour)).Unix(),
-		},
+		Uuid:     uuid,
+		Scope:    scope,
+		ClientID: clientID,
+	}
+
+	return signSessionToken(ctx, claims, user, TokenExpire())
+}
+
+// GenerateImpersonationToken signs a token for a session an admin opened as
+// another user. The admin is named in the act claim.
+func GenerateImpersonationToken(ctx context.Context, user *domain.User, uuid string, admin *domain.User, expire time.Duration) (token interface{}, err error) {
+	claims := &JwtCustomClaims{
+		Uuid: uuid,
+		Act:  &Actor{Subject: strconv.Itoa(admin.ID)},
 	}
 
-	// Create token with claims
-	tokenJwt := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return signSessionToken(ctx, claims, user, expire)
+}
+
+// signSessionToken signs the token of session claims.Uuid. The uuid doubles
+// as jti, revoking the session denylists it.
+func signSessionToken(ctx context.Context, claims *JwtCustomClaims, user *domain.User, expire time.Duration) (token interface{}, err error) {
+	now := time.Now()
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        claims.Uuid,
+		Issuer:    Issuer(),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(expire)),
+	}
+	if audience := os.Getenv("JWT_AUDIENCE"); audience != "" {
+		claims.Audience = jwt.ClaimStrings{audience}
+	}
+	if StatelessSessions() {
+		claims.User = user
+	}
+	actor := ""
+	if claims.Act != nil {
+		actor = claims.Act.Subject
+	}
+	claims.Extra = enrichClaims(ctx, user, &LoginContext{
+		Uuid:     claims.Uuid,
+		ClientID: claims.ClientID,
+		Scope:    claims.Scope,
+		Actor:    actor,
+	})
 
 	// Generate encoded token and send it as response.
-	t, err := tokenJwt.SignedString([]byte(os.Getenv("JWT_KEY")))
+	t, err := signToken(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	// Claims such as the user snapshot should not be readable by whoever
+	// gets hold of the token
+	t, err = encryptToken(t)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, err
+}
+
+// IDTokenClaims are the claims of an OpenID Connect id_token
+type IDTokenClaims struct {
+	Nonce             string `json:"nonce,omitempty"`
+	AuthTime          int64  `json:"auth_time,omitempty"`
+	Name              string `json:"name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// GenerateIDToken signs an id_token for a client. The issuer is
+// APPLICATION_URL, the same one the discovery document advertises.
+func GenerateIDToken(info *domain.UserInfo, clientID string, nonce string, authTime int64) (token interface{}, err error) {
+	now := time.Now()
+
+	claims := &IDTokenClaims{
+		Nonce:             nonce,
+		AuthTime:          authTime,
+		Name:              info.Name,
+		PreferredUsername: info.PreferredUsername,
+		Email:             info.Email,
+		EmailVerified:     info.EmailVerified,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    os.Getenv("APPLICATION_URL"),
+			Subject:   info.Subject,
+			Audience:  jwt.ClaimStrings{clientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(TokenExpire())),
+		},
+	}
+
+	t, err := signToken(claims)
 	if err != nil {
 		return nil, err
 	}
 
 	return t, err
 }
+
+// ParseToken verifies a token from GenerateToken and returns its claims.
+// Encrypted tokens are decrypted first.
+func ParseToken(token string) (claims *JwtCustomClaims, err error) {
+	token, err = decryptToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	claims = &JwtCustomClaims{}
+	err = NewVerifier(os.Getenv("JWT_AUDIENCE")).Verify(token, claims)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// TokenExpire is the lifetime of the tokens GenerateToken signs
+func TokenExpire() time.Duration {
+	jwtHourExpire := os.Getenv("JWT_EXPIRE_HOUR")
+	convJwtHour, _ := strconv.Atoi(jwtHourExpire)
+	return time.Hour * time.Duration(convJwtHour)
+}
@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"auth/internal/domain"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestImpersonationTokenCarriesActor(t *testing.T) {
+	t.Setenv("JWT_KEY", "secret")
+
+	token, err := GenerateImpersonationToken(context.Background(), &domain.User{ID: 2}, "session", &domain.User{ID: 1}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := ParseToken(token.(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Uuid != "session" || claims.Act == nil || claims.Act.Subject != "1" {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+}
+
+func TestSessionTokenHasNoActor(t *testing.T) {
+	t.Setenv("JWT_KEY", "secret")
+	t.Setenv("JWT_EXPIRE_HOUR", "1")
+
+	token, err := GenerateToken(context.Background(), &domain.User{ID: 1}, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := ParseToken(token.(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Act != nil {
+		t.Errorf("unexpected act claim %+v", claims.Act)
+	}
+	if claims.IssuedAt == nil {
+		t.Error("expected iat to be set")
+	}
+}
+
+func TestStatelessTokenCarriesUser(t *testing.T) {
+	t.Setenv("JWT_KEY", "secret")
+	t.Setenv("JWT_EXPIRE_HOUR", "1")
+	t.Setenv("JWT_SESSION_MODE", SessionModeStateless)
+
+	token, err := GenerateToken(context.Background(), &domain.User{ID: 1, Username: "alice"}, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := ParseToken(token.(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.User == nil || claims.User.ID != 1 || claims.User.Username != "alice" {
+		t.Errorf("unexpected user claim %+v", claims.User)
+	}
+	if claims.ID != "session" {
+		t.Errorf("expected jti to be the session uuid, got %q", claims.ID)
+	}
+}
+
+func TestRedisSessionTokenHasNoUser(t *testing.T) {
+	t.Setenv("JWT_KEY", "secret")
+	t.Setenv("JWT_EXPIRE_HOUR", "1")
+	t.Setenv("JWT_SESSION_MODE", SessionModeRedis)
+
+	token, err := GenerateToken(context.Background(), &domain.User{ID: 1}, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := ParseToken(token.(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.User != nil {
+		t.Errorf("unexpected user claim %+v", claims.User)
+	}
+}
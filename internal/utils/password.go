@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"unicode"
+)
+
+// defaultPasswordMinLength is used when PASSWORD_MIN_LENGTH is not set
+const defaultPasswordMinLength = 8
+
+// CheckPasswordPolicy reports why a new password is not acceptable, or nil
+// when it is. Passwords need a minimum length and must mix letters and
+// digits.
+func CheckPasswordPolicy(password string) error {
+	minLength, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH"))
+	if err != nil || minLength <= 0 {
+		minLength = defaultPasswordMinLength
+	}
+
+	if len([]rune(password)) < minLength {
+		return errors.New("password minimal " + strconv.Itoa(minLength) + " karakter")
+	}
+
+	var hasLetter, hasDigit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			hasLetter = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		}
+	}
+
+	if !hasLetter || !hasDigit {
+		return errors.New("password harus mengandung huruf dan angka")
+	}
+
+	return nil
+}
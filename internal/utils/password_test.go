@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestCheckPasswordPolicy(t *testing.T) {
+	t.Setenv("PASSWORD_MIN_LENGTH", "")
+
+	cases := map[string]bool{
+		"pa55word":  true,
+		"pa55":      false,
+		"password":  false,
+		"12345678":  false,
+		"kata5andi": true,
+	}
+
+	for password, valid := range cases {
+		err := CheckPasswordPolicy(password)
+		if valid && err != nil {
+			t.Errorf("expected %q to be accepted, got %s", password, err)
+		}
+		if !valid && err == nil {
+			t.Errorf("expected %q to be rejected", password)
+		}
+	}
+}
+
+func TestCheckPasswordPolicyMinLength(t *testing.T) {
+	t.Setenv("PASSWORD_MIN_LENGTH", "12")
+
+	if err := CheckPasswordPolicy("pa55word"); err == nil {
+		t.Error("expected password shorter than PASSWORD_MIN_LENGTH to be rejected")
+	}
+
+	if err := CheckPasswordPolicy("pa55word1234"); err != nil {
+		t.Errorf("expected password to be accepted, got %s", err)
+	}
+}
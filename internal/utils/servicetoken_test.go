@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"auth/internal/domain"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseServiceToken(t *testing.T) {
+	t.Setenv("JWT_KEY", "secret")
+
+	token, err := GenerateServiceToken("billing", "invoices:read", []string{"ledger"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := ParseServiceToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.ClientID != "billing" || claims.Scope != "invoices:read" || claims.Subject != "billing" {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+	if len(claims.Audience) != 1 || claims.Audience[0] != "ledger" {
+		t.Errorf("unexpected audience %v", claims.Audience)
+	}
+}
+
+func TestParseServiceTokenRejectsUserToken(t *testing.T) {
+	t.Setenv("JWT_KEY", "secret")
+	t.Setenv("JWT_EXPIRE_HOUR", "1")
+
+	token, err := GenerateToken(context.Background(), &domain.User{ID: 1}, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ParseServiceToken(token.(string)); err == nil {
+		t.Fatal("expected a user token to be rejected")
+	}
+}
+
+func TestParseServiceTokenExpired(t *testing.T) {
+	t.Setenv("JWT_KEY", "secret")
+
+	token, err := GenerateServiceToken("billing", "", nil, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ParseServiceToken(token); err == nil {
+		t.Fatal("expected an expired token to be rejected")
+	}
+}
@@ -0,0 +1,263 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Algorithms tokens can be signed with. HS256 is the legacy mode, everyone
+// who verifies such a token can also forge one.
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+)
+
+// minRSAKeyBits is the smallest RSA key we sign with
+const minRSAKeyBits = 2048
+
+// SigningKey is an asymmetric key tokens are signed with. ID is sent as the
+// kid header so verifiers can pick the matching public key.
+type SigningKey struct {
+	ID         string
+	Algorithm  string
+	PrivateKey crypto.Signer
+}
+
+// NewSigningKey checks that the key suits the algorithm. Without an id the
+// kid is the key's RFC 7638 thumbprint.
+func NewSigningKey(id string, algorithm string, privateKey crypto.Signer) (*SigningKey, error) {
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		if algorithm != AlgRS256 {
+			return nil, errors.New("rsa keys can only be used with " + AlgRS256)
+		}
+		if key.N.BitLen() < minRSAKeyBits {
+			return nil, errors.New("rsa keys must be at least 2048 bits")
+		}
+	case *ecdsa.PrivateKey:
+		if algorithm != AlgES256 || key.Curve != elliptic.P256() {
+			return nil, errors.New("ecdsa keys must be P-256 and used with " + AlgES256)
+		}
+	case ed25519.PrivateKey:
+		if algorithm != AlgEdDSA {
+			return nil, errors.New("ed25519 keys can only be used with " + AlgEdDSA)
+		}
+	default:
+		return nil, errors.New("unsupported signing key")
+	}
+
+	key := &SigningKey{
+		ID:         id,
+		Algorithm:  algorithm,
+		PrivateKey: privateKey,
+	}
+
+	if key.ID == "" {
+		jwk := key.JWK()
+		thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+		if err != nil {
+			return nil, err
+		}
+		key.ID = base64.RawURLEncoding.EncodeToString(thumbprint)
+	}
+
+	return key, nil
+}
+
+// GeneratePrivateKey creates a new key for algorithm
+func GeneratePrivateKey(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case AlgRS256:
+		return rsa.GenerateKey(rand.Reader, minRSAKeyBits)
+	case AlgES256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgEdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return nil, errors.New("unsupported signing algorithm " + algorithm)
+	}
+}
+
+// ParsePrivateKeyPEM reads a PKCS#8, PKCS#1 or SEC 1 private key
+func ParsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+		return nil, errors.New("unsupported private key")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	return nil, errors.New("unsupported private key")
+}
+
+// JWK is the public half of the key as a JSON Web Key
+func (k *SigningKey) JWK() jose.JSONWebKey {
+	return jose.JSONWebKey{
+		Key:       k.PrivateKey.Public(),
+		KeyID:     k.ID,
+		Algorithm: k.Algorithm,
+		Use:       "sig",
+	}
+}
+
+// KeySet holds the keys tokens are signed and verified with
+type KeySet interface {
+	// SigningKey is the key new tokens are signed with
+	SigningKey() (*SigningKey, error)
+	// VerificationKey finds the key a token names in its kid header
+	VerificationKey(kid string) (*SigningKey, error)
+	// VerificationKeys are published for other services to verify with
+	VerificationKeys() []*SigningKey
+}
+
+// StaticKeySet is a fixed set of keys, the first one signs
+type StaticKeySet struct {
+	keys []*SigningKey
+}
+
+func NewStaticKeySet(keys ...*SigningKey) *StaticKeySet {
+	return &StaticKeySet{keys: keys}
+}
+
+func (s *StaticKeySet) SigningKey() (*SigningKey, error) {
+	if len(s.keys) == 0 {
+		return nil, errors.New("no signing key")
+	}
+	return s.keys[0], nil
+}
+
+func (s *StaticKeySet) VerificationKey(kid string) (*SigningKey, error) {
+	for _, key := range s.keys {
+		if key.ID == kid {
+			return key, nil
+		}
+	}
+	return nil, errors.New("unknown signing key")
+}
+
+func (s *StaticKeySet) VerificationKeys() []*SigningKey {
+	return s.keys
+}
+
+// keySet signs every token we issue. Without one tokens are signed with
+// HS256 and JWT_KEY.
+var (
+	keySet     KeySet
+	keySetLock sync.RWMutex
+)
+
+// UseKeySet switches token signing to asymmetric keys, nil goes back to the
+// legacy HS256 mode. It may be called again whenever the keys change.
+func UseKeySet(keys KeySet) {
+	keySetLock.Lock()
+	defer keySetLock.Unlock()
+	keySet = keys
+}
+
+func currentKeySet() KeySet {
+	keySetLock.RLock()
+	defer keySetLock.RUnlock()
+	return keySet
+}
+
+// SigningAlgorithm is the algorithm new tokens are signed with
+func SigningAlgorithm() string {
+	keySet := currentKeySet()
+	if keySet == nil {
+		return AlgHS256
+	}
+	key, err := keySet.SigningKey()
+	if err != nil {
+		return AlgHS256
+	}
+	return key.Algorithm
+}
+
+// JWKS returns the public keys tokens are verified with. It is empty in the
+// legacy HS256 mode, the shared secret is never published.
+func JWKS() *jose.JSONWebKeySet {
+	jwks := &jose.JSONWebKeySet{Keys: []jose.JSONWebKey{}}
+	keySet := currentKeySet()
+	if keySet == nil {
+		return jwks
+	}
+	for _, key := range keySet.VerificationKeys() {
+		jwks.Keys = append(jwks.Keys, key.JWK())
+	}
+	return jwks
+}
+
+// verificationKey returns what verifies a token signed with alg by the key
+// kid. The algorithm must be the one of the key, a token cannot choose how it
+// is checked.
+func verificationKey(kid string, alg string) (interface{}, error) {
+	keySet := currentKeySet()
+	if keySet == nil {
+		if alg != AlgHS256 {
+			return nil, errors.New("unexpected signing method")
+		}
+		return []byte(os.Getenv("JWT_KEY")), nil
+	}
+
+	key, err := keySet.VerificationKey(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if key.Algorithm != alg {
+		return nil, errors.New("unexpected signing method")
+	}
+
+	return key.PrivateKey.Public(), nil
+}
+
+// signToken signs claims with the current signing key
+func signToken(claims jwt.Claims) (string, error) {
+	keySet := currentKeySet()
+	if keySet == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(os.Getenv("JWT_KEY")))
+	}
+
+	key, err := keySet.SigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	tokenJwt := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	tokenJwt.Header["kid"] = key.ID
+
+	return tokenJwt.SignedString(key.PrivateKey)
+}
+
+// keyFunc looks up the key of a token for jwt.ParseWithClaims
+func keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	return verificationKey(kid, token.Method.Alg())
+}
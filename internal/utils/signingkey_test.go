@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"auth/internal/domain"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func useTestKey(t *testing.T, algorithm string, privateKey crypto.Signer) *SigningKey {
+	t.Helper()
+
+	key, err := NewSigningKey("", algorithm, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	UseKeySet(NewStaticKeySet(key))
+	t.Cleanup(func() { UseKeySet(nil) })
+
+	return key
+}
+
+func TestAsymmetricTokenRoundTrip(t *testing.T) {
+	t.Setenv("JWT_EXPIRE_HOUR", "1")
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	cases := map[string]crypto.Signer{
+		AlgRS256: rsaKey,
+		AlgES256: ecKey,
+		AlgEdDSA: edKey,
+	}
+
+	for algorithm, privateKey := range cases {
+		t.Run(algorithm, func(t *testing.T) {
+			key := useTestKey(t, algorithm, privateKey)
+
+			token, err := GenerateToken(context.Background(), &domain.User{ID: 1}, "session")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			parsed, _, err := new(jwt.Parser).ParseUnverified(token.(string), &JwtCustomClaims{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed.Header["kid"] != key.ID || parsed.Header["alg"] != algorithm {
+				t.Errorf("unexpected header %v", parsed.Header)
+			}
+
+			claims, err := ParseToken(token.(string))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.Uuid != "session" {
+				t.Errorf("unexpected claims %+v", claims)
+			}
+
+			jwks := JWKS()
+			if len(jwks.Key(key.ID)) != 1 || !jwks.Key(key.ID)[0].IsPublic() {
+				t.Errorf("expected the public key in the JWKS, got %+v", jwks)
+			}
+		})
+	}
+}
+
+func TestAsymmetricModeRejectsHS256(t *testing.T) {
+	t.Setenv("JWT_KEY", "secret")
+	t.Setenv("JWT_EXPIRE_HOUR", "1")
+
+	// Signed in legacy mode, before the key set is in use
+	token, err := GenerateToken(context.Background(), &domain.User{ID: 1}, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	useTestKey(t, AlgES256, ecKey)
+
+	if _, err := ParseToken(token.(string)); err == nil {
+		t.Fatal("expected an HS256 token to be rejected")
+	}
+}
+
+func TestNewSigningKeyRejectsMismatch(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if _, err := NewSigningKey("", AlgES256, ecKey); err == nil {
+		t.Error("expected a P-384 key to be rejected for ES256")
+	}
+
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	if _, err := NewSigningKey("", AlgRS256, edKey); err == nil {
+		t.Error("expected an ed25519 key to be rejected for RS256")
+	}
+}
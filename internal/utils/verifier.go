@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/exp/slices"
+)
+
+// Used when JWT_ISSUER is not set
+const defaultIssuer = "Auth Service"
+
+// Used when JWT_LEEWAY_SECOND is not set
+const defaultLeewaySecond = 30
+
+// Reasons a token is refused. Every error Verify returns is one of these.
+var (
+	ErrTokenMalformed   = errors.New("token is malformed")
+	ErrTokenInvalid     = errors.New("token is invalid")
+	ErrTokenExpired     = errors.New("token is expired")
+	ErrTokenNotValidYet = errors.New("token is not valid yet")
+	ErrTokenRevoked     = errors.New("token is revoked")
+)
+
+// verifiableClaims are claims embedding jwt.RegisteredClaims
+type verifiableClaims interface {
+	jwt.Claims
+	VerifyAudience(cmp string, req bool) bool
+	VerifyExpiresAt(cmp time.Time, req bool) bool
+	VerifyIssuedAt(cmp time.Time, req bool) bool
+	VerifyNotBefore(cmp time.Time, req bool) bool
+	VerifyIssuer(cmp string, req bool) bool
+}
+
+// Verifier checks every token we accept. The signature must be made with an
+// allowed algorithm by a key of the key set, exp is required and nbf and iat
+// are checked when present, all give or take Leeway for clock skew.
+type Verifier struct {
+	// Algorithms limits the accepted algorithms further, empty allows the
+	// one of whichever key signed the token
+	Algorithms []string
+	Issuer     string
+	// Audience is required in aud when set
+	Audience string
+	Leeway   time.Duration
+	Now      func() time.Time
+}
+
+// NewVerifier reads JWT_ISSUER, JWT_ALLOWED_ALGS and JWT_LEEWAY_SECOND.
+// audience may be empty for tokens not bound to one.
+func NewVerifier(audience string) *Verifier {
+	leeway, err := strconv.Atoi(os.Getenv("JWT_LEEWAY_SECOND"))
+	if err != nil || leeway < 0 {
+		leeway = defaultLeewaySecond
+	}
+
+	return &Verifier{
+		Algorithms: strings.Fields(os.Getenv("JWT_ALLOWED_ALGS")),
+		Issuer:     Issuer(),
+		Audience:   audience,
+		Leeway:     time.Second * time.Duration(leeway),
+		Now:        time.Now,
+	}
+}
+
+// Issuer is the iss claim of the tokens we sign for ourselves
+func Issuer() string {
+	if issuer := os.Getenv("JWT_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return defaultIssuer
+}
+
+// Verify checks token and fills claims
+func (v *Verifier) Verify(token string, claims verifiableClaims) error {
+	parser := jwt.NewParser(jwt.WithoutClaimsValidation())
+
+	_, err := parser.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		if len(v.Algorithms) > 0 && !slices.Contains(v.Algorithms, token.Method.Alg()) {
+			return nil, errors.New("unexpected signing method")
+		}
+		return keyFunc(token)
+	})
+
+	if errors.Is(err, jwt.ErrTokenMalformed) {
+		return ErrTokenMalformed
+	}
+	if err != nil {
+		return ErrTokenInvalid
+	}
+
+	now := v.Now()
+
+	if !claims.VerifyExpiresAt(now.Add(-v.Leeway), true) {
+		return ErrTokenExpired
+	}
+	if !claims.VerifyNotBefore(now.Add(v.Leeway), false) || !claims.VerifyIssuedAt(now.Add(v.Leeway), false) {
+		return ErrTokenNotValidYet
+	}
+	if !claims.VerifyIssuer(v.Issuer, true) {
+		return ErrTokenInvalid
+	}
+	if v.Audience != "" && !claims.VerifyAudience(v.Audience, true) {
+		return ErrTokenInvalid
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func signedTestToken(t *testing.T, method jwt.SigningMethod, claims *JwtCustomClaims) string {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(method, claims).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func testClaims(issuedAt time.Time, expire time.Duration) *JwtCustomClaims {
+	return &JwtCustomClaims{
+		Uuid: "session",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    defaultIssuer,
+			Audience:  jwt.ClaimStrings{"api"},
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
+			ExpiresAt: jwt.NewNumericDate(issuedAt.Add(expire)),
+		},
+	}
+}
+
+func TestVerifierChecksClaims(t *testing.T) {
+	t.Setenv("JWT_KEY", "secret")
+
+	now := time.Now()
+
+	noExpiry := testClaims(now, time.Minute)
+	noExpiry.ExpiresAt = nil
+
+	wrongIssuer := testClaims(now, time.Minute)
+	wrongIssuer.Issuer = "Someone Else"
+
+	tests := []struct {
+		name   string
+		token  string
+		verify Verifier
+		err    error
+	}{
+		{"valid", signedTestToken(t, jwt.SigningMethodHS256, testClaims(now, time.Minute)), Verifier{Issuer: defaultIssuer, Audience: "api"}, nil},
+		{"expired within leeway", signedTestToken(t, jwt.SigningMethodHS256, testClaims(now.Add(-time.Minute), 50*time.Second)), Verifier{Issuer: defaultIssuer, Leeway: 30 * time.Second}, nil},
+		{"expired beyond leeway", signedTestToken(t, jwt.SigningMethodHS256, testClaims(now.Add(-time.Minute), 20*time.Second)), Verifier{Issuer: defaultIssuer, Leeway: 30 * time.Second}, ErrTokenExpired},
+		{"missing exp", signedTestToken(t, jwt.SigningMethodHS256, noExpiry), Verifier{Issuer: defaultIssuer}, ErrTokenExpired},
+		{"issued in the future", signedTestToken(t, jwt.SigningMethodHS256, testClaims(now.Add(time.Minute), time.Minute)), Verifier{Issuer: defaultIssuer, Leeway: 30 * time.Second}, ErrTokenNotValidYet},
+		{"wrong issuer", signedTestToken(t, jwt.SigningMethodHS256, wrongIssuer), Verifier{Issuer: defaultIssuer}, ErrTokenInvalid},
+		{"wrong audience", signedTestToken(t, jwt.SigningMethodHS256, testClaims(now, time.Minute)), Verifier{Issuer: defaultIssuer, Audience: "admin"}, ErrTokenInvalid},
+		{"algorithm not allowed", signedTestToken(t, jwt.SigningMethodHS256, testClaims(now, time.Minute)), Verifier{Issuer: defaultIssuer, Algorithms: []string{AlgRS256}}, ErrTokenInvalid},
+		{"algorithm of another key", signedTestToken(t, jwt.SigningMethodHS512, testClaims(now, time.Minute)), Verifier{Issuer: defaultIssuer}, ErrTokenInvalid},
+		{"malformed", "not.a.token", Verifier{Issuer: defaultIssuer}, ErrTokenMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier := tt.verify
+			verifier.Now = func() time.Time { return now }
+
+			err := verifier.Verify(tt.token, &JwtCustomClaims{})
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestNewVerifierReadsEnv(t *testing.T) {
+	t.Setenv("JWT_ISSUER", "https://auth.example.com")
+	t.Setenv("JWT_ALLOWED_ALGS", "RS256 ES256")
+	t.Setenv("JWT_LEEWAY_SECOND", "5")
+
+	verifier := NewVerifier("api")
+
+	if verifier.Issuer != "https://auth.example.com" || verifier.Audience != "api" || verifier.Leeway != 5*time.Second {
+		t.Errorf("unexpected verifier %+v", verifier)
+	}
+	if len(verifier.Algorithms) != 2 || verifier.Algorithms[0] != AlgRS256 {
+		t.Errorf("unexpected algorithms %v", verifier.Algorithms)
+	}
+}